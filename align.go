@@ -0,0 +1,42 @@
+package logfmt
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// NewAlignedEncoder returns a new encoder that writes to w with the keys of
+// each record padded to a common width, for human readers of debug output in
+// a terminal. Key/value pairs are buffered until EndRecord, which pads each
+// key with spaces to the width, in runes, of the longest key of the record,
+// so that a record with the keys "level", "msg", and "time" is written as
+//
+//	level=info msg  =hello time =12:00:00
+//
+// and values line up across records with the same keys. Values are quoted
+// as usual; only the padding is added. The output is therefore not strict
+// logfmt, as it contains spaces between keys and the '=' that follows, and
+// it is not intended to be decoded.
+func NewAlignedEncoder(w io.Writer) *Encoder {
+	enc := NewEncoder(w)
+	enc.align = true
+	return enc
+}
+
+// keyWidth returns the width of the widest key of the pending pairs.
+func (enc *Encoder) keyWidth() int {
+	width := 0
+	for _, p := range enc.pending.pairs {
+		if n := utf8.RuneCount(enc.pending.key(p)); n > width {
+			width = n
+		}
+	}
+	return width
+}
+
+// writePadding writes the spaces that pad key to width.
+func (enc *Encoder) writePadding(key []byte, width int) {
+	for n := utf8.RuneCount(key); n < width; n++ {
+		enc.scratch.WriteByte(' ')
+	}
+}
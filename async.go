@@ -0,0 +1,112 @@
+package logfmt
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrEncoderStopped is returned by Encoder methods of an encoder returned by
+// NewAsyncEncoder after its stop function has been called.
+var ErrEncoderStopped = errors.New("async encoder stopped")
+
+// NewAsyncEncoder returns a new encoder that writes to w from a background
+// goroutine, so that producers of records are not delayed by a slow w, and
+// a function that stops the goroutine.
+//
+// Key/value pairs are buffered until EndRecord, which queues the complete
+// record and returns without waiting for it to be written. Up to queueSize
+// records may be queued; when the queue is full EndRecord blocks until the
+// goroutine has taken a record from the queue. Records are never dropped,
+// so the queue size bounds memory use and burst absorption, not loss.
+// Reset discards the buffered pairs.
+//
+// If w returns an error, that record and any records queued after it are
+// discarded, and subsequent calls to EndRecord return the error.
+//
+// The stop function waits for all queued records to be written, stops the
+// goroutine, and returns the first error returned by w, if any. It must be
+// called once the encoder is no longer in use, and not concurrently with
+// Encoder methods. After it is called, EndRecord returns ErrEncoderStopped.
+// Calling it more than once returns the same result.
+func NewAsyncEncoder(w io.Writer, queueSize int) (*Encoder, func() error) {
+	aw := &asyncWriter{
+		w:     w,
+		queue: make(chan *[]byte, queueSize),
+		done:  make(chan struct{}),
+	}
+	go aw.run()
+	enc := NewEncoder(aw)
+	enc.framer = aw
+	return enc, aw.stop
+}
+
+type asyncWriter struct {
+	w     io.Writer
+	buf   []byte
+	queue chan *[]byte
+	done  chan struct{}
+	pool  sync.Pool
+
+	mu  sync.Mutex
+	err error
+
+	stopped bool
+}
+
+func (aw *asyncWriter) Write(p []byte) (int, error) {
+	aw.buf = append(aw.buf, p...)
+	return len(p), nil
+}
+
+func (aw *asyncWriter) discard() {
+	aw.buf = aw.buf[:0]
+}
+
+func (aw *asyncWriter) endRecord() error {
+	if aw.stopped {
+		aw.discard()
+		return ErrEncoderStopped
+	}
+	if err := aw.writeErr(); err != nil {
+		aw.discard()
+		return err
+	}
+	rec, _ := aw.pool.Get().(*[]byte)
+	if rec == nil {
+		rec = new([]byte)
+	}
+	*rec = append(append((*rec)[:0], aw.buf...), newline...)
+	aw.discard()
+	aw.queue <- rec
+	return nil
+}
+
+func (aw *asyncWriter) run() {
+	defer close(aw.done)
+	for rec := range aw.queue {
+		if aw.writeErr() == nil {
+			if _, err := aw.w.Write(*rec); err != nil {
+				aw.mu.Lock()
+				aw.err = err
+				aw.mu.Unlock()
+			}
+		}
+		aw.pool.Put(rec)
+	}
+}
+
+func (aw *asyncWriter) writeErr() error {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	return aw.err
+}
+
+func (aw *asyncWriter) stop() error {
+	if !aw.stopped {
+		aw.stopped = true
+		close(aw.queue)
+	}
+	<-aw.done
+	return aw.writeErr()
+}
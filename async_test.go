@@ -0,0 +1,107 @@
+package logfmt_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestAsyncEncoder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc, stop := logfmt.NewAsyncEncoder(buf, 2)
+	for i := 0; i < 5; i++ {
+		if err := enc.EncodeKeyvals("i", i, "msg", "a b"); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EndRecord(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.EncodeKeyval("discarded", 1); err != nil {
+		t.Fatal(err)
+	}
+	enc.Reset()
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "i=0 msg=\"a b\"\ni=1 msg=\"a b\"\ni=2 msg=\"a b\"\ni=3 msg=\"a b\"\ni=4 msg=\"a b\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := enc.EncodeKeyval("late", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndRecord(); err != logfmt.ErrEncoderStopped {
+		t.Errorf("EndRecord after stop: got %v, want %v", err, logfmt.ErrEncoderStopped)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("second stop: got %v, want nil", err)
+	}
+}
+
+func TestAsyncEncoderBlocks(t *testing.T) {
+	w := &gatedWriter{gate: make(chan struct{})}
+	enc, stop := logfmt.NewAsyncEncoder(w, 1)
+
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+		for i := 0; i < 3; i++ {
+			enc.EncodeKeyval("i", i)
+			enc.EndRecord()
+		}
+	}()
+
+	// The writer holds the first record and the queue the second, so the
+	// third EndRecord blocks until the writer proceeds.
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-ended:
+		t.Fatal("EndRecord did not block with a full queue")
+	default:
+	}
+	close(w.gate)
+	<-ended
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.buf.String(), "i=0\ni=1\ni=2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAsyncEncoderWriteError(t *testing.T) {
+	errWrite := errors.New("write failed")
+	enc, stop := logfmt.NewAsyncEncoder(errWriter{errWrite}, 0)
+
+	enc.EncodeKeyval("a", 1)
+	if err := enc.EndRecord(); err != nil {
+		t.Fatalf("first EndRecord: got %v, want nil", err)
+	}
+	if err := stop(); err != errWrite {
+		t.Errorf("stop: got %v, want %v", err, errWrite)
+	}
+}
+
+type gatedWriter struct {
+	gate chan struct{}
+	buf  bytes.Buffer
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	return w.buf.Write(p)
+}
+
+type errWriter struct {
+	err error
+}
+
+func (w errWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
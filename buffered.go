@@ -0,0 +1,56 @@
+package logfmt
+
+import "io"
+
+// NewBufferedEncoder returns a new encoder that writes to w one record at a
+// time. Key/value pairs are buffered until EndRecord, which writes the record
+// and its terminating newline with a single call to w.Write, greatly reducing
+// the number of writes to unbuffered writers such as files and network
+// connections. Reset discards the buffered pairs.
+//
+// If w returns an error, or writes only part of the record without
+// returning an error, EndRecord returns the error, or io.ErrShortWrite, and
+// the record is discarded.
+func NewBufferedEncoder(w io.Writer) *Encoder {
+	bw := &bufferWriter{w: w}
+	enc := NewEncoder(bw)
+	enc.framer = bw
+	return enc
+}
+
+// Bytes returns the encoded pairs of the current record that have not yet
+// been written, if enc was returned by NewBufferedEncoder, and nil
+// otherwise. The record may be inspected, or modified in place, before it is
+// written by EndRecord. Pairs held back by options that order the record,
+// such as SortKeys, are not included until EndRecord. The slice is only
+// valid until the next call of an Encoder method.
+func (enc *Encoder) Bytes() []byte {
+	if bw, ok := enc.framer.(*bufferWriter); ok {
+		return bw.buf
+	}
+	return nil
+}
+
+type bufferWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (bw *bufferWriter) Write(p []byte) (int, error) {
+	bw.buf = append(bw.buf, p...)
+	return len(p), nil
+}
+
+func (bw *bufferWriter) discard() {
+	bw.buf = bw.buf[:0]
+}
+
+func (bw *bufferWriter) endRecord() error {
+	bw.buf = append(bw.buf, '\n')
+	n, err := bw.w.Write(bw.buf)
+	if err == nil && n < len(bw.buf) {
+		err = io.ErrShortWrite
+	}
+	bw.discard()
+	return err
+}
@@ -0,0 +1,97 @@
+package logfmt_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+// countingWriter records each call to Write.
+type countingWriter struct {
+	writes []string
+	n      int
+	err    error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	if w.err != nil || w.n > 0 {
+		return w.n, w.err
+	}
+	return len(p), nil
+}
+
+func TestBufferedEncoder(t *testing.T) {
+	w := &countingWriter{}
+	enc := logfmt.NewBufferedEncoder(w)
+	if err := enc.EncodeKeyvals("a", 1, "b", "two words", "c", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.writes) != 0 {
+		t.Fatalf("got writes %q before EndRecord", w.writes)
+	}
+	if got, want := string(enc.Bytes()), `a=1 b="two words" c=null`; got != want {
+		t.Errorf("Bytes: got %q, want %q", got, want)
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EncodeKeyval("d", 4); err != nil {
+		t.Fatal(err)
+	}
+	enc.Reset()
+	if len(enc.Bytes()) != 0 {
+		t.Errorf("Bytes after Reset: got %q, want empty", enc.Bytes())
+	}
+	if err := enc.EncodeKeyval("e", 5); err != nil {
+		t.Fatal(err)
+	}
+	enc.Bytes()[2] = '6'
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a=1 b=\"two words\" c=null\n", "e=6\n"}
+	if len(w.writes) != len(want) {
+		t.Fatalf("got writes %q, want %q", w.writes, want)
+	}
+	for i := range want {
+		if w.writes[i] != want[i] {
+			t.Errorf("write %d: got %q, want %q", i, w.writes[i], want[i])
+		}
+	}
+}
+
+func TestBufferedEncoderWriteError(t *testing.T) {
+	errWrite := errors.New("write error")
+	for _, w := range []*countingWriter{{err: errWrite}, {n: 2}} {
+		enc := logfmt.NewBufferedEncoder(w)
+		if err := enc.EncodeKeyval("k", "value"); err != nil {
+			t.Fatal(err)
+		}
+		want := w.err
+		if want == nil {
+			want = io.ErrShortWrite
+		}
+		if err := enc.EndRecord(); err != want {
+			t.Errorf("got %v, want %v", err, want)
+		}
+		if len(enc.Bytes()) != 0 {
+			t.Errorf("Bytes after failed EndRecord: got %q, want empty", enc.Bytes())
+		}
+	}
+}
+
+func TestEncoderBytesUnbuffered(t *testing.T) {
+	var buf bytes.Buffer
+	enc := logfmt.NewEncoder(&buf)
+	if err := enc.EncodeKeyval("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if got := enc.Bytes(); got != nil {
+		t.Errorf("got %q, want nil", got)
+	}
+}
@@ -0,0 +1,70 @@
+package logfmt
+
+import "context"
+
+type fieldsKey struct{}
+
+// WithFields returns a copy of ctx that carries keyvals, a variadic sequence
+// of alternating keys and values, in addition to any fields already carried
+// by ctx. Fields are written by Encoder.EncodeKeyvalCtx.
+func WithFields(ctx context.Context, keyvals ...interface{}) context.Context {
+	if len(keyvals)%2 == 1 {
+		keyvals = append(keyvals, nil)
+	}
+	parent := Fields(ctx)
+	fields := make([]interface{}, 0, len(parent)+len(keyvals))
+	fields = append(fields, parent...)
+	fields = append(fields, keyvals...)
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// Fields returns the alternating keys and values carried by ctx, as added by
+// WithFields, or nil if there are none. The returned slice must not be
+// modified.
+func Fields(ctx context.Context) []interface{} {
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	return fields
+}
+
+// EncodeKeyvalCtx writes the logfmt encoding of key and value to the stream
+// like EncodeKeyval. If it is the first pair of a record, the fields carried
+// by ctx, as added by WithFields, are written first, as if by EncodeKeyvals.
+// Fields are written once per record; later calls for the same record write
+// only key and value. Nothing is written if a non-nil error is returned.
+func (enc *Encoder) EncodeKeyvalCtx(ctx context.Context, key, value interface{}) error {
+	enc.lock()
+	defer enc.unlock()
+	if enc.needSep {
+		return encodeError(key, enc.encodeKeyval(key, value))
+	}
+	if enc.ElapsedKey != "" && enc.start.IsZero() {
+		enc.start = enc.clock()
+	}
+	enc.pairs.reset()
+	if err := enc.appendFields(&enc.pairs, Fields(ctx)); err != nil {
+		return err
+	}
+	if err := enc.appendPairs(&enc.pairs, key, value); err != nil {
+		return encodeError(key, err)
+	}
+	return enc.writePairs(&enc.pairs)
+}
+
+// appendFields appends the pairs of keyvals to pl, handling keys and values
+// of unsupported type as EncodeKeyvals does.
+func (enc *Encoder) appendFields(pl *pairList, keyvals []interface{}) error {
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		k, v := keyvals[i], keyvals[i+1]
+		err := enc.appendPairs(pl, k, v)
+		if err == ErrUnsupportedKeyType {
+			continue
+		}
+		if isValueError(err) {
+			err = enc.appendPairs(pl, k, err)
+		}
+		if err != nil {
+			return encodeError(k, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package logfmt_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestWithFields(t *testing.T) {
+	ctx := context.Background()
+	if got := logfmt.Fields(ctx); got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+
+	parent := logfmt.WithFields(ctx, "trace", "abc")
+	child := logfmt.WithFields(parent, "span", 2, "odd")
+	if got, want := logfmt.Fields(parent), []interface{}{"trace", "abc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parent: got %v, want %v", got, want)
+	}
+	if got, want := logfmt.Fields(child), []interface{}{"trace", "abc", "span", 2, "odd", nil}; !reflect.DeepEqual(got, want) {
+		t.Errorf("child: got %v, want %v", got, want)
+	}
+}
+
+func TestEncodeKeyvalCtx(t *testing.T) {
+	ctx := logfmt.WithFields(context.Background(), "trace", "abc", "ns", "api")
+
+	buf := &bytes.Buffer{}
+	enc := logfmt.NewEncoder(buf)
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check(enc.EncodeKeyvalCtx(ctx, "a", 1))
+	check(enc.EncodeKeyvalCtx(ctx, "b", 2))
+	check(enc.EndRecord())
+	check(enc.EncodeKeyvalCtx(context.Background(), "c", 3))
+	check(enc.EndRecord())
+	check(enc.EncodeKeyval("d", 4))
+	check(enc.EncodeKeyvalCtx(ctx, "e", 5))
+	check(enc.EndRecord())
+
+	want := "trace=abc ns=api a=1 b=2\nc=3\nd=4 e=5\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestEncodeKeyvalCtxError(t *testing.T) {
+	ctx := logfmt.WithFields(context.Background(), "trace", "abc", "ns", "api")
+	for _, d := range []struct {
+		key, value interface{}
+		err        error
+	}{
+		{key: nil, value: 1, err: logfmt.ErrNilKey},
+		{key: "a", value: make(chan int), err: logfmt.ErrUnsupportedValueType},
+	} {
+		buf := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(buf)
+		if err := enc.EncodeKeyvalCtx(ctx, d.key, d.value); !errors.Is(err, d.err) {
+			t.Errorf("%v=%v: got error %v, want %v", d.key, d.value, err, d.err)
+		}
+		if got := buf.String(); got != "" {
+			t.Errorf("%v=%v: got %q written, want nothing", d.key, d.value, got)
+		}
+		if err := enc.EncodeKeyvalCtx(ctx, "b", 2); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), "trace=abc ns=api b=2"; got != want {
+			t.Errorf("%v=%v: got %q, want %q", d.key, d.value, got, want)
+		}
+	}
+}
@@ -32,3 +32,36 @@ func BenchmarkDecodeKeyval(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkDecodeKeyvalCopyTokens(b *testing.B) {
+	const rows = 10000
+	data := []byte{}
+	for i := 0; i < rows; i++ {
+		data = append(data, "a=1 b=\"bar\" ƒ=2h3s r=\"esc\\tmore stuff\" d x=sf   \n"...)
+	}
+
+	for _, release := range []bool{false, true} {
+		name := "NoRelease"
+		if release {
+			name = "Release"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				dec := NewDecoder(bytes.NewReader(data))
+				dec.CopyTokens = true
+				for dec.ScanRecord() {
+					for dec.ScanKeyval() {
+					}
+					if release {
+						dec.Release()
+					}
+				}
+				if err := dec.Err(); err != nil {
+					b.Errorf("got %v, want %v", err, nil)
+				}
+			}
+		})
+	}
+}
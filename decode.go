@@ -3,19 +3,137 @@ package logfmt
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
+	"unicode"
 	"unicode/utf8"
 )
 
 // A Decoder reads and decodes logfmt records from an input stream.
 type Decoder struct {
-	pos     int
-	key     []byte
-	value   []byte
-	lineNum int
-	s       *bufio.Scanner
-	err     error
+	// KeyValueDelimiters lists the bytes recognized as the separator between
+	// a key and its value. If nil, only '=' is recognized. Each delimiter
+	// must be a printable ASCII byte other than '"'; ScanRecord fails with
+	// ErrInvalidDelimiter otherwise. Delimiters other than '=' are permitted
+	// within unquoted values.
+	KeyValueDelimiters []byte
+
+	// LineTransform, if non-nil, is called with each raw line read from the
+	// input before it is scanned for key/value pairs. The returned bytes are
+	// scanned in place of the line. The argument is only valid until
+	// LineTransform returns, and the returned slice must remain valid and
+	// unmodified until the next call to ScanRecord.
+	LineTransform func(line []byte) []byte
+
+	// DoubledQuoteEscape selects CSV style escaping of quoted values. When
+	// true, a pair of double quotes within a quoted value decodes as a single
+	// literal double quote, a lone double quote terminates the value, and
+	// backslashes have no special meaning.
+	DoubledQuoteEscape bool
+
+	// MaxEscapes, if positive, limits the number of escape sequences
+	// permitted in a single quoted value. A value with more escape sequences
+	// causes a SyntaxError, bounding the work spent unquoting untrusted input.
+	MaxEscapes int
+
+	// InlineCommentMarker, if not empty, starts a comment that extends to the
+	// end of the line. The marker is only recognized at the start of a token,
+	// that is at the start of the line or following whitespace, so a marker
+	// within a key or value does not start a comment.
+	InlineCommentMarker []byte
+
+	// RejectNonPrintableKeys causes keys that contain runes that are not
+	// printable, as defined by unicode.IsPrint, to be reported as syntax
+	// errors. Such runes include zero width and other format characters.
+	RejectNonPrintableKeys bool
+
+	// CopyTokens causes the slices returned by Key and Value to be copies
+	// that remain valid after the next call to ScanRecord. The copies for a
+	// record share a buffer obtained from a pool. Calling Release when done
+	// with the record's keys and values returns the buffer to the pool for
+	// reuse; the slices must not be used after Release. A buffer that is not
+	// released is left to the garbage collector.
+	CopyTokens bool
+
+	// CoalesceDuplicates causes the Decoder to remember the key/value pairs
+	// of the current record as they are scanned, so that all values of a
+	// repeated key are available from MultiValue.
+	CoalesceDuplicates bool
+
+	// Base64Keys, if not nil, lists keys whose values are base64 encoded.
+	// Their values are decoded and Value returns the decoded bytes. Both the
+	// standard and URL-safe alphabets are accepted, with or without padding.
+	// A value that is not valid base64 causes a SyntaxError.
+	Base64Keys map[string]bool
+
+	// TimeKeys maps keys to the layouts used by ValueTime to parse their
+	// values. Keys not present are parsed using time.RFC3339.
+	TimeKeys map[string]string
+
+	// KeyAliases, if not nil, maps keys to canonical names. A scanned key
+	// found in KeyAliases is replaced by its canonical name, which is then
+	// returned by Key and used to look up Base64Keys and TimeKeys. Aliases
+	// are not chained: the canonical name is not itself looked up.
+	KeyAliases map[string]string
+
+	// MaxKeysPerRecord, if positive, limits the number of distinct keys
+	// permitted in a single record. A record with more distinct keys causes
+	// a SyntaxError, protecting consumers that build a map of each record
+	// from untrusted input. Keys are compared after applying KeyAliases.
+	MaxKeysPerRecord int
+
+	pos        int
+	keyStart   int
+	keyEnd     int
+	valueStart int
+	line       []byte
+	key        []byte
+	value      []byte
+	lineNum    int
+	spanned    int
+	records    int
+	keyvals    int
+	s          *bufio.Scanner
+	buf        []byte
+	maxSize    int
+	split      bufio.SplitFunc
+	recordSep  byte
+	lenient    func(err *SyntaxError)
+	rejectDups bool
+	quotedKeys bool
+	multiline  bool
+	trimValues bool
+	onRecord   func(lineNum int)
+	separators []byte
+	linePrefix func(line []byte) ([]byte, bool)
+	intern     func([]byte) string
+	nullAsNil  bool
+	sqValues   bool
+	onToken    func(ev TokenEvent)
+	tokenEnd   int
+	joined     []byte
+	raw        []byte
+	comment    []byte
+	err        error
+
+	// advanced reports that a RecordReader has already advanced the
+	// Decoder, and advancedOK holds the result, for the next ScanRecord.
+	advanced   bool
+	advancedOK bool
+
+	tokens *[]byte
+	pairs  [][2][]byte
+	keys   [][]byte
+}
+
+var tokenBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
 }
 
 // NewDecoder returns a new decoder that reads from r.
@@ -23,10 +141,7 @@ type Decoder struct {
 // The decoder introduces its own buffering and may read data from r beyond
 // the logfmt records requested.
 func NewDecoder(r io.Reader) *Decoder {
-	dec := &Decoder{
-		s: bufio.NewScanner(r),
-	}
-	return dec
+	return newDecoder(r, make([]byte, 0, startBufSize), bufio.MaxScanTokenSize)
 }
 
 // NewDecoderSize returns a new decoder that reads from r.
@@ -36,50 +151,685 @@ func NewDecoder(r io.Reader) *Decoder {
 // The size argument specifies the size of the initial buffer that the
 // Decoder will use to read records from r.
 // If a log line is longer than the size argument, the Decoder will return
-// a bufio.ErrTooLong error.
+// a SyntaxError that wraps bufio.ErrTooLong. It is equivalent to calling
+// SetMaxLineLength(size) on a decoder returned by NewDecoder.
 func NewDecoderSize(r io.Reader, size int) *Decoder {
-	scanner := bufio.NewScanner(r)
-	scanner.Buffer(make([]byte, 0, size), size)
+	return newDecoder(r, make([]byte, 0, size), size)
+}
+
+// NewReaderDecoder returns a new decoder that reads from r and, unlike one
+// returned by NewDecoder, does not limit the length of a record, so that
+// legitimately large records, such as those with embedded payloads, never
+// cause a "line too long" error.
+//
+// Each record is held in memory in its entirety while it is decoded, and the
+// decoder's buffer grows to the size of the largest record read and is not
+// shrunk, so the memory used is bounded only by the input. A decoder for
+// untrusted input should set a limit with SetMaxLineLength or use
+// NewDecoder instead.
+//
+// The decoder introduces its own buffering and may read data from r beyond
+// the logfmt records requested.
+func NewReaderDecoder(r io.Reader) *Decoder {
+	return newDecoder(r, make([]byte, 0, startBufSize), maxInt)
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// startBufSize is the size of the initial buffer allocated by NewDecoder,
+// which grows as needed up to bufio.MaxScanTokenSize.
+const startBufSize = 4096
+
+func newDecoder(r io.Reader, buf []byte, maxSize int) *Decoder {
 	dec := &Decoder{
-		s: scanner,
+		s:         bufio.NewScanner(r),
+		buf:       buf,
+		maxSize:   maxSize,
+		recordSep: '\n',
+		keyStart:  -1,
 	}
+	dec.s.Buffer(buf, maxSize)
 	return dec
 }
 
+// SetMaxLineLength sets the maximum length of a line, including its
+// terminating newline, to n bytes, and allocates an initial buffer of that
+// size. By default the buffer starts small and grows as needed up to
+// bufio.MaxScanTokenSize. When a line is too long, decoding stops and Err
+// returns a SyntaxError with the message "line too long" that wraps
+// bufio.ErrTooLong. The limit is kept by Reset. SetMaxLineLength panics if it
+// is called after scanning has started.
+func (dec *Decoder) SetMaxLineLength(n int) {
+	dec.buf = make([]byte, 0, n)
+	dec.maxSize = n
+	dec.s.Buffer(dec.buf, n)
+}
+
+// SetRecordSeparator sets the byte that terminates each record in place of
+// the default newline, for input such as streams framed by the ASCII record
+// separator 0x1e. As with newlines, the final record need not be terminated
+// and a trailing separator does not produce an empty record. Setting the
+// separator to '\n' restores the default, which also removes a carriage
+// return preceding each newline. SetRecordSeparator panics if it is called
+// after scanning has started.
+func (dec *Decoder) SetRecordSeparator(b byte) {
+	dec.recordSep = b
+	dec.split = nil
+	if b != '\n' {
+		dec.split = scanRecords(b)
+	}
+	dec.s.Split(dec.splitFunc())
+}
+
+func (dec *Decoder) splitFunc() bufio.SplitFunc {
+	if dec.split == nil {
+		return bufio.ScanLines
+	}
+	return dec.split
+}
+
+// scanRecords returns a split function for a bufio.Scanner that splits
+// records terminated by sep.
+func scanRecords(sep byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// Reset discards any buffered data and state, and rebinds dec to read from
+// r, so that a Decoder and its initial buffer can be reused. Options set on
+// dec, including its maximum record size and framing, are kept. After Reset,
+// dec behaves as a new decoder reading from r; in particular line numbers
+// start again at 1. Slices previously returned by Key and Value must not be
+// used after Reset.
+func (dec *Decoder) Reset(r io.Reader) {
+	*dec.s = *bufio.NewScanner(r)
+	dec.s.Buffer(dec.buf[:0], dec.maxSize)
+	dec.s.Split(dec.splitFunc())
+	dec.pos, dec.keyStart, dec.valueStart = 0, -1, 0
+	dec.line, dec.raw, dec.key, dec.value = nil, nil, nil, nil
+	dec.lineNum, dec.spanned, dec.records, dec.keyvals = 0, 0, 0, 0
+	dec.err = nil
+	dec.advanced, dec.advancedOK = false, false
+	dec.tokens = nil
+	dec.pairs = dec.pairs[:0]
+	dec.keys = dec.keys[:0]
+}
+
+// NewDecoderMulti returns a new decoder that reads from each of rs in turn,
+// as if they were concatenated. A record boundary is guaranteed between the
+// readers; a newline is inserted after a reader whose data does not end with
+// one, so that records from different readers are never merged.
+//
+// The decoder introduces its own buffering and may read data from rs beyond
+// the logfmt records requested.
+func NewDecoderMulti(rs ...io.Reader) *Decoder {
+	return NewDecoder(&multiRecordReader{rs: rs})
+}
+
+// multiRecordReader is like the reader returned by io.MultiReader except
+// that it ensures the data from each reader ends in a newline.
+type multiRecordReader struct {
+	rs     []io.Reader
+	needNL bool
+}
+
+func (mr *multiRecordReader) Read(p []byte) (int, error) {
+	for len(mr.rs) > 0 {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		n, err := mr.rs[0].Read(p)
+		if n > 0 {
+			mr.needNL = p[n-1] != '\n'
+		}
+		if err != io.EOF {
+			return n, err
+		}
+		mr.rs = mr.rs[1:]
+		if mr.needNL {
+			mr.needNL = false
+			if n < len(p) {
+				p[n] = '\n'
+				n++
+			} else {
+				// No room for the newline; supply it on the next call.
+				mr.rs = append([]io.Reader{bytes.NewReader(newline)}, mr.rs...)
+			}
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, io.EOF
+}
+
 // ScanRecord advances the Decoder to the next record, which can then be
 // parsed with the ScanKeyval method. It returns false when decoding stops,
 // either by reaching the end of the input or an error. After ScanRecord
 // returns false, the Err method will return any error that occurred during
 // decoding, except that if it was io.EOF, Err will return nil.
 func (dec *Decoder) ScanRecord() bool {
+	if dec.advanced {
+		dec.advanced = false
+		return dec.advancedOK
+	}
 	if dec.err != nil {
 		return false
 	}
-	if !dec.s.Scan() {
-		dec.err = dec.s.Err()
+	if !validDelimiters(dec.KeyValueDelimiters) {
+		dec.err = ErrInvalidDelimiter
 		return false
 	}
-	dec.lineNum++
-	dec.pos = 0
+	for {
+		if !dec.s.Scan() {
+			dec.scanError()
+			return false
+		}
+		line, extra := dec.s.Bytes(), 0
+		if dec.multiline && dec.quoteOpen(line, false) {
+			var ok bool
+			if line, extra, ok = dec.joinLines(line); !ok {
+				return false
+			}
+		}
+		dec.raw = line
+		dec.startRecord(line)
+		dec.spanned = extra
+		if !dec.isComment() && dec.stripPrefix() {
+			dec.records++
+			if dec.onRecord != nil {
+				dec.onRecord(dec.lineNum)
+			}
+			return true
+		}
+	}
+}
+
+// OnRecordStart sets a function that ScanRecord calls each time it advances
+// to a new record, before it returns true, with the 1-based line number of
+// the record as reported by Line. It is not called when ScanRecord returns
+// false, nor for comment lines. Passing nil removes the function.
+func (dec *Decoder) OnRecordStart(fn func(lineNum int)) {
+	dec.onRecord = fn
+}
+
+// SetLinePrefixFunc sets a function that ScanRecord calls with each line,
+// other than comment lines, to remove a prefix that precedes the key/value
+// pairs, such as the timestamp and level in
+//
+//	2024-01-02T03:04:05Z INFO k1=v1 k2=v2
+//
+// fn returns the rest of the line, which is scanned for pairs, and true, or
+// false to skip the line, which is still counted when numbering lines. If
+// rest is a suffix of line, as it is when fn slices line, positions reported
+// by SyntaxError and Pos remain relative to the start of the line, and
+// LineBytes returns the whole line; otherwise positions are relative to the
+// start of rest, which LineBytes returns. Passing nil removes the function.
+func (dec *Decoder) SetLinePrefixFunc(fn func(line []byte) (rest []byte, ok bool)) {
+	dec.linePrefix = fn
+}
+
+// stripPrefix applies the line prefix function, if any, to the current
+// record and reports whether the record should be scanned.
+func (dec *Decoder) stripPrefix() bool {
+	if dec.linePrefix == nil {
+		return true
+	}
+	line := dec.line[dec.pos:]
+	rest, ok := dec.linePrefix(line)
+	if !ok {
+		return false
+	}
+	if len(rest) <= len(line) && (len(rest) == 0 || &rest[len(rest)-1] == &line[len(line)-1]) {
+		dec.pos += len(line) - len(rest)
+	} else {
+		dec.line, dec.pos = rest, 0
+	}
 	return true
 }
 
+// scanError records the error that stopped the scanner, if any.
+func (dec *Decoder) scanError() {
+	dec.err = dec.s.Err()
+	if dec.err == bufio.ErrTooLong {
+		dec.tooLong()
+	}
+}
+
+func (dec *Decoder) tooLong() {
+	dec.err = &SyntaxError{
+		Msg:  "line too long",
+		Line: dec.lineNum + dec.spanned + 1,
+		Pos:  dec.maxSize + 1,
+		Err:  bufio.ErrTooLong,
+	}
+}
+
+// SetAllowMultilineValues sets whether a quoted value, or a quoted key if
+// they are allowed, may span lines. When allowed, a line that ends within a
+// quoted string is joined to the following lines, separated by newlines,
+// until the string is closed, and the newlines become part of the unquoted
+// value. A string that is still open at the end of the input is reported as
+// unterminated. Line reports the line on which a joined record starts, which
+// is also the line reported by any SyntaxError within it, and positions are
+// relative to the start of the joined record. The length of a joined record
+// is limited like that of a line. By default a quoted string that is not
+// closed on its line is a syntax error.
+func (dec *Decoder) SetAllowMultilineValues(allow bool) {
+	dec.multiline = allow
+}
+
+// quoteOpen reports whether a quoted string is open at the end of line, given
+// whether one is open at its start. A single-quoted value is skipped, as it
+// may not span lines.
+func (dec *Decoder) quoteOpen(line []byte, open bool) bool {
+	if !open && len(dec.comment) > 0 && bytes.HasPrefix(bytes.TrimLeft(line, " \t"), dec.comment) {
+		return false
+	}
+	esc, sq := false, false
+	for i, c := range line {
+		switch {
+		case sq:
+			if esc {
+				esc = false
+			} else if c == '\\' {
+				esc = true
+			} else if c == '\'' {
+				sq = false
+			}
+		case !open:
+			if c == '"' {
+				open = true
+			} else if c == '\'' && dec.sqValues && dec.startsValue(line, i) {
+				sq = true
+			} else if len(dec.InlineCommentMarker) > 0 && (i == 0 || line[i-1] <= ' ') && bytes.HasPrefix(line[i:], dec.InlineCommentMarker) {
+				return false
+			}
+		case esc:
+			esc = false
+		case c == '\\' && !dec.DoubledQuoteEscape:
+			esc = true
+		case c == '"':
+			open = false
+		}
+	}
+	return open
+}
+
+// startsValue reports whether line[i] is the first byte of a value, following
+// a key-value delimiter and, if values are trimmed, any blanks.
+func (dec *Decoder) startsValue(line []byte, i int) bool {
+	if dec.trimValues {
+		for i > 0 && line[i-1] <= ' ' {
+			i--
+		}
+	}
+	return i > 0 && dec.isKeyValueDelimiter(line[i-1])
+}
+
+// joinLines joins line, which ends within a quoted string, and the lines
+// that follow it until the string is closed or the input ends. It returns
+// the joined record and the number of lines joined to line. It reports false
+// if an error stops the scanner.
+func (dec *Decoder) joinLines(line []byte) ([]byte, int, bool) {
+	dec.joined = append(dec.joined[:0], line...)
+	n := 0
+	for open := true; open; {
+		if !dec.s.Scan() {
+			if dec.s.Err() != nil {
+				dec.scanError()
+				return nil, 0, false
+			}
+			break
+		}
+		line = dec.s.Bytes()
+		if len(dec.joined)+1+len(line) >= dec.maxSize {
+			dec.tooLong()
+			return nil, 0, false
+		}
+		dec.joined = append(append(dec.joined, '\n'), line...)
+		n++
+		open = dec.quoteOpen(line, true)
+	}
+	return dec.joined, n, true
+}
+
+// SetTrimUnquotedValues sets whether whitespace between a key/value
+// delimiter and an unquoted value is ignored, for input from producers that
+// write pairs such as k= v. When enabled, a delimiter followed by whitespace
+// takes the next token as its value, provided that the token contains no
+// key/value delimiter or quote; otherwise the value is missing, as it is by
+// default. Unquoted values never include trailing whitespace, and quoted
+// values are not affected. Note that a key without a value that follows a
+// delimiter and whitespace, such as flag in k= flag, is taken as the value of
+// the preceding key.
+func (dec *Decoder) SetTrimUnquotedValues(trim bool) {
+	dec.trimValues = trim
+}
+
+// SetNullAsNil sets whether the unquoted value null, the token an Encoder
+// writes for nil values by default, is decoded as a missing value, so that
+// Value returns nil rather than the bytes null. A quoted "null", which an
+// Encoder writes for a string equal to the token, is still decoded as the
+// string null, keeping the two distinguishable. Only the default token is
+// recognized; values written by an Encoder whose token has been changed with
+// Encoder.SetNilValue are decoded as written. Note that Value also returns
+// nil for a key without a value, as in k or k=, so a nil value does not
+// distinguish null from an empty value.
+func (dec *Decoder) SetNullAsNil(enable bool) {
+	dec.nullAsNil = enable
+}
+
+// SetSingleQuoteValues sets whether values may be quoted with apostrophes,
+// as in k='a b', for input from producers that quote values that way. A
+// single-quoted value is unquoted like a double-quoted one, including its
+// escape sequences, with \' in place of \" as the escape for the quoting
+// character; a double quote within it may be escaped or not. Double-quoted
+// values are decoded as usual. Only a value that begins with an apostrophe is
+// quoted; an apostrophe elsewhere is an ordinary byte of a key or unquoted
+// value. A single-quoted value is not affected by DoubledQuoteEscape and may
+// not span lines. By default an apostrophe is always an ordinary byte.
+func (dec *Decoder) SetSingleQuoteValues(enable bool) {
+	dec.sqValues = enable
+}
+
+// SetSeparatorRunes restricts the whitespace accepted between pairs, and
+// before the first and after the last pair of a record, to the bytes of
+// allowed. Any other space or control character found there causes a
+// SyntaxError wrapping ErrUnexpectedSeparator; for example, passing " "
+// rejects tabs. The carriage return of a CRLF line ending is removed before
+// a line is scanned and is always accepted. Characters of allowed that are
+// not space or control characters have no effect. An empty string restores
+// the default, which accepts all space and control characters.
+func (dec *Decoder) SetSeparatorRunes(allowed string) {
+	if allowed == "" {
+		dec.separators = nil
+		return
+	}
+	dec.separators = []byte(allowed)
+}
+
+// SetAllowQuotedKeys sets whether keys may be quoted, as in "a key"=value. A
+// quoted key is unquoted like a quoted value, including its escape
+// sequences, and Key returns the unquoted key. An empty quoted key is a
+// syntax error, as is a quoted key followed by anything other than a
+// key/value delimiter or whitespace. By default a quote in a key is a syntax
+// error.
+func (dec *Decoder) SetAllowQuotedKeys(allow bool) {
+	dec.quotedKeys = allow
+}
+
+// SetCommentPrefix sets a prefix that marks comment lines. A line whose
+// first byte other than whitespace begins the prefix is skipped by
+// ScanRecord, although it is still counted when numbering lines. The prefix
+// is not recognized elsewhere in a line. An empty prefix, the default,
+// disables comment lines.
+func (dec *Decoder) SetCommentPrefix(prefix string) {
+	dec.comment = []byte(prefix)
+}
+
+// isComment reports whether the current record is a comment line.
+func (dec *Decoder) isComment() bool {
+	if len(dec.comment) == 0 {
+		return false
+	}
+	line := dec.line[dec.pos:]
+	for len(line) > 0 && line[0] <= ' ' {
+		line = line[1:]
+	}
+	return bytes.HasPrefix(line, dec.comment)
+}
+
+// startRecord makes line the current record.
+func (dec *Decoder) startRecord(line []byte) {
+	dec.lineNum += 1 + dec.spanned
+	dec.spanned = 0
+	dec.pos = 0
+	dec.keyStart = -1
+	dec.tokenEnd = -1
+	dec.tokens = nil
+	dec.pairs = dec.pairs[:0]
+	dec.keys = dec.keys[:0]
+	dec.line = line
+	if dec.LineTransform != nil {
+		dec.line = dec.LineTransform(dec.line)
+	}
+	if dec.lineNum == 1 && bytes.HasPrefix(dec.line, bomBytes) {
+		// Skip a byte order mark at the start of the input. Positions
+		// remain relative to the start of the line.
+		dec.pos = len(bomBytes)
+	}
+}
+
 // ScanKeyval advances the Decoder to the next key/value pair of the current
 // record, which can then be retrieved with the Key and Value methods. It
 // returns false when decoding stops, either by reaching the end of the
 // current record or an error.
 func (dec *Decoder) ScanKeyval() bool {
+	prevErr := dec.err
+	if dec.scanPair() {
+		dec.keyvals++
+		if dec.onToken != nil {
+			dec.emitPair()
+		}
+		return true
+	}
+	se, ok := dec.err.(*SyntaxError)
+	if ok && dec.onToken != nil && dec.err != prevErr {
+		dec.emitError(se)
+	}
+	if ok && dec.lenient != nil {
+		dec.clearSyntaxError()
+		dec.lenient(se)
+	}
+	return false
+}
+
+// ClearErr clears the current error if it is a SyntaxError raised while
+// parsing a record, so that decoding can continue, and reports whether it
+// did. The rest of the record containing the error is skipped; the next call
+// to ScanKeyval returns false and the next call to ScanRecord reads the
+// following record. Pairs of the record scanned before the error remain
+// valid. Errors from the underlying reader, including io.EOF, and the
+// SyntaxError for a line that is too long cannot be cleared.
+func (dec *Decoder) ClearErr() bool {
+	se, ok := dec.err.(*SyntaxError)
+	if !ok || se.Err == bufio.ErrTooLong {
+		return false
+	}
+	dec.clearSyntaxError()
+	return true
+}
+
+// SkipRecord discards the remaining key/value pairs of the current record
+// without scanning them, so that the next call to ScanKeyval returns false
+// and the next call to ScanRecord moves on to the following record. It may be
+// called after any number of pairs of the record have been scanned. Pairs
+// skipped are not checked for syntax errors, and an error that has already
+// occurred is kept.
+func (dec *Decoder) SkipRecord() {
+	if dec.err != nil {
+		return
+	}
+	dec.endRecord()
+}
+
+// clearSyntaxError clears a syntax error and ends the current record.
+func (dec *Decoder) clearSyntaxError() {
+	dec.err = nil
+	dec.endRecord()
+}
+
+// endRecord ends the current record, as if it had no more pairs.
+func (dec *Decoder) endRecord() {
+	dec.key, dec.value = nil, nil
+	dec.pos = len(dec.line)
+}
+
+// SetRejectDuplicateKeys sets whether a key that occurs more than once in a
+// record is a syntax error, reported at the position of the repeated key.
+// Keys are compared byte for byte, after applying KeyAliases, and a key
+// without a value counts as an occurrence of the key.
+func (dec *Decoder) SetRejectDuplicateKeys(reject bool) {
+	dec.rejectDups = reject
+}
+
+// SetLenient enables lenient decoding if collect is not nil. In lenient mode
+// a syntax error ends the current record early, as if it had no more pairs,
+// and is passed to collect instead of stopping the Decoder; the next call to
+// ScanRecord continues with the following record. Pairs of the record scanned
+// before the error remain valid. Errors from the underlying reader, and the
+// SyntaxError for a line that is too long, still stop the Decoder and are
+// reported by Err. Passing nil disables lenient mode.
+func (dec *Decoder) SetLenient(collect func(err *SyntaxError)) {
+	dec.lenient = collect
+}
+
+func (dec *Decoder) scanPair() bool {
+	if !dec.scanKeyval() {
+		return false
+	}
+	if len(dec.KeyAliases) > 0 && dec.key != nil {
+		if canon, ok := dec.KeyAliases[string(dec.key)]; ok {
+			dec.key = []byte(canon)
+		}
+	}
+	if (dec.MaxKeysPerRecord > 0 || dec.rejectDups) && dec.key != nil && !dec.trackKey() {
+		return false
+	}
+	if dec.value != nil && dec.Base64Keys[string(dec.key)] {
+		v, err := decodeBase64(dec.value)
+		if err != nil {
+			dec.pos = dec.valueStart
+			dec.syntaxError(ErrInvalidBase64)
+			return false
+		}
+		dec.value = v
+	}
+	if dec.CopyTokens {
+		dec.copyTokens()
+	}
+	if dec.CoalesceDuplicates && dec.key != nil {
+		dec.pairs = append(dec.pairs, [2][]byte{dec.key, dec.value})
+	}
+	return true
+}
+
+// trackKey adds the current key to the distinct keys of the record. It
+// reports a syntax error and returns false if the key is a rejected
+// duplicate or the number of distinct keys exceeds MaxKeysPerRecord.
+func (dec *Decoder) trackKey() bool {
+	for _, k := range dec.keys {
+		if bytes.Equal(k, dec.key) {
+			if dec.rejectDups {
+				dec.pos = dec.keyStart
+				dec.syntaxError(ErrDuplicateKey)
+				return false
+			}
+			return true
+		}
+	}
+	if dec.MaxKeysPerRecord > 0 && len(dec.keys) == dec.MaxKeysPerRecord {
+		dec.pos = dec.keyStart
+		dec.syntaxError(ErrTooManyKeys)
+		return false
+	}
+	dec.keys = append(dec.keys, dec.key)
+	return true
+}
+
+func decodeBase64(src []byte) ([]byte, error) {
+	enc := base64.StdEncoding
+	if bytes.ContainsAny(src, "-_") {
+		enc = base64.URLEncoding
+	}
+	if len(src)%4 != 0 {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+	dst := make([]byte, enc.DecodedLen(len(src)))
+	n, err := enc.Strict().Decode(dst, src)
+	return dst[:n], err
+}
+
+// MultiValue returns, in order, the values of all pairs of the current
+// record with the given key that have been scanned so far. It requires
+// CoalesceDuplicates to be set, and returns nil otherwise. The returned
+// slices are subject to the same lifetime rules as those returned by Value.
+func (dec *Decoder) MultiValue(key []byte) [][]byte {
+	var values [][]byte
+	for _, p := range dec.pairs {
+		if bytes.Equal(p[0], key) {
+			values = append(values, p[1])
+		}
+	}
+	return values
+}
+
+func (dec *Decoder) copyTokens() {
+	if dec.tokens == nil {
+		dec.tokens = tokenBufferPool.Get().(*[]byte)
+	}
+	dec.key = appendToken(dec.tokens, dec.key)
+	dec.value = appendToken(dec.tokens, dec.value)
+}
+
+// appendToken appends tok to *buf and returns the appended copy, with its
+// capacity limited so that appending to it cannot overwrite other tokens.
+func appendToken(buf *[]byte, tok []byte) []byte {
+	if tok == nil {
+		return nil
+	}
+	start := len(*buf)
+	*buf = append(*buf, tok...)
+	end := len(*buf)
+	return (*buf)[start:end:end]
+}
+
+// Release returns the buffer holding the copies of the keys and values of
+// the current record, made when CopyTokens is set, to a pool for reuse. Slices
+// previously returned by Key and Value for the record must not be used after
+// Release is called.
+func (dec *Decoder) Release() {
+	dec.key, dec.value = nil, nil
+	if dec.tokens == nil {
+		return
+	}
+	*dec.tokens = (*dec.tokens)[:0]
+	tokenBufferPool.Put(dec.tokens)
+	dec.tokens = nil
+}
+
+func (dec *Decoder) scanKeyval() bool {
 	dec.key, dec.value = nil, nil
 	if dec.err != nil {
 		return false
 	}
 
-	line := dec.s.Bytes()
+	line := dec.line
 
 	// garbage
 	for p, c := range line[dec.pos:] {
-		if c > ' ' {
+		if c <= ' ' {
+			if dec.separators != nil && bytes.IndexByte(dec.separators, c) < 0 {
+				dec.pos += p
+				dec.unexpectedByte(c)
+				return false
+			}
+		} else {
 			dec.pos += p
+			if len(dec.InlineCommentMarker) > 0 && bytes.HasPrefix(line[dec.pos:], dec.InlineCommentMarker) {
+				break
+			}
 			goto key
 		}
 	}
@@ -87,17 +837,18 @@ func (dec *Decoder) ScanKeyval() bool {
 	return false
 
 key:
-	const invalidKeyError = "invalid key"
-
+	dec.keyStart, dec.keyEnd = dec.pos, -1
 	start, multibyte := dec.pos, false
+	if dec.quotedKeys && line[dec.pos] == '"' {
+		goto qkey
+	}
 	for p, c := range line[dec.pos:] {
 		switch {
-		case c == '=':
+		case dec.isKeyValueDelimiter(c):
 			dec.pos += p
 			if dec.pos > start {
 				dec.key = line[start:dec.pos]
-				if multibyte && bytes.ContainsRune(dec.key, utf8.RuneError) {
-					dec.syntaxError(invalidKeyError)
+				if (multibyte || dec.RejectNonPrintableKeys) && !dec.checkKey() {
 					return false
 				}
 			}
@@ -106,7 +857,7 @@ key:
 				return false
 			}
 			goto equal
-		case c == '"':
+		case c == '=' || c == '"':
 			dec.pos += p
 			dec.unexpectedByte(c)
 			return false
@@ -114,8 +865,7 @@ key:
 			dec.pos += p
 			if dec.pos > start {
 				dec.key = line[start:dec.pos]
-				if multibyte && bytes.ContainsRune(dec.key, utf8.RuneError) {
-					dec.syntaxError(invalidKeyError)
+				if (multibyte || dec.RejectNonPrintableKeys) && !dec.checkKey() {
 					return false
 				}
 			}
@@ -127,26 +877,60 @@ key:
 	dec.pos = len(line)
 	if dec.pos > start {
 		dec.key = line[start:dec.pos]
-		if multibyte && bytes.ContainsRune(dec.key, utf8.RuneError) {
-			dec.syntaxError(invalidKeyError)
+		if (multibyte || dec.RejectNonPrintableKeys) && !dec.checkKey() {
 			return false
 		}
 	}
 	return true
 
+qkey:
+	if !dec.scanQuoted(line, &dec.key) {
+		return false
+	}
+	if dec.key == nil {
+		dec.pos = dec.keyStart
+		dec.syntaxError(ErrInvalidKey)
+		return false
+	}
+	if !dec.checkKey() {
+		return false
+	}
+	if dec.pos >= len(line) {
+		return true
+	}
+	switch c := line[dec.pos]; {
+	case dec.isKeyValueDelimiter(c):
+		goto equal
+	case c <= ' ':
+		return true
+	default:
+		dec.unexpectedByte(c)
+		return false
+	}
+
 equal:
+	dec.keyEnd = dec.pos
 	dec.pos++
+	dec.valueStart = dec.pos
 	if dec.pos >= len(line) {
 		return true
 	}
 	switch c := line[dec.pos]; {
 	case c <= ' ':
+		if i := dec.trimmedValue(line); i >= 0 {
+			dec.pos, dec.valueStart = i, i
+			goto value
+		}
 		return true
-	case c == '"':
+	case c == '"', c == '\'' && dec.sqValues:
 		goto qvalue
 	}
 
-	// value
+value:
+	if dec.sqValues && line[dec.pos] == '\'' {
+		// A trimmed value may be single-quoted.
+		goto qvalue
+	}
 	start = dec.pos
 	for p, c := range line[dec.pos:] {
 		switch {
@@ -157,55 +941,294 @@ equal:
 		case c <= ' ':
 			dec.pos += p
 			if dec.pos > start {
-				dec.value = line[start:dec.pos]
+				dec.setUnquotedValue(line[start:dec.pos])
 			}
 			return true
 		}
 	}
 	dec.pos = len(line)
 	if dec.pos > start {
-		dec.value = line[start:dec.pos]
+		dec.setUnquotedValue(line[start:dec.pos])
 	}
 	return true
 
 qvalue:
-	const (
-		untermQuote  = "unterminated quoted value"
-		invalidQuote = "invalid quoted value"
-	)
+	return dec.scanQuoted(line, &dec.value)
+}
+
+// setUnquotedValue sets the current value to the unquoted value v, or to nil
+// if v is the nil token and SetNullAsNil is enabled.
+func (dec *Decoder) setUnquotedValue(v []byte) {
+	if dec.nullAsNil && string(v) == "null" {
+		v = nil
+	}
+	dec.value = v
+}
+
+// trimmedValue returns the offset in line of an unquoted value separated
+// from the delimiter at line[dec.pos-1] by whitespace, if values are trimmed
+// and there is one, or -1 otherwise.
+func (dec *Decoder) trimmedValue(line []byte) int {
+	if !dec.trimValues {
+		return -1
+	}
+	i := dec.pos
+	for i < len(line) && line[i] <= ' ' {
+		i++
+	}
+	if i == len(line) || len(dec.InlineCommentMarker) > 0 && bytes.HasPrefix(line[i:], dec.InlineCommentMarker) {
+		return -1
+	}
+	for _, c := range line[i:] {
+		if c <= ' ' {
+			break
+		}
+		if c == '=' || c == '"' || dec.isKeyValueDelimiter(c) {
+			// The token is a key or a quoted value.
+			return -1
+		}
+	}
+	return i
+}
+
+// scanQuoted scans the quoted string that starts at line[dec.pos], sets *dst
+// to its unquoted contents, or nil if it is empty, and advances dec.pos past
+// the closing quote. The string is quoted by the quotation mark or, for a
+// single-quoted value, the apostrophe at line[dec.pos]. It reports whether
+// the string is valid, recording a syntax error if it is not.
+func (dec *Decoder) scanQuoted(line []byte, dst *[]byte) bool {
+	var hasEsc, esc bool
+	var nesc int
+	start := dec.pos
+	q := line[start]
+	if dec.DoubledQuoteEscape && q == '"' {
+		goto dquoted
+	}
 
-	hasEsc, esc := false, false
-	start = dec.pos
 	for p, c := range line[dec.pos+1:] {
 		switch {
 		case esc:
 			esc = false
 		case c == '\\':
 			hasEsc, esc = true, true
-		case c == '"':
+			nesc++
+			if dec.MaxEscapes > 0 && nesc > dec.MaxEscapes {
+				dec.pos += p + 1
+				dec.syntaxError(ErrTooManyEscapes)
+				return false
+			}
+		case c == q:
 			dec.pos += p + 2
 			if hasEsc {
-				v, ok := unquoteBytes(line[start:dec.pos])
+				v, ok := unquoteBytes(line[start:dec.pos], q, dec.multiline)
 				if !ok {
-					dec.syntaxError(invalidQuote)
+					dec.syntaxError(ErrInvalidQuotedValue)
 					return false
 				}
-				dec.value = v
+				*dst = v
 			} else {
 				start++
 				end := dec.pos - 1
 				if end > start {
-					dec.value = line[start:end]
+					*dst = line[start:end]
 				}
 			}
 			return true
 		}
 	}
 	dec.pos = len(line)
-	dec.syntaxError(untermQuote)
+	dec.syntaxError(ErrUnterminatedQuote)
+	return false
+
+dquoted:
+	start = dec.pos + 1
+	for i := start; i < len(line); i++ {
+		if line[i] != '"' {
+			continue
+		}
+		if i+1 < len(line) && line[i+1] == '"' {
+			hasEsc = true
+			i++
+			continue
+		}
+		dec.pos = i + 1
+		if i > start {
+			*dst = line[start:i]
+			if hasEsc {
+				*dst = bytes.ReplaceAll(*dst, doubledQuote, quote)
+			}
+		}
+		return true
+	}
+	dec.pos = len(line)
+	dec.syntaxError(ErrUnterminatedQuote)
 	return false
 }
 
+var (
+	quote        = []byte(`"`)
+	doubledQuote = []byte(`""`)
+)
+
+// checkKey reports whether dec.key is valid, recording a syntax error if it
+// is not.
+func (dec *Decoder) checkKey() bool {
+	if bytes.ContainsRune(dec.key, utf8.RuneError) {
+		dec.syntaxError(ErrInvalidKey)
+		return false
+	}
+	if dec.RejectNonPrintableKeys && bytes.IndexFunc(dec.key, isNonPrintable) != -1 {
+		dec.syntaxError(ErrNonPrintableKey)
+		return false
+	}
+	return true
+}
+
+func isNonPrintable(r rune) bool {
+	return !unicode.IsPrint(r)
+}
+
+func (dec *Decoder) isKeyValueDelimiter(c byte) bool {
+	if dec.KeyValueDelimiters == nil {
+		return c == '='
+	}
+	return bytes.IndexByte(dec.KeyValueDelimiters, c) != -1
+}
+
+func validDelimiters(delims []byte) bool {
+	if delims == nil {
+		return true
+	}
+	if len(delims) == 0 {
+		return false
+	}
+	for _, c := range delims {
+		if c <= ' ' || c >= utf8.RuneSelf-1 || c == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// RecordReader returns a reader that yields the raw bytes of the current
+// record, as read from the input and followed by the record separator, for
+// verbatim forwarding. The bytes are not affected by LineTransform.
+//
+// When the current record is exhausted the reader returns io.EOF and advances
+// the Decoder to the next record, so that each call to io.Copy from the
+// reader copies one record. The next call to ScanRecord does not advance the
+// Decoder again; it reports the result of the advance made by the reader.
+// Once the input is exhausted or an error occurs, Read returns 0, io.EOF and
+// Err reports any error. Interleaving reads with calls to ScanKeyval is
+// permitted.
+func (dec *Decoder) RecordReader() io.Reader {
+	return &recordReader{dec: dec, rec: dec.lineNum}
+}
+
+type recordReader struct {
+	dec *Decoder
+	rec int
+	off int
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	dec := r.dec
+	if dec.lineNum == 0 && !dec.advanced {
+		// No record has been scanned yet.
+		if !dec.ScanRecord() {
+			dec.advanced, dec.advancedOK = true, false
+			return 0, io.EOF
+		}
+	}
+	if r.rec != dec.lineNum {
+		r.rec, r.off = dec.lineNum, 0
+	}
+
+	line := dec.raw
+	if r.off < len(line)+1 && !(dec.advanced && !dec.advancedOK) {
+		dec.advanced = false
+		n := 0
+		if r.off < len(line) {
+			n = copy(p, line[r.off:])
+		}
+		if n < len(p) && r.off+n == len(line) {
+			p[n] = dec.recordSep
+			n++
+		}
+		r.off += n
+		return n, nil
+	}
+
+	if !dec.advanced {
+		ok := dec.ScanRecord()
+		dec.advanced, dec.advancedOK = true, ok
+		if ok {
+			r.rec, r.off = dec.lineNum, 0
+		}
+	}
+	return 0, io.EOF
+}
+
+// Line returns the 1-based line number of the current record, counting
+// records as lines, or 0 if no record has been scanned.
+func (dec *Decoder) Line() int {
+	return dec.lineNum
+}
+
+// RecordCount returns the number of records returned by ScanRecord since the
+// Decoder was created or last Reset. Unlike Line, it does not count comment
+// lines.
+func (dec *Decoder) RecordCount() int {
+	return dec.records
+}
+
+// KeyvalCount returns the number of key/value pairs returned by ScanKeyval,
+// across all records, since the Decoder was created or last Reset.
+func (dec *Decoder) KeyvalCount() int {
+	return dec.keyvals
+}
+
+// Pos returns the 1-based byte position, within the line of the current
+// record, of the key/value pair most recently found by a call to ScanKeyval,
+// or 0 if none has been found in the record. Together with Line it gives
+// coordinates consistent with those reported by SyntaxError.
+func (dec *Decoder) Pos() int {
+	return dec.keyStart + 1
+}
+
+// LineBytes returns the current record as it appears in the input, without
+// its terminating newline, after any LineTransform. The returned slice may
+// point to internal buffers and is only valid until the next call to
+// ScanRecord.
+func (dec *Decoder) LineBytes() []byte {
+	return dec.line
+}
+
+// KeyOffset returns the start and end byte offsets, within LineBytes, of the
+// key most recently found by a call to ScanKeyval, as it appears in the
+// input; a quoted key includes its quotes. The offsets are only meaningful
+// after ScanKeyval returns true.
+func (dec *Decoder) KeyOffset() (start, end int) {
+	end = dec.keyEnd
+	if end < 0 {
+		end = dec.pos
+	}
+	return dec.keyStart, end
+}
+
+// ValueOffset returns the start and end byte offsets, within LineBytes, of
+// the value most recently found by a call to ScanKeyval, as it appears in
+// the input; a quoted value includes its quotes and escape sequences. For a
+// key without a delimiter both offsets are the end of the key, and for an
+// empty value both are the offset just after the delimiter. The offsets are
+// only meaningful after ScanKeyval returns true.
+func (dec *Decoder) ValueOffset() (start, end int) {
+	if dec.keyEnd < 0 {
+		return dec.pos, dec.pos
+	}
+	return dec.valueStart, dec.pos
+}
+
 // Key returns the most recent key found by a call to ScanKeyval. The returned
 // slice may point to internal buffers and is only valid until the next call
 // to ScanRecord.  It does no allocation.
@@ -221,34 +1244,107 @@ func (dec *Decoder) Value() []byte {
 	return dec.value
 }
 
+// KeyString returns a copy of the most recent key found by a call to
+// ScanKeyval as a string. Unlike the slice returned by Key, the string
+// remains valid after subsequent calls and is safe to retain.
+func (dec *Decoder) KeyString() string {
+	return dec.string(dec.key)
+}
+
+// ValueString returns a copy of the most recent value found by a call to
+// ScanKeyval as a string. Unlike the slice returned by Value, the string
+// remains valid after subsequent calls and is safe to retain. A missing value
+// is returned as the empty string.
+func (dec *Decoder) ValueString() string {
+	return dec.string(dec.value)
+}
+
+// ScanKeyvalString advances the Decoder to the next key/value pair of the
+// current record like ScanKeyval, and returns copies of the key and value as
+// strings, which remain valid after subsequent calls and are safe to retain.
+// A missing value is returned as the empty string. The ok result is the
+// result of ScanKeyval; when it is false the key and value are empty.
+func (dec *Decoder) ScanKeyvalString() (key, value string, ok bool) {
+	if !dec.ScanKeyval() {
+		return "", "", false
+	}
+	return dec.string(dec.key), dec.string(dec.value), true
+}
+
 // Err returns the first non-EOF error that was encountered by the Scanner.
 func (dec *Decoder) Err() error {
 	return dec.err
 }
 
-func (dec *Decoder) syntaxError(msg string) {
+func (dec *Decoder) syntaxError(err error) {
 	dec.err = &SyntaxError{
-		Msg:  msg,
+		Msg:  err.Error(),
 		Line: dec.lineNum,
 		Pos:  dec.pos + 1,
+		Err:  err,
 	}
 }
 
 func (dec *Decoder) unexpectedByte(c byte) {
+	var err error
+	switch c {
+	case '=':
+		err = ErrUnexpectedEquals
+	case '"':
+		err = ErrUnexpectedQuote
+	default:
+		if c <= ' ' {
+			err = ErrUnexpectedSeparator
+		} else {
+			err = ErrUnexpectedDelimiter
+		}
+	}
 	dec.err = &SyntaxError{
 		Msg:  fmt.Sprintf("unexpected %q", c),
 		Line: dec.lineNum,
 		Pos:  dec.pos + 1,
+		Err:  err,
 	}
 }
 
+// Errors wrapped by a SyntaxError to identify the kind of syntax error. Some
+// are only reported when the corresponding Decoder option is enabled. A key
+// that is not valid UTF-8 is reported with ErrInvalidKey.
+var (
+	ErrUnexpectedEquals    = errors.New("unexpected '='")
+	ErrUnexpectedQuote     = errors.New("unexpected '\"'")
+	ErrUnexpectedDelimiter = errors.New("unexpected key/value delimiter")
+	ErrUnexpectedSeparator = errors.New("unexpected separator")
+	ErrUnterminatedQuote   = errors.New("unterminated quoted value")
+	ErrInvalidQuotedValue  = errors.New("invalid quoted value")
+	ErrTooManyEscapes      = errors.New("too many escapes")
+	ErrNonPrintableKey     = errors.New("non-printable key")
+	ErrInvalidBase64       = errors.New("invalid base64")
+	ErrTooManyKeys         = errors.New("too many keys")
+	ErrDuplicateKey        = errors.New("duplicate key")
+)
+
+// ErrInvalidDelimiter is returned by Decoder methods if KeyValueDelimiters
+// contains a byte that cannot separate a key from its value.
+var ErrInvalidDelimiter = errors.New("invalid key/value delimiter")
+
 // A SyntaxError represents a syntax error in the logfmt input stream.
 type SyntaxError struct {
 	Msg  string
 	Line int
 	Pos  int
+
+	// Err is the kind of syntax error, one of the errors wrapped by a
+	// SyntaxError such as ErrUnterminatedQuote.
+	Err error
 }
 
 func (e *SyntaxError) Error() string {
 	return fmt.Sprintf("logfmt syntax error at pos %d on line %d: %s", e.Pos, e.Line, e.Msg)
 }
+
+// Unwrap returns e.Err, so that errors.Is can test for a kind of syntax
+// error.
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
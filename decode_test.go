@@ -3,10 +3,13 @@ package logfmt
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 type kv struct {
@@ -34,6 +37,33 @@ func TestDecoder_scan(t *testing.T) {
 			dec:  defaultDecoder,
 			want: [][]kv{nil, nil},
 		},
+		{
+			data: "\xef\xbb\xbfa=1\nb=2",
+			dec:  defaultDecoder,
+			want: [][]kv{
+				{{[]byte("a"), []byte("1")}},
+				{{[]byte("b"), []byte("2")}},
+			},
+		},
+		{
+			data: "a=1\n\xef\xbb\xbfb=2",
+			dec:  defaultDecoder,
+			want: [][]kv{
+				{{[]byte("a"), []byte("1")}},
+				{{[]byte("\xef\xbb\xbfb"), []byte("2")}},
+			},
+		},
+		{
+			data: "a=1 b=v\r\nc=\"q r\"\r\n\r\nd\ne=\"\\r\"\r\n",
+			dec:  defaultDecoder,
+			want: [][]kv{
+				{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("v")}},
+				{{[]byte("c"), []byte("q r")}},
+				nil,
+				{{[]byte("d"), nil}},
+				{{[]byte("e"), []byte("\r")}},
+			},
+		},
 		{
 			data: `x= `,
 			dec:  defaultDecoder,
@@ -128,6 +158,129 @@ func TestDecoder_scan(t *testing.T) {
 				{[]byte("y"), []byte("f")},
 			}},
 		},
+		{
+			data: "a=1 b:2 c:\"x y\"\nd:3 e=4 t=12:30",
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.KeyValueDelimiters = []byte{'=', ':'}
+				return dec
+			},
+			want: [][]kv{
+				{
+					{[]byte("a"), []byte("1")},
+					{[]byte("b"), []byte("2")},
+					{[]byte("c"), []byte("x y")},
+				},
+				{
+					{[]byte("d"), []byte("3")},
+					{[]byte("e"), []byte("4")},
+					{[]byte("t"), []byte("12:30")},
+				},
+			},
+		},
+		{
+			data: "a:b=c",
+			dec:  defaultDecoder,
+			want: [][]kv{{{[]byte("a:b"), []byte("c")}}},
+		},
+		{
+			data: "2024-01-01 a=1 b=2\n2024-01-01 c=3\nd=4",
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.LineTransform = func(line []byte) []byte {
+					return bytes.TrimPrefix(line, []byte("2024-01-01 "))
+				}
+				return dec
+			},
+			want: [][]kv{
+				{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("2")}},
+				{{[]byte("c"), []byte("3")}},
+				{{[]byte("d"), []byte("4")}},
+			},
+		},
+		{
+			data: `a="x""y" b="" c="""" d="q\" e="""q"""` + "\n" + `f="a""" g=1`,
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.DoubledQuoteEscape = true
+				return dec
+			},
+			want: [][]kv{
+				{
+					{[]byte("a"), []byte(`x"y`)},
+					{[]byte("b"), nil},
+					{[]byte("c"), []byte(`"`)},
+					{[]byte("d"), []byte(`q\`)},
+					{[]byte("e"), []byte(`"q"`)},
+				},
+				{
+					{[]byte("f"), []byte(`a"`)},
+					{[]byte("g"), []byte("1")},
+				},
+			},
+		},
+		{
+			data: "a=1 b=2 // note\nc=\"x // y\" d=http://e //\n// only a comment\nf=1//x",
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.InlineCommentMarker = []byte("//")
+				return dec
+			},
+			want: [][]kv{
+				{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("2")}},
+				{{[]byte("c"), []byte("x // y")}, {[]byte("d"), []byte("http://e")}},
+				nil,
+				{{[]byte("f"), []byte("1//x")}},
+			},
+		},
+		{
+			data: "a=\"#1\" # b=2\n#c=3",
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.InlineCommentMarker = []byte("#")
+				return dec
+			},
+			want: [][]kv{
+				{{[]byte("a"), []byte("#1")}},
+				nil,
+			},
+		},
+		{
+			data: "e\u0301=1 b\u200bc=2",
+			dec:  defaultDecoder,
+			want: [][]kv{{
+				{[]byte("e\u0301"), []byte("1")},
+				{[]byte("b\u200bc"), []byte("2")},
+			}},
+		},
+		{
+			data: "e\u0301=1 ƒ=2",
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.RejectNonPrintableKeys = true
+				return dec
+			},
+			want: [][]kv{{
+				{[]byte("e\u0301"), []byte("1")},
+				{[]byte("ƒ"), []byte("2")},
+			}},
+		},
+		{
+			data: `std="aGk/Pz4+" url=aGk_Pz4- pad="YQ==" raw=YQ empty= plain=YQ`,
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.Base64Keys = map[string]bool{"std": true, "url": true, "pad": true, "raw": true, "empty": true}
+				return dec
+			},
+			want: [][]kv{{
+				{[]byte("std"), []byte("hi??>>")},
+				{[]byte("url"), []byte("hi??>>")},
+				{[]byte("pad"), []byte("a")},
+				{[]byte("raw"), []byte("a")},
+				{[]byte("empty"), nil},
+				{[]byte("plain"), []byte("YQ")},
+			}},
+		},
 	}
 
 	for _, test := range tests {
@@ -164,72 +317,77 @@ func TestDecoder_errors(t *testing.T) {
 		{
 			data: "a=1\n=bar",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "unexpected '='", Line: 2, Pos: 1},
+			want: &SyntaxError{Msg: "unexpected '='", Line: 2, Pos: 1, Err: ErrUnexpectedEquals},
+		},
+		{
+			data: "\xef\xbb\xbf=1",
+			dec:  defaultDecoder,
+			want: &SyntaxError{Msg: "unexpected '='", Line: 1, Pos: 4, Err: ErrUnexpectedEquals},
 		},
 		{
 			data: "a=1\n\"k\"=bar",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "unexpected '\"'", Line: 2, Pos: 1},
+			want: &SyntaxError{Msg: "unexpected '\"'", Line: 2, Pos: 1, Err: ErrUnexpectedQuote},
 		},
 		{
 			data: "a=1\nk\"ey=bar",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "unexpected '\"'", Line: 2, Pos: 2},
+			want: &SyntaxError{Msg: "unexpected '\"'", Line: 2, Pos: 2, Err: ErrUnexpectedQuote},
 		},
 		{
 			data: "a=1\nk=b\"ar",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "unexpected '\"'", Line: 2, Pos: 4},
+			want: &SyntaxError{Msg: "unexpected '\"'", Line: 2, Pos: 4, Err: ErrUnexpectedQuote},
 		},
 		{
 			data: "a=1\nk=b =ar",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "unexpected '='", Line: 2, Pos: 5},
+			want: &SyntaxError{Msg: "unexpected '='", Line: 2, Pos: 5, Err: ErrUnexpectedEquals},
 		},
 		{
 			data: "a==",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "unexpected '='", Line: 1, Pos: 3},
+			want: &SyntaxError{Msg: "unexpected '='", Line: 1, Pos: 3, Err: ErrUnexpectedEquals},
 		},
 		{
 			data: "a=1\nk=b=ar",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "unexpected '='", Line: 2, Pos: 4},
+			want: &SyntaxError{Msg: "unexpected '='", Line: 2, Pos: 4, Err: ErrUnexpectedEquals},
 		},
 		{
 			data: "a=\"1",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 5},
+			want: &SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 5, Err: ErrUnterminatedQuote},
 		},
 		{
 			data: "a=\"1\\",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 6},
+			want: &SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 6, Err: ErrUnterminatedQuote},
 		},
 		{
 			data: "a=\"\\t1",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 7},
+			want: &SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 7, Err: ErrUnterminatedQuote},
 		},
 		{
 			data: "a=\"\\u1\"",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "invalid quoted value", Line: 1, Pos: 8},
+			want: &SyntaxError{Msg: "invalid quoted value", Line: 1, Pos: 8, Err: ErrInvalidQuotedValue},
 		},
 		{
 			data: "a\ufffd=bar",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "invalid key", Line: 1, Pos: 5},
+			want: &SyntaxError{Msg: "invalid key", Line: 1, Pos: 5, Err: ErrInvalidKey},
 		},
 		{
 			data: "\x80=bar",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "invalid key", Line: 1, Pos: 2},
+			want: &SyntaxError{Msg: "invalid key", Line: 1, Pos: 2, Err: ErrInvalidKey},
 		},
 		{
 			data: "\x80",
 			dec:  defaultDecoder,
-			want: &SyntaxError{Msg: "invalid key", Line: 1, Pos: 2},
+			want: &SyntaxError{Msg: "invalid key", Line: 1, Pos: 2, Err: ErrInvalidKey},
 		},
 		{
 			data: "a=1\nb=2",
@@ -237,7 +395,88 @@ func TestDecoder_errors(t *testing.T) {
 				dec := NewDecoderSize(strings.NewReader(s), 1)
 				return dec
 			},
-			want: bufio.ErrTooLong,
+			want: &SyntaxError{Msg: "line too long", Line: 1, Pos: 2, Err: bufio.ErrTooLong},
+		},
+		{
+			data: "a:1 b=2",
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.KeyValueDelimiters = []byte{':'}
+				return dec
+			},
+			want: &SyntaxError{Msg: "unexpected '='", Line: 1, Pos: 6, Err: ErrUnexpectedEquals},
+		},
+		{
+			data: "a=1",
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.KeyValueDelimiters = []byte{'=', ' '}
+				return dec
+			},
+			want: ErrInvalidDelimiter,
+		},
+		{
+			data: "a=1",
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.KeyValueDelimiters = []byte{}
+				return dec
+			},
+			want: ErrInvalidDelimiter,
+		},
+		{
+			data: `a="x""`,
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.DoubledQuoteEscape = true
+				return dec
+			},
+			want: &SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 7, Err: ErrUnterminatedQuote},
+		},
+		{
+			data: `a="\t\t" b="\u0000\u0000\u0000"`,
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.MaxEscapes = 2
+				return dec
+			},
+			want: &SyntaxError{Msg: "too many escapes", Line: 1, Pos: 25, Err: ErrTooManyEscapes},
+		},
+		{
+			data: "a=1 b\u200bc=2",
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.RejectNonPrintableKeys = true
+				return dec
+			},
+			want: &SyntaxError{Msg: "non-printable key", Line: 1, Pos: 10, Err: ErrNonPrintableKey},
+		},
+		{
+			data: "a\x7f",
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.RejectNonPrintableKeys = true
+				return dec
+			},
+			want: &SyntaxError{Msg: "non-printable key", Line: 1, Pos: 3, Err: ErrNonPrintableKey},
+		},
+		{
+			data: `a=1 b="!!notbase64"`,
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.Base64Keys = map[string]bool{"b": true}
+				return dec
+			},
+			want: &SyntaxError{Msg: "invalid base64", Line: 1, Pos: 7, Err: ErrInvalidBase64},
+		},
+		{
+			data: `b=YR`,
+			dec: func(s string) *Decoder {
+				dec := NewDecoder(strings.NewReader(s))
+				dec.Base64Keys = map[string]bool{"b": true}
+				return dec
+			},
+			want: &SyntaxError{Msg: "invalid base64", Line: 1, Pos: 3, Err: ErrInvalidBase64},
 		},
 	}
 
@@ -254,6 +493,35 @@ func TestDecoder_errors(t *testing.T) {
 	}
 }
 
+func TestSyntaxError_Is(t *testing.T) {
+	tests := []struct {
+		data string
+		want error
+	}{
+		{data: "a=1\n=b", want: ErrUnexpectedEquals},
+		{data: "a=\"1\"\"", want: ErrUnexpectedQuote},
+		{data: "a=\"1", want: ErrUnterminatedQuote},
+		{data: "a=\"\\x\"", want: ErrInvalidQuotedValue},
+		{data: "\xffa=1", want: ErrInvalidKey},
+	}
+
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(test.data))
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+			}
+		}
+		err := dec.Err()
+		if !errors.Is(err, test.want) {
+			t.Errorf("%q: got %v, want errors.Is %v", test.data, err, test.want)
+		}
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("%q: got %T, want *SyntaxError", test.data, err)
+		}
+	}
+}
+
 func TestDecoder_decode_encode(t *testing.T) {
 	tests := []struct {
 		in, out string
@@ -267,7 +535,11 @@ func TestDecoder_decode_encode(t *testing.T) {
 		},
 		{
 			"a=1 b=\"bar\" ƒ=2h3s r=\"esc\\t\" d x=sf   ",
-			"a=1 b=bar ƒ=2h3s r=\"esc\\t\" d= x=sf\n",
+			"a=1 b=bar ƒ=2h3s r=\"esc\\t\" d=null x=sf\n",
+		},
+		{
+			"foo=\"\\\\\" bar=1 baz=a\\b\n",
+			"foo=\"\\\\\" bar=1 baz=\"a\\\\b\"\n",
 		},
 	}
 
@@ -275,6 +547,7 @@ func TestDecoder_decode_encode(t *testing.T) {
 		dec := NewDecoder(strings.NewReader(test.in))
 		buf := bytes.Buffer{}
 		enc := NewEncoder(&buf)
+		enc.SetEmitEmptyRecords(true)
 
 		var err error
 	loop:
@@ -300,3 +573,1329 @@ func TestDecoder_decode_encode(t *testing.T) {
 		}
 	}
 }
+
+func TestDecoder_RecordReader(t *testing.T) {
+	const in = "a=1 b=2\n\nc=\"x y\"\n"
+	want := []string{"a=1 b=2\n", "\n", "c=\"x y\"\n"}
+
+	t.Run("ScanRecord", func(t *testing.T) {
+		dec := NewDecoder(strings.NewReader(in))
+		var got []string
+		for dec.ScanRecord() {
+			buf := &bytes.Buffer{}
+			if _, err := io.Copy(buf, dec.RecordReader()); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, buf.String())
+		}
+		if err := dec.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("\n got: %q\nwant: %q", got, want)
+		}
+	})
+
+	t.Run("reuse", func(t *testing.T) {
+		dec := NewDecoder(strings.NewReader(in))
+		r := dec.RecordReader()
+		var got []string
+		for {
+			buf := &bytes.Buffer{}
+			n, err := io.Copy(buf, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				break
+			}
+			got = append(got, buf.String())
+		}
+		if err := dec.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("\n got: %q\nwant: %q", got, want)
+		}
+		if dec.ScanRecord() {
+			t.Error("ScanRecord: got true after input exhausted")
+		}
+	})
+
+	t.Run("multiline", func(t *testing.T) {
+		dec := NewDecoder(strings.NewReader("a=\"x\ny\" b=1\nc=2\n"))
+		dec.SetAllowMultilineValues(true)
+		var got []string
+		for dec.ScanRecord() {
+			buf := &bytes.Buffer{}
+			if _, err := io.Copy(buf, dec.RecordReader()); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, buf.String())
+		}
+		if want := []string{"a=\"x\ny\" b=1\n", "c=2\n"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("\n got: %q\nwant: %q", got, want)
+		}
+	})
+
+	t.Run("small reads", func(t *testing.T) {
+		dec := NewDecoder(strings.NewReader(in))
+		if !dec.ScanRecord() {
+			t.Fatal("ScanRecord: got false")
+		}
+		if !dec.ScanKeyval() || string(dec.Key()) != "a" {
+			t.Fatalf("ScanKeyval: got key %q", dec.Key())
+		}
+		got, err := io.ReadAll(iotest.OneByteReader(dec.RecordReader()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want[0] {
+			t.Errorf("got %q, want %q", got, want[0])
+		}
+		if !dec.ScanRecord() || dec.ScanKeyval() {
+			t.Error("want second, empty, record")
+		}
+	})
+}
+
+func TestNewDecoderMulti(t *testing.T) {
+	tests := []struct {
+		name string
+		rs   []io.Reader
+		want [][]kv
+	}{
+		{
+			name: "missing newlines",
+			rs: []io.Reader{
+				strings.NewReader("a=1\nb=2"),
+				strings.NewReader("c=3"),
+				strings.NewReader(""),
+				strings.NewReader("d=4\n"),
+				strings.NewReader("e=5"),
+			},
+			want: [][]kv{
+				{{[]byte("a"), []byte("1")}},
+				{{[]byte("b"), []byte("2")}},
+				{{[]byte("c"), []byte("3")}},
+				{{[]byte("d"), []byte("4")}},
+				{{[]byte("e"), []byte("5")}},
+			},
+		},
+		{
+			name: "one byte reads",
+			rs: []io.Reader{
+				iotest.OneByteReader(strings.NewReader("a=1")),
+				iotest.DataErrReader(strings.NewReader("b=2")),
+			},
+			want: [][]kv{
+				{{[]byte("a"), []byte("1")}},
+				{{[]byte("b"), []byte("2")}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got [][]kv
+			dec := NewDecoderMulti(test.rs...)
+			for dec.ScanRecord() {
+				var kvs []kv
+				for dec.ScanKeyval() {
+					kvs = append(kvs, kv{dec.Key(), dec.Value()})
+				}
+				got = append(got, kvs)
+			}
+			if err := dec.Err(); err != nil {
+				t.Errorf("got err: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("\n got: %+v\nwant: %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMultiRecordReader(t *testing.T) {
+	mr := &multiRecordReader{rs: []io.Reader{
+		strings.NewReader("a"),
+		strings.NewReader("b\n"),
+		strings.NewReader("c"),
+	}}
+	got, err := io.ReadAll(iotest.OneByteReader(mr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a\nb\nc\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecoder_CopyTokens(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1 b=\"x\\ty\" c\nd=2\n"))
+	dec.CopyTokens = true
+
+	var got []kv
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			got = append(got, kv{dec.Key(), dec.Value()})
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []kv{
+		{[]byte("a"), []byte("1")},
+		{[]byte("b"), []byte("x\ty")},
+		{[]byte("c"), nil},
+		{[]byte("d"), []byte("2")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\n got: %+v\nwant: %+v", got, want)
+	}
+	if k := got[0].k; cap(k) != len(k) {
+		t.Errorf("key capacity: got %d, want %d", cap(k), len(k))
+	}
+}
+
+func TestDecoder_Release(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1\nb=2\n"))
+	dec.CopyTokens = true
+
+	var got []string
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			got = append(got, string(dec.Key())+"="+string(dec.Value()))
+		}
+		dec.Release()
+		if dec.Key() != nil || dec.Value() != nil {
+			t.Error("Key or Value not nil after Release")
+		}
+	}
+	if want := []string{"a=1", "b=2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecoder_MultiValue(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("tag=a id=1 tag=b host=h tag=\"c d\" id=2 tag\nid=3\n"))
+	dec.CoalesceDuplicates = true
+
+	if !dec.ScanRecord() {
+		t.Fatal("ScanRecord: got false")
+	}
+	for dec.ScanKeyval() {
+	}
+	tests := []struct {
+		key  string
+		want [][]byte
+	}{
+		{key: "tag", want: [][]byte{[]byte("a"), []byte("b"), []byte("c d"), nil}},
+		{key: "id", want: [][]byte{[]byte("1"), []byte("2")}},
+		{key: "host", want: [][]byte{[]byte("h")}},
+		{key: "missing", want: nil},
+	}
+	for _, test := range tests {
+		if got := dec.MultiValue([]byte(test.key)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.key, got, test.want)
+		}
+	}
+
+	if !dec.ScanRecord() {
+		t.Fatal("ScanRecord: got false")
+	}
+	for dec.ScanKeyval() {
+	}
+	if got := dec.MultiValue([]byte("tag")); got != nil {
+		t.Errorf("tag: got %q, want nil", got)
+	}
+	if got, want := dec.MultiValue([]byte("id")), [][]byte{[]byte("3")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("id: got %q, want %q", got, want)
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecoder_KeyAliases(t *testing.T) {
+	in := "msg=a message=b text=c level=info lvl=warn severity\n"
+	dec := NewDecoder(strings.NewReader(in))
+	dec.KeyAliases = map[string]string{
+		"message":  "msg",
+		"text":     "msg",
+		"lvl":      "level",
+		"level":    "severity",
+		"severity": "level",
+	}
+
+	var got []kv
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			got = append(got, kv{dec.Key(), dec.Value()})
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []kv{
+		{[]byte("msg"), []byte("a")},
+		{[]byte("msg"), []byte("b")},
+		{[]byte("msg"), []byte("c")},
+		{[]byte("severity"), []byte("info")},
+		{[]byte("level"), []byte("warn")},
+		{[]byte("level"), nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDecoder_KeyAliasesNoAlloc(t *testing.T) {
+	in := strings.Repeat("a=1 b=2 c=3\n", 200)
+	dec := NewDecoder(strings.NewReader(in))
+	dec.KeyAliases = map[string]string{"message": "msg"}
+	dec.ScanRecord()
+	allocs := testing.AllocsPerRun(100, func() {
+		dec.ScanRecord()
+		for dec.ScanKeyval() {
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs per record, want 0", allocs)
+	}
+}
+
+func TestDecoder_MaxKeysPerRecord(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want error
+	}{
+		{in: "a=1 b=2 c=3", max: 0},
+		{in: "a=1 b=2 c=3", max: 3},
+		{in: "a=1 b=2 a=3 c b=4", max: 3},
+		{in: "a=1 b=2 c=3 d=4", max: 3, want: &SyntaxError{Msg: "too many keys", Line: 1, Pos: 13, Err: ErrTooManyKeys}},
+		{in: "a=1 a=2 a=3\nb=1 c=2", max: 2},
+		{in: "a=1\nb=1 c=2 d", max: 2, want: &SyntaxError{Msg: "too many keys", Line: 2, Pos: 9, Err: ErrTooManyKeys}},
+	}
+
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(test.in))
+		dec.MaxKeysPerRecord = test.max
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+			}
+		}
+		if got := dec.Err(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q, %d: got %v, want %v", test.in, test.max, got, test.want)
+		}
+	}
+}
+
+func TestDecoder_Reset(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1\nb=\"unterminated\n"))
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+		}
+	}
+	if dec.Err() == nil {
+		t.Fatal("got nil error, want syntax error")
+	}
+
+	dec.Reset(strings.NewReader("c=3\nd=\"x\n"))
+	var got []kv
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			got = append(got, kv{dec.Key(), dec.Value()})
+		}
+	}
+	if want := []kv{{[]byte("c"), []byte("3")}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	want := &SyntaxError{Msg: "unterminated quoted value", Line: 2, Pos: 5, Err: ErrUnterminatedQuote}
+	if err := dec.Err(); !reflect.DeepEqual(err, want) {
+		t.Errorf("got %v, want %v", err, want)
+	}
+
+	dec.Reset(strings.NewReader("e=5"))
+	if !dec.ScanRecord() || !dec.ScanKeyval() || string(dec.Key()) != "e" {
+		t.Errorf("after second Reset: got key %q, err %v", dec.Key(), dec.Err())
+	}
+}
+
+func TestDecoder_ResetSize(t *testing.T) {
+	dec := NewDecoderSize(strings.NewReader("a=1\n"), 8)
+	dec.Reset(strings.NewReader("a=123456789\n"))
+	for dec.ScanRecord() {
+	}
+	if err := dec.Err(); !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("got %v, want %v", err, bufio.ErrTooLong)
+	}
+}
+
+func TestDecoder_SetMaxLineLength(t *testing.T) {
+	long := strings.Repeat("x", bufio.MaxScanTokenSize)
+	data := "a=1\nb=" + long + "\nc=3\n"
+
+	dec := NewDecoder(strings.NewReader(data))
+	for dec.ScanRecord() {
+	}
+	want := &SyntaxError{Msg: "line too long", Line: 2, Pos: bufio.MaxScanTokenSize + 1, Err: bufio.ErrTooLong}
+	if got := dec.Err(); !reflect.DeepEqual(got, want) {
+		t.Errorf("default: got %v, want %v", got, want)
+	}
+
+	dec = NewDecoder(strings.NewReader(data))
+	dec.SetMaxLineLength(2 * bufio.MaxScanTokenSize)
+	var got []string
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			got = append(got, string(dec.Key()))
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatalf("raised limit: unexpected error: %v", err)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("raised limit: got keys %q, want %q", got, want)
+	}
+
+	dec = NewDecoder(strings.NewReader("a=1\nb=2\nc=123456789\n"))
+	dec.SetMaxLineLength(8)
+	for dec.ScanRecord() {
+	}
+	want = &SyntaxError{Msg: "line too long", Line: 3, Pos: 9, Err: bufio.ErrTooLong}
+	if got := dec.Err(); !reflect.DeepEqual(got, want) {
+		t.Errorf("lowered limit: got %v, want %v", got, want)
+	}
+}
+
+func TestDecoder_ResetAllocs(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(""))
+	r := strings.NewReader("")
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset("a=1 b=\"x y\"\nc=2\n")
+		dec.Reset(r)
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs per Reset, want 0", allocs)
+	}
+}
+
+func TestDecoder_SetRecordSeparator(t *testing.T) {
+	tests := []struct {
+		in   string
+		sep  byte
+		want [][]kv
+	}{
+		{
+			in:  "a=1\x1eb=\"x\ny\"\x1e",
+			sep: 0x1e,
+			want: [][]kv{
+				{{[]byte("a"), []byte("1")}},
+				{{[]byte("b"), []byte("x\ny")}},
+			},
+		},
+		{
+			in:  "a=1\x00\x00b=2",
+			sep: 0,
+			want: [][]kv{
+				{{[]byte("a"), []byte("1")}},
+				nil,
+				{{[]byte("b"), []byte("2")}},
+			},
+		},
+		{
+			in:  "a=1\r\nb=2\n",
+			sep: '\n',
+			want: [][]kv{
+				{{[]byte("a"), []byte("1")}},
+				{{[]byte("b"), []byte("2")}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(test.in))
+		dec.SetRecordSeparator(test.sep)
+		var got [][]kv
+		for dec.ScanRecord() {
+			var rec []kv
+			for dec.ScanKeyval() {
+				rec = append(rec, kv{dec.Key(), dec.Value()})
+			}
+			got = append(got, rec)
+		}
+		if err := dec.Err(); err != nil {
+			t.Errorf("%q: got err: %v", test.in, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.in, got, test.want)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader("a=1\x1eb=\"2\x1e"))
+	dec.SetRecordSeparator(0x1e)
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+		}
+	}
+	want := &SyntaxError{Msg: "unterminated quoted value", Line: 2, Pos: 5, Err: ErrUnterminatedQuote}
+	if err := dec.Err(); !reflect.DeepEqual(err, want) {
+		t.Errorf("got %v, want %v", err, want)
+	}
+
+	dec = NewDecoder(strings.NewReader("a=1\x1eb=2"))
+	dec.SetRecordSeparator(0x1e)
+	dec.Reset(strings.NewReader("c=3\x1ed=4"))
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, dec.RecordReader()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "c=3\x1e"; got != want {
+		t.Errorf("RecordReader after Reset: got %q, want %q", got, want)
+	}
+}
+
+func TestDecoder_LinePos(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1  b=\"x y\" c\n\n  ƒ=2\n"))
+	if dec.Line() != 0 || dec.Pos() != 0 {
+		t.Errorf("before ScanRecord: got %d:%d, want 0:0", dec.Line(), dec.Pos())
+	}
+
+	type linePos struct {
+		key       string
+		line, pos int
+	}
+	var got []linePos
+	for dec.ScanRecord() {
+		if dec.Pos() != 0 {
+			t.Errorf("line %d: got pos %d before ScanKeyval, want 0", dec.Line(), dec.Pos())
+		}
+		for dec.ScanKeyval() {
+			got = append(got, linePos{string(dec.Key()), dec.Line(), dec.Pos()})
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []linePos{
+		{"a", 1, 1},
+		{"b", 1, 6},
+		{"c", 1, 14},
+		{"ƒ", 3, 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDecoder_Offsets(t *testing.T) {
+	data := "\xef\xbb\xbfa=1  bb=\"x \\\"y\\\"\" c d= \"e f\"=g h=\"\"\ni=1"
+	dec := NewDecoder(strings.NewReader(data))
+	dec.SetAllowQuotedKeys(true)
+	type offsets struct {
+		key, value string
+	}
+	var got []offsets
+	for dec.ScanRecord() {
+		line := dec.LineBytes()
+		for dec.ScanKeyval() {
+			ks, ke := dec.KeyOffset()
+			vs, ve := dec.ValueOffset()
+			got = append(got, offsets{string(line[ks:ke]), string(line[vs:ve])})
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []offsets{
+		{"a", "1"},
+		{"bb", `"x \"y\""`},
+		{"c", ""},
+		{"d", ""},
+		{`"e f"`, "g"},
+		{"h", `""`},
+		{"i", "1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	dec = NewDecoder(strings.NewReader("k v=1"))
+	dec.ScanRecord()
+	dec.ScanKeyval()
+	if s, e := dec.ValueOffset(); s != 1 || e != 1 {
+		t.Errorf("key without value: got offsets %d, %d, want 1, 1", s, e)
+	}
+}
+
+func TestDecoder_Counts(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1 b=2\n# c=3\n\nd=4 e\nf=5 g=\"6"))
+	dec.SetCommentPrefix("#")
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+		}
+	}
+	if dec.Err() == nil {
+		t.Fatal("no error")
+	}
+	if got, want := dec.RecordCount(), 4; got != want {
+		t.Errorf("RecordCount() = %d, want %d", got, want)
+	}
+	if got, want := dec.KeyvalCount(), 5; got != want {
+		t.Errorf("KeyvalCount() = %d, want %d", got, want)
+	}
+
+	dec.Reset(strings.NewReader("a=1\n"))
+	if dec.RecordCount() != 0 || dec.KeyvalCount() != 0 {
+		t.Errorf("after Reset: got counts %d, %d, want 0, 0", dec.RecordCount(), dec.KeyvalCount())
+	}
+	for dec.ScanRecord() {
+		dec.SkipRecord()
+	}
+	if dec.RecordCount() != 1 || dec.KeyvalCount() != 0 {
+		t.Errorf("after SkipRecord: got counts %d, %d, want 1, 0", dec.RecordCount(), dec.KeyvalCount())
+	}
+}
+
+func TestDecoder_KeyStringValueString(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1 b=\"x\\ty\" c\nd=2\n"))
+	m := map[string]string{}
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			m[dec.KeyString()] = dec.ValueString()
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "1", "b": "x\ty", "c": "", "d": "2"}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v, want %v", m, want)
+	}
+}
+
+func TestDecoder_ScanKeyvalString(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1 b=\"x\\ty\" c\nd=2 e=\"3\n"))
+	var got []string
+	for dec.ScanRecord() {
+		for {
+			k, v, ok := dec.ScanKeyvalString()
+			if !ok {
+				if k != "" || v != "" {
+					t.Errorf("got %q, %q with ok false", k, v)
+				}
+				break
+			}
+			got = append(got, k, v)
+		}
+	}
+	want := []string{"a", "1", "b", "x\ty", "c", "", "d", "2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	wantErr := &SyntaxError{Msg: "unterminated quoted value", Line: 2, Pos: 9, Err: ErrUnterminatedQuote}
+	if err := dec.Err(); !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("got error %v, want %v", err, wantErr)
+	}
+}
+
+func TestDecoder_SetLenient(t *testing.T) {
+	in := "a=1 b=\"unterminated\nc=2\n=3 d=4\ne=5 f\"=6\ng=7\n"
+	dec := NewDecoder(strings.NewReader(in))
+	var errs []*SyntaxError
+	dec.SetLenient(func(err *SyntaxError) {
+		errs = append(errs, err)
+	})
+
+	var got [][]kv
+	for dec.ScanRecord() {
+		var rec []kv
+		for dec.ScanKeyval() {
+			rec = append(rec, kv{dec.Key(), dec.Value()})
+		}
+		if dec.ScanKeyval() {
+			t.Errorf("line %d: ScanKeyval after end of record returned true", dec.Line())
+		}
+		got = append(got, rec)
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	want := [][]kv{
+		{{[]byte("a"), []byte("1")}},
+		{{[]byte("c"), []byte("2")}},
+		nil,
+		{{[]byte("e"), []byte("5")}},
+		{{[]byte("g"), []byte("7")}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	wantErrs := []*SyntaxError{
+		{Msg: "unterminated quoted value", Line: 1, Pos: 20, Err: ErrUnterminatedQuote},
+		{Msg: "unexpected '='", Line: 3, Pos: 1, Err: ErrUnexpectedEquals},
+		{Msg: "unexpected '\"'", Line: 4, Pos: 6, Err: ErrUnexpectedQuote},
+	}
+	if !reflect.DeepEqual(errs, wantErrs) {
+		t.Errorf("got errors %v, want %v", errs, wantErrs)
+	}
+}
+
+func TestDecoder_SetLenientTooLong(t *testing.T) {
+	dec := NewDecoderSize(strings.NewReader("a=1\nb=123456789\nc=3\n"), 8)
+	dec.SetLenient(func(err *SyntaxError) {
+		t.Errorf("unexpected syntax error: %v", err)
+	})
+	n := 0
+	for dec.ScanRecord() {
+		n++
+	}
+	if err := dec.Err(); !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("got %v, want %v", err, bufio.ErrTooLong)
+	}
+	if n != 1 {
+		t.Errorf("got %d records, want 1", n)
+	}
+}
+
+func TestDecoder_ClearErr(t *testing.T) {
+	data := "a=1 b=\"2\nc=3\n=d e=5\nf=6"
+	dec := NewDecoder(strings.NewReader(data))
+	var got []kv
+	var errs []error
+	for {
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+				got = append(got, kv{dec.Key(), dec.Value()})
+			}
+		}
+		err := dec.Err()
+		if err == nil {
+			break
+		}
+		errs = append(errs, err)
+		if !dec.ClearErr() {
+			t.Fatalf("ClearErr() = false for %v", err)
+		}
+		if dec.Err() != nil {
+			t.Fatalf("Err() = %v after ClearErr", dec.Err())
+		}
+	}
+	want := []kv{{[]byte("a"), []byte("1")}, {[]byte("c"), []byte("3")}, {[]byte("f"), []byte("6")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	wantErrs := []error{
+		&SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 9, Err: ErrUnterminatedQuote},
+		&SyntaxError{Msg: "unexpected '='", Line: 3, Pos: 1, Err: ErrUnexpectedEquals},
+	}
+	if !reflect.DeepEqual(errs, wantErrs) {
+		t.Errorf("got errors %v, want %v", errs, wantErrs)
+	}
+	if dec.ClearErr() {
+		t.Error("ClearErr() = true with no error")
+	}
+}
+
+func TestDecoder_SetAllowQuotedKeys(t *testing.T) {
+	tests := []struct {
+		data string
+		want []kv
+		err  error
+	}{
+		{data: `"k"=bar`, want: []kv{{[]byte("k"), []byte("bar")}}},
+		{data: `"a key"="a value" b=1`, want: []kv{{[]byte("a key"), []byte("a value")}, {[]byte("b"), []byte("1")}}},
+		{data: `"x\"y\u00e9"=1`, want: []kv{{[]byte(`x"yé`), []byte("1")}}},
+		{data: `"k" "j"= "i"=`, want: []kv{{[]byte("k"), nil}, {[]byte("j"), nil}, {[]byte("i"), nil}}},
+		{data: `a="k"`, want: []kv{{[]byte("a"), []byte("k")}}},
+		{data: `""=v`, err: &SyntaxError{Msg: "invalid key", Line: 1, Pos: 1, Err: ErrInvalidKey}},
+		{data: `a=1 "k=v`, want: []kv{{[]byte("a"), []byte("1")}}, err: &SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 9, Err: ErrUnterminatedQuote}},
+		{data: `"k"x=v`, err: &SyntaxError{Msg: "unexpected 'x'", Line: 1, Pos: 4, Err: ErrUnexpectedDelimiter}},
+		{data: `"k\q"=v`, err: &SyntaxError{Msg: "invalid quoted value", Line: 1, Pos: 6, Err: ErrInvalidQuotedValue}},
+		{data: `k"=v`, err: &SyntaxError{Msg: "unexpected '\"'", Line: 1, Pos: 2, Err: ErrUnexpectedQuote}},
+	}
+
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(test.data))
+		dec.SetAllowQuotedKeys(true)
+		var got []kv
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+				got = append(got, kv{dec.Key(), dec.Value()})
+			}
+		}
+		if err := dec.Err(); !reflect.DeepEqual(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.data, err, test.err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.data, got, test.want)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader(`"k"=bar`))
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+		}
+	}
+	want := &SyntaxError{Msg: "unexpected '\"'", Line: 1, Pos: 1, Err: ErrUnexpectedQuote}
+	if err := dec.Err(); !reflect.DeepEqual(err, want) {
+		t.Errorf("default: got error %v, want %v", err, want)
+	}
+}
+
+func TestDecoder_SetAllowMultilineValues(t *testing.T) {
+	tests := []struct {
+		data  string
+		want  []kv
+		lines []int
+		err   error
+	}{
+		{
+			data:  "a=1 trace=\"panic: x\n\tmain.go:1\n\" b=2\nc=3\n",
+			want:  []kv{{[]byte("a"), []byte("1")}, {[]byte("trace"), []byte("panic: x\n\tmain.go:1\n")}, {[]byte("b"), []byte("2")}, {[]byte("c"), []byte("3")}},
+			lines: []int{1, 1, 1, 4},
+		},
+		{
+			data:  "msg=\"say \\\"hi\\\"\nbye\" n=1\nm=\"one line\"\n",
+			want:  []kv{{[]byte("msg"), []byte("say \"hi\"\nbye")}, {[]byte("n"), []byte("1")}, {[]byte("m"), []byte("one line")}},
+			lines: []int{1, 1, 3},
+		},
+		{
+			data:  "a=1 # \"not a quote\nb=2\n",
+			want:  []kv{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("2")}},
+			lines: []int{1, 2},
+		},
+		{
+			data:  "a=1\nb=\"open\nstill open\n",
+			want:  []kv{{[]byte("a"), []byte("1")}},
+			lines: []int{1},
+			err:   &SyntaxError{Msg: "unterminated quoted value", Line: 2, Pos: 19, Err: ErrUnterminatedQuote},
+		},
+		{
+			data:  "a=\"x\\\ny\"\n",
+			lines: []int{},
+			err:   &SyntaxError{Msg: "invalid quoted value", Line: 1, Pos: 9, Err: ErrInvalidQuotedValue},
+		},
+	}
+
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(test.data))
+		dec.SetAllowMultilineValues(true)
+		dec.InlineCommentMarker = []byte("#")
+		got, lines := []kv(nil), []int{}
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+				got = append(got, kv{dec.Key(), dec.Value()})
+				lines = append(lines, dec.Line())
+			}
+		}
+		if err := dec.Err(); !reflect.DeepEqual(err, test.err) {
+			t.Errorf("%q: got error %v, want %v", test.data, err, test.err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.data, got, test.want)
+		}
+		if !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("%q: got lines %v, want %v", test.data, lines, test.lines)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader("a=\"x\ny\"\n"))
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+		}
+	}
+	want := &SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 5, Err: ErrUnterminatedQuote}
+	if err := dec.Err(); !reflect.DeepEqual(err, want) {
+		t.Errorf("default: got error %v, want %v", err, want)
+	}
+
+	dec = NewDecoderSize(strings.NewReader("a=\"0123456789\n0123456789\n0123456789\n"), 24)
+	dec.SetAllowMultilineValues(true)
+	for dec.ScanRecord() {
+	}
+	want = &SyntaxError{Msg: "line too long", Line: 1, Pos: 25, Err: bufio.ErrTooLong}
+	if err := dec.Err(); !reflect.DeepEqual(err, want) {
+		t.Errorf("too long: got error %v, want %v", err, want)
+	}
+}
+
+func TestNewReaderDecoder(t *testing.T) {
+	big := strings.Repeat("x", 4*bufio.MaxScanTokenSize)
+	data := "a=1\nbig=\"" + big + "\" b=2\nc=3"
+
+	dec := NewReaderDecoder(strings.NewReader(data))
+	var got []kv
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			got = append(got, kv{[]byte(dec.KeyString()), []byte(dec.ValueString())})
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []kv{{[]byte("a"), []byte("1")}, {[]byte("big"), []byte(big)}, {[]byte("b"), []byte("2")}, {[]byte("c"), []byte("3")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d pairs, want %d", len(got), len(want))
+	}
+
+	dec = NewDecoder(strings.NewReader(data))
+	for dec.ScanRecord() {
+	}
+	if err := dec.Err(); !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("NewDecoder: got error %v, want %v", err, bufio.ErrTooLong)
+	}
+}
+
+func TestDecoder_SetTrimUnquotedValues(t *testing.T) {
+	tests := []struct {
+		data       string
+		want, trim []kv
+	}{
+		{
+			data: "k=  v  ",
+			want: []kv{{[]byte("k"), nil}, {[]byte("v"), nil}},
+			trim: []kv{{[]byte("k"), []byte("v")}},
+		},
+		{
+			data: "a= 1 b=\t2\tc=3",
+			want: []kv{{[]byte("a"), nil}, {[]byte("1"), nil}, {[]byte("b"), nil}, {[]byte("2"), nil}, {[]byte("c"), []byte("3")}},
+			trim: []kv{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("2")}, {[]byte("c"), []byte("3")}},
+		},
+		{
+			data: "a=  b=2 d=   ",
+			want: []kv{{[]byte("a"), nil}, {[]byte("b"), []byte("2")}, {[]byte("d"), nil}},
+			trim: []kv{{[]byte("a"), nil}, {[]byte("b"), []byte("2")}, {[]byte("d"), nil}},
+		},
+		{
+			data: "a= # comment",
+			want: []kv{{[]byte("a"), nil}},
+			trim: []kv{{[]byte("a"), nil}},
+		},
+	}
+	for _, test := range tests {
+		for _, trim := range []bool{false, true} {
+			dec := NewDecoder(strings.NewReader(test.data))
+			dec.InlineCommentMarker = []byte("#")
+			dec.SetTrimUnquotedValues(trim)
+			var got []kv
+			for dec.ScanRecord() {
+				for dec.ScanKeyval() {
+					got = append(got, kv{dec.Key(), dec.Value()})
+				}
+			}
+			want := test.want
+			if trim {
+				want = test.trim
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%q, trim %v: got %q, want %q", test.data, trim, got, want)
+			}
+		}
+	}
+}
+
+func TestDecoder_SetSeparatorRunes(t *testing.T) {
+	tests := []struct {
+		data    string
+		allowed string
+		want    []kv
+		pos     int
+	}{
+		{data: "a=1\tb=2", allowed: "", want: []kv{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("2")}}},
+		{data: "a=1 b=\"x\ty\"", allowed: " ", want: []kv{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("x\ty")}}},
+		{data: "a=1\tb=2", allowed: " ", want: []kv{{[]byte("a"), []byte("1")}}, pos: 4},
+		{data: "\ta=1", allowed: " ", pos: 1},
+		{data: "a=1 b \t", allowed: " ", want: []kv{{[]byte("a"), []byte("1")}, {[]byte("b"), nil}}, pos: 7},
+		{data: "a=1\t b=2\v", allowed: " \t", want: []kv{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("2")}}, pos: 9},
+	}
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(test.data))
+		dec.SetSeparatorRunes(test.allowed)
+		var got []kv
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+				got = append(got, kv{dec.Key(), dec.Value()})
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.data, got, test.want)
+		}
+		err := dec.Err()
+		if test.pos == 0 {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %v", test.data, err)
+			}
+			continue
+		}
+		var se *SyntaxError
+		if !errors.As(err, &se) || !errors.Is(err, ErrUnexpectedSeparator) || se.Pos != test.pos {
+			t.Errorf("%q: got error %v, want ErrUnexpectedSeparator at pos %d", test.data, err, test.pos)
+		}
+	}
+}
+
+func TestDecoder_SetLinePrefixFunc(t *testing.T) {
+	data := "2024-01-02T03:04:05Z INFO k1=v1 k2=v2\n# comment\nno prefix\n2024-01-02T03:04:06Z WARN k=\"x\" y\"\n"
+	dec := NewDecoder(strings.NewReader(data))
+	dec.SetCommentPrefix("#")
+	dec.SetLinePrefixFunc(func(line []byte) ([]byte, bool) {
+		fields := bytes.SplitN(line, []byte(" "), 3)
+		if len(fields) < 3 || !bytes.HasPrefix(fields[0], []byte("2024-")) {
+			return nil, false
+		}
+		return fields[2], true
+	})
+
+	type linePos struct {
+		key, value string
+		line, pos  int
+	}
+	var got []linePos
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			got = append(got, linePos{dec.KeyString(), dec.ValueString(), dec.Line(), dec.Pos()})
+		}
+	}
+	want := []linePos{
+		{"k1", "v1", 1, 27},
+		{"k2", "v2", 1, 33},
+		{"k", "x", 4, 27},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var se *SyntaxError
+	if err := dec.Err(); !errors.As(err, &se) || se.Line != 4 || se.Pos != 34 {
+		t.Errorf("got error %v, want syntax error at 4:34", err)
+	}
+
+	// A prefix function that returns a copy yields positions relative to it.
+	dec = NewDecoder(strings.NewReader("[prefix] a=1 b=\"\n"))
+	dec.SetLinePrefixFunc(func(line []byte) ([]byte, bool) {
+		return append([]byte(nil), bytes.TrimPrefix(line, []byte("[prefix] "))...), true
+	})
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+		}
+	}
+	if err := dec.Err(); !errors.As(err, &se) || se.Pos != 8 {
+		t.Errorf("copied rest: got error %v, want syntax error at pos 8", err)
+	}
+}
+
+func TestDecoder_SetNullAsNil(t *testing.T) {
+	data := "a=null b=\"null\" c=nullable d=\"\" e= f null=1"
+	tests := []struct {
+		enable bool
+		want   []kv
+	}{
+		{
+			enable: false,
+			want: []kv{
+				{[]byte("a"), []byte("null")}, {[]byte("b"), []byte("null")}, {[]byte("c"), []byte("nullable")},
+				{[]byte("d"), nil}, {[]byte("e"), nil}, {[]byte("f"), nil}, {[]byte("null"), []byte("1")},
+			},
+		},
+		{
+			enable: true,
+			want: []kv{
+				{[]byte("a"), nil}, {[]byte("b"), []byte("null")}, {[]byte("c"), []byte("nullable")},
+				{[]byte("d"), nil}, {[]byte("e"), nil}, {[]byte("f"), nil}, {[]byte("null"), []byte("1")},
+			},
+		},
+	}
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(data))
+		dec.SetNullAsNil(test.enable)
+		var got []kv
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+				got = append(got, kv{dec.Key(), dec.Value()})
+			}
+		}
+		if err := dec.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("enable %v: got %q, want %q", test.enable, got, test.want)
+		}
+	}
+
+	// Values written by an Encoder round trip.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.EncodeKeyvals("n", nil, "s", "null"); err != nil {
+		t.Fatal(err)
+	}
+	dec := NewDecoder(&buf)
+	dec.SetNullAsNil(true)
+	dec.ScanRecord()
+	var got []kv
+	for dec.ScanKeyval() {
+		got = append(got, kv{dec.Key(), dec.Value()})
+	}
+	if want := []kv{{[]byte("n"), nil}, {[]byte("s"), []byte("null")}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip: got %q, want %q", got, want)
+	}
+}
+
+func TestDecoder_SetSingleQuoteValues(t *testing.T) {
+	tests := []struct {
+		data   string
+		enable bool
+		want   []kv
+		err    error
+	}{
+		{
+			data:   `a='x y' b="p q" c=it's d='' e='say "hi"' f='don\'t\n' g='\u00e9'`,
+			enable: true,
+			want: []kv{
+				{[]byte("a"), []byte("x y")}, {[]byte("b"), []byte("p q")}, {[]byte("c"), []byte("it's")},
+				{[]byte("d"), nil}, {[]byte("e"), []byte(`say "hi"`)}, {[]byte("f"), []byte("don't\n")},
+				{[]byte("g"), []byte("é")},
+			},
+		},
+		{
+			data:   `a='x' b="it's" 'k=1`,
+			enable: true,
+			want:   []kv{{[]byte("a"), []byte("x")}, {[]byte("b"), []byte("it's")}, {[]byte("'k"), []byte("1")}},
+		},
+		{
+			data:   `a='x b=2`,
+			enable: true,
+			err:    &SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 9, Err: ErrUnterminatedQuote},
+		},
+		{
+			data:   `a='x\q'`,
+			enable: true,
+			err:    &SyntaxError{Msg: "invalid quoted value", Line: 1, Pos: 8, Err: ErrInvalidQuotedValue},
+		},
+		{
+			data:   `a="don\'t"`,
+			enable: true,
+			want:   []kv{{[]byte("a"), []byte("don't")}},
+		},
+		{
+			data: `a="don\'t"`,
+			want: []kv{{[]byte("a"), []byte("don't")}},
+		},
+		{
+			data: `a='x b='' c=it's`,
+			want: []kv{{[]byte("a"), []byte("'x")}, {[]byte("b"), []byte("''")}, {[]byte("c"), []byte("it's")}},
+		},
+	}
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(test.data))
+		dec.SetSingleQuoteValues(test.enable)
+		var got []kv
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+				got = append(got, kv{dec.Key(), dec.Value()})
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.data, got, test.want)
+		}
+		if err := dec.Err(); !reflect.DeepEqual(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.data, err, test.err)
+		}
+	}
+}
+
+func TestDecoder_SetSingleQuoteValuesMultiline(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("k='say \"hi' a=1\nb=\"x\ny\" c='x\"'\nd=2"))
+	dec.SetSingleQuoteValues(true)
+	dec.SetAllowMultilineValues(true)
+	var got [][]kv
+	for dec.ScanRecord() {
+		var rec []kv
+		for dec.ScanKeyval() {
+			rec = append(rec, kv{dec.Key(), dec.Value()})
+		}
+		got = append(got, rec)
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]kv{
+		{{[]byte("k"), []byte(`say "hi`)}, {[]byte("a"), []byte("1")}},
+		{{[]byte("b"), []byte("x\ny")}, {[]byte("c"), []byte(`x"`)}},
+		{{[]byte("d"), []byte("2")}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecoder_OnRecordStart(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1\n# comment\nb=2 c=3\n\nd=4"))
+	dec.SetCommentPrefix("#")
+	var events []string
+	dec.OnRecordStart(func(lineNum int) {
+		events = append(events, fmt.Sprintf("start %d", lineNum))
+	})
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			events = append(events, string(dec.Key()))
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start 1", "a", "start 3", "b", "c", "start 4", "start 5", "d"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %q, want %q", events, want)
+	}
+}
+
+func TestDecoder_SkipRecord(t *testing.T) {
+	data := "kind=keep a=1 b=2\nkind=drop c=\"3\nkind=keep d=4\nkind=drop\nkind=keep\n"
+	dec := NewDecoder(strings.NewReader(data))
+	var got []kv
+	for dec.ScanRecord() {
+		if !dec.ScanKeyval() {
+			t.Fatal("empty record")
+		}
+		if string(dec.Value()) == "drop" {
+			dec.SkipRecord()
+			if dec.ScanKeyval() {
+				t.Errorf("ScanKeyval() = true after SkipRecord, key %q", dec.Key())
+			}
+			continue
+		}
+		for dec.ScanKeyval() {
+			got = append(got, kv{dec.Key(), dec.Value()})
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []kv{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("2")}, {[]byte("d"), []byte("4")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDecoder_SkipRecordKeepsErr(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1 =b c=3\nd=4\n"))
+	dec.ScanRecord()
+	for dec.ScanKeyval() {
+	}
+	dec.SkipRecord()
+	if dec.ScanRecord() {
+		t.Error("ScanRecord() = true after a syntax error")
+	}
+	want := &SyntaxError{Msg: "unexpected '='", Line: 1, Pos: 5, Err: ErrUnexpectedEquals}
+	if err := dec.Err(); !reflect.DeepEqual(err, want) {
+		t.Errorf("got %v, want %v", err, want)
+	}
+}
+
+func TestDecoder_ClearErrNotSyntax(t *testing.T) {
+	readErr := errors.New("read error")
+	dec := NewDecoder(iotest.ErrReader(readErr))
+	for dec.ScanRecord() {
+	}
+	if dec.ClearErr() {
+		t.Error("ClearErr() = true for a read error")
+	}
+	if err := dec.Err(); err != readErr {
+		t.Errorf("got %v, want %v", err, readErr)
+	}
+
+	dec = NewDecoderSize(strings.NewReader("a=123456789\nb=1\n"), 8)
+	for dec.ScanRecord() {
+	}
+	if dec.ClearErr() {
+		t.Error("ClearErr() = true for a line too long")
+	}
+	if err := dec.Err(); !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("got %v, want %v", err, bufio.ErrTooLong)
+	}
+}
+
+func TestDecoder_SetRejectDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want error
+	}{
+		{in: "a=1 b=2 c\nb=3 a=4"},
+		{in: "y= d y=g", want: &SyntaxError{Msg: "duplicate key", Line: 1, Pos: 6, Err: ErrDuplicateKey}},
+		{in: "a=1\nb c=2 b", want: &SyntaxError{Msg: "duplicate key", Line: 2, Pos: 7, Err: ErrDuplicateKey}},
+		{in: "k=1 K=2 k=\"3\"", want: &SyntaxError{Msg: "duplicate key", Line: 1, Pos: 9, Err: ErrDuplicateKey}},
+	}
+
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(test.in))
+		dec.SetRejectDuplicateKeys(true)
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+			}
+		}
+		if got := dec.Err(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.in, got, test.want)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader("msg=a message=b"))
+	dec.KeyAliases = map[string]string{"message": "msg"}
+	dec.SetRejectDuplicateKeys(true)
+	dec.ScanRecord()
+	for dec.ScanKeyval() {
+	}
+	want := &SyntaxError{Msg: "duplicate key", Line: 1, Pos: 7, Err: ErrDuplicateKey}
+	if got := dec.Err(); !reflect.DeepEqual(got, want) {
+		t.Errorf("aliased: got %v, want %v", got, want)
+	}
+}
+
+func TestDecoder_SetCommentPrefix(t *testing.T) {
+	in := "# fixture\na=1 b=#2\n  # indented\n\nc=\"#3\"\n#\nd= e=\n"
+	dec := NewDecoder(strings.NewReader(in))
+	dec.SetCommentPrefix("#")
+
+	var got [][]kv
+	var lines []int
+	for dec.ScanRecord() {
+		var rec []kv
+		for dec.ScanKeyval() {
+			rec = append(rec, kv{dec.Key(), dec.Value()})
+		}
+		got = append(got, rec)
+		lines = append(lines, dec.Line())
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]kv{
+		{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("#2")}},
+		nil,
+		{{[]byte("c"), []byte("#3")}},
+		{{[]byte("d"), nil}, {[]byte("e"), nil}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if want := []int{2, 4, 5, 7}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines: got %v, want %v", lines, want)
+	}
+
+	dec = NewDecoder(strings.NewReader("// note\n=x\n"))
+	dec.SetCommentPrefix("//")
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+		}
+	}
+	wantErr := &SyntaxError{Msg: "unexpected '='", Line: 2, Pos: 1, Err: ErrUnexpectedEquals}
+	if err := dec.Err(); !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("got %v, want %v", err, wantErr)
+	}
+}
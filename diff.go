@@ -0,0 +1,109 @@
+package logfmt
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// A DiffEncoder writes logfmt records that contain only the key/value pairs
+// that changed since the previous record it wrote. It is intended for
+// logging slowly changing state with less volume.
+//
+// The first record, and the first record after a call to Reset, establishes
+// the baseline and is written in full. Each subsequent record is compared
+// with the one before it: pairs whose encoded value differs, or whose key
+// was not present before, are written in the order given. Keys present in
+// the previous record but missing from the current one are listed, sorted
+// and separated by spaces, as the value of a final pair keyed by
+// RemovedKey. A record with no changes is not written.
+type DiffEncoder struct {
+	// RemovedKey is the key of the pair that lists removed keys.
+	RemovedKey string
+
+	enc  *Encoder
+	prev map[string]string
+	cur  map[string]string
+
+	pairs   pairList
+	changed pairList
+}
+
+// NewDiffEncoder returns a new DiffEncoder that writes to w. RemovedKey is
+// initialized to "removed".
+func NewDiffEncoder(w io.Writer) *DiffEncoder {
+	return &DiffEncoder{
+		RemovedKey: "removed",
+		enc:        NewEncoder(w),
+	}
+}
+
+// EncodeRecord compares keyvals, a variadic sequence of alternating keys and
+// values, with the previous record and writes the changes as a complete
+// record. Keys and values are handled as by Encoder.EncodeKeyvals.
+func (d *DiffEncoder) EncodeRecord(keyvals ...interface{}) error {
+	if len(keyvals)%2 == 1 {
+		keyvals = append(keyvals, nil)
+	}
+
+	cur := d.cur
+	if cur == nil {
+		cur = map[string]string{}
+	}
+	for k := range cur {
+		delete(cur, k)
+	}
+	d.changed.reset()
+	for i := 0; i < len(keyvals); i += 2 {
+		k, v := keyvals[i], keyvals[i+1]
+
+		d.pairs.reset()
+		err := d.enc.appendPairs(&d.pairs, k, v)
+		if err == ErrUnsupportedKeyType {
+			continue
+		}
+		if isValueError(err) {
+			err = d.enc.appendPairs(&d.pairs, k, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, p := range d.pairs.pairs {
+			key, val := d.pairs.key(p), d.pairs.value(p)
+			cur[string(key)] = string(val)
+			if pv, ok := d.prev[string(key)]; !ok || pv != string(val) {
+				d.changed.add(key, val)
+			}
+		}
+	}
+
+	var removed []string
+	for k := range d.prev {
+		if _, ok := cur[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(removed)
+
+	d.prev, d.cur = cur, d.prev
+
+	if len(d.changed.pairs) == 0 && len(removed) == 0 {
+		return nil
+	}
+	if err := d.enc.writePairs(&d.changed); err != nil {
+		return err
+	}
+	if len(removed) > 0 {
+		if err := d.enc.EncodeKeyval(d.RemovedKey, strings.Join(removed, " ")); err != nil {
+			return err
+		}
+	}
+	return d.enc.EndRecord()
+}
+
+// Reset discards the baseline so that the next record is written in full.
+func (d *DiffEncoder) Reset() {
+	d.prev = nil
+	d.enc.Reset()
+}
@@ -0,0 +1,57 @@
+package logfmt_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestDiffEncoder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	d := logfmt.NewDiffEncoder(buf)
+
+	records := [][]interface{}{
+		{"state", "idle", "conns", 0, "host", "a"},
+		{"state", "idle", "conns", 0, "host", "a"},
+		{"state", "busy", "conns", 3, "host", "a"},
+		{"state", "busy", "conns", 3},
+		{"state", "busy", "conns", 3, "host", "b", "extra", "x y"},
+	}
+	for _, r := range records {
+		if err := d.EncodeRecord(r...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Reset()
+	if err := d.EncodeRecord("state", "busy", "conns", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "state=idle conns=0 host=a\n" +
+		"state=busy conns=3\n" +
+		"removed=host\n" +
+		"host=b extra=\"x y\"\n" +
+		"state=busy conns=3\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestDiffEncoderRemovedKey(t *testing.T) {
+	buf := &bytes.Buffer{}
+	d := logfmt.NewDiffEncoder(buf)
+	d.RemovedKey = "gone"
+
+	if err := d.EncodeRecord("a", 1, "b", 2, "c", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.EncodeRecord("a", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "a=1 b=2 c=3\na=2 gone=\"b c\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got: %q\nwant: %q", got, want)
+	}
+}
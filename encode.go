@@ -3,29 +3,192 @@ package logfmt
 import (
 	"bytes"
 	"encoding"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
+	"net/url"
 	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode/utf8"
 )
 
 // MarshalKeyvals returns the logfmt encoding of keyvals, a variadic sequence
 // of alternating keys and values.
 func MarshalKeyvals(keyvals ...interface{}) ([]byte, error) {
-	buf := &bytes.Buffer{}
-	if err := NewEncoder(buf).EncodeKeyvals(keyvals...); err != nil {
+	buf := getBuffer()
+	defer poolBuffer(buf)
+	enc := GetEncoder(buf)
+	defer PutEncoder(enc)
+	if err := enc.EncodeKeyvals(keyvals...); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	// Copy the result so that buf can be reused.
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// MarshalKeyvalsTo writes the logfmt encoding of keyvals, a variadic
+// sequence of alternating keys and values, to w. Like MarshalKeyvals it does
+// not terminate the record with a newline.
+func MarshalKeyvalsTo(w io.Writer, keyvals ...interface{}) error {
+	return NewEncoder(w).EncodeKeyvals(keyvals...)
+}
+
+// MarshalKeyvalsAppend appends the logfmt encoding of keyvals, a variadic
+// sequence of alternating keys and values, to dst and returns the extended
+// slice, so that several calls may contribute pairs to the same record. A
+// space is written before the first pair unless dst is empty or already ends
+// with a space, another separator, or a newline. Keyvals are encoded as by
+// MarshalKeyvals. If nothing is encoded, or a non-nil error is returned, dst
+// is returned unchanged.
+func MarshalKeyvalsAppend(dst []byte, keyvals ...interface{}) ([]byte, error) {
+	buf := getBuffer()
+	defer poolBuffer(buf)
+	enc := GetEncoder(buf)
+	defer PutEncoder(enc)
+	if err := enc.EncodeKeyvals(keyvals...); err != nil {
+		return dst, err
+	}
+	if buf.Len() == 0 {
+		return dst, nil
+	}
+	if n := len(dst); n > 0 && dst[n-1] > ' ' {
+		dst = append(dst, ' ')
+	}
+	return append(dst, buf.Bytes()...), nil
+}
+
+// AppendKeyval appends the logfmt encoding of key and value to dst and
+// returns the extended slice. No separator is written before the key; the
+// caller is responsible for separating pairs. Keys and values are encoded as
+// by MarshalKeyvals and the same errors are returned for invalid keys and
+// values. If a non-nil error is returned dst is returned unchanged.
+func AppendKeyval(dst []byte, key, value interface{}) ([]byte, error) {
+	pl := pairListPool.Get().(*pairList)
+	defer func() {
+		pl.reset()
+		pairListPool.Put(pl)
+	}()
+	if err := defaultEncoder.appendPairs(pl, key, value); err != nil {
+		return dst, encodeError(key, err)
+	}
+	for i, p := range pl.pairs {
+		if i > 0 {
+			dst = append(dst, ' ')
+		}
+		dst = append(dst, pl.key(p)...)
+		dst = append(dst, '=')
+		dst = append(dst, pl.value(p)...)
+	}
+	return dst, nil
+}
+
+// defaultEncoder provides the default configuration for functions that
+// encode without an Encoder. It must not be modified.
+var defaultEncoder Encoder
+
+var pairListPool = sync.Pool{
+	New: func() interface{} {
+		return &pairList{}
+	},
 }
 
 // An Encoder writes logfmt data to an output stream.
 type Encoder struct {
-	w       io.Writer
-	scratch bytes.Buffer
-	needSep bool
+	// SequenceKey, if not empty, causes the encoder to begin each record
+	// with a key/value pair of SequenceKey and a sequence number. The
+	// sequence starts at 1 and increments with each non-empty record ended
+	// by EndRecord.
+	SequenceKey string
+
+	// StripBOM causes the encoder to remove a leading UTF-8 byte order mark
+	// from string and []byte values before writing them.
+	StripBOM bool
+
+	// MarshalErrorPolicy controls how errors returned by the MarshalText
+	// method of a value are handled.
+	MarshalErrorPolicy MarshalErrorPolicy
+
+	// RejectCR causes the encoder to reject values containing a carriage
+	// return with ErrCRInValue, for the benefit of consumers that split
+	// records on carriage returns.
+	RejectCR bool
+
+	// NonFiniteFloatPolicy controls how floating point values that are
+	// infinite or not a number are handled.
+	NonFiniteFloatPolicy NonFiniteFloatPolicy
+
+	// EmptySliceMarker, if not empty, is written as the value of empty but
+	// non-nil slices, which otherwise write nothing, so that they remain
+	// distinguishable from nil slices in the output.
+	EmptySliceMarker string
+
+	// SchemaOrder, if not nil, fixes the order of keys within each record.
+	// The pairs of a record are buffered until EndRecord, which writes the
+	// pairs with keys in SchemaOrder first, in the listed order, followed by
+	// any other pairs in the order they were encoded.
+	SchemaOrder []string
+
+	// FillMissing causes EndRecord to write a null value for each key in
+	// SchemaOrder that is missing from a non-empty record, keeping columns
+	// aligned.
+	FillMissing bool
+
+	// SortKeys causes the pairs of each record to be buffered until
+	// EndRecord, which writes them sorted by the bytes of their encoded keys.
+	// The sort is stable, so pairs with equal keys remain in the order they
+	// were encoded. When used with SchemaOrder, only the pairs with keys not
+	// in SchemaOrder are sorted.
+	SortKeys bool
+
+	// ElapsedKey, if not empty, causes EndRecord to add a final pair with key
+	// ElapsedKey and the time.Duration elapsed since the start of the record.
+	// A record starts when StartRecord is called or, if it is not called,
+	// when its first pair is encoded.
+	ElapsedKey string
+
+	w          io.Writer
+	scratch    bytes.Buffer
+	needSep    bool
+	formatters map[reflect.Type]func(v interface{}) ([]byte, error)
+	flush      func()
+	framer     recordFramer
+	seq        uint64
+	nilToken   []byte
+	timeLayout string
+	floatFmt   byte
+	floatPrec  int
+	intBase    int
+	intPrefix  string
+	keyPrefix  string
+	badPrefix  bool
+	sanitize   func(string) string
+	keyFilter  func(string) bool
+	valueFmt   func(key string, value interface{}) interface{}
+	maxDepth   int
+	keepCtrl   func(rune) bool
+	bools      *[2]string
+	preferErr  bool
+	align      bool
+	autoNL     bool
+	strict     bool
+	unquoteNil bool
+	emitEmpty  bool
+	quoteKeys  map[string]bool
+	forceQuote bool
+	mu         *sync.Mutex
+	pairSep    []byte
+	start      time.Time
+	now        func() time.Time
+	pairs      pairList
+	pending    pairList
 }
 
 // NewEncoder returns a new encoder that writes to w.
@@ -35,6 +198,99 @@ func NewEncoder(w io.Writer) *Encoder {
 	}
 }
 
+// GetEncoder returns an encoder that writes to w, taken from a pool of
+// encoders if one is available. The encoder is in the same state as one
+// returned by NewEncoder, but may reuse the buffers of an encoder previously
+// passed to PutEncoder, avoiding allocations when an encoder is needed for
+// each record.
+func GetEncoder(w io.Writer) *Encoder {
+	enc := encoderPool.Get().(*Encoder)
+	enc.setWriter(w)
+	return enc
+}
+
+// PutEncoder returns enc, which must have been obtained from GetEncoder, to
+// the pool of encoders. The encoder's writer and settings are cleared, and
+// enc must not be used after calling PutEncoder.
+func PutEncoder(enc *Encoder) {
+	enc.setWriter(nil)
+	encoderPool.Put(enc)
+}
+
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		return &Encoder{}
+	},
+}
+
+// Clone returns a new encoder with the configuration of enc, including the
+// values of its exported fields and the settings made by its setter methods
+// and registered formatters, for example to derive the encoder of a child
+// logger. The clone starts a new record, and its records and sequence
+// numbers are independent of those of enc.
+//
+// The clone writes to w, or to the destination of enc if w is nil, framing
+// records in the same way as enc, so that a clone of an encoder returned by
+// NewBufferedEncoder or NewLengthPrefixedEncoder is buffered or length
+// prefixed in turn. A clone of an encoder returned by NewAsyncEncoder writes
+// to w synchronously; Clone panics if w is nil for such an encoder. A clone of
+// an encoder returned by NewSyncEncoder shares its mutex, so that records
+// written by WriteRecord through either are not interleaved.
+func (enc *Encoder) Clone(w io.Writer) *Encoder {
+	c := *enc
+	c.scratch = bytes.Buffer{}
+	c.pairs, c.pending = pairList{}, pairList{}
+	c.needSep, c.seq, c.start = false, 0, time.Time{}
+	if enc.formatters != nil {
+		c.formatters = make(map[reflect.Type]func(v interface{}) ([]byte, error), len(enc.formatters))
+		for t, fn := range enc.formatters {
+			c.formatters[t] = fn
+		}
+	}
+	if enc.SchemaOrder != nil {
+		c.SchemaOrder = append([]string{}, enc.SchemaOrder...)
+	}
+
+	switch f := enc.framer.(type) {
+	case nil:
+		if w != nil {
+			c.w, c.flush = w, nil
+		}
+	case *bufferWriter:
+		if w == nil {
+			w = f.w
+		}
+		bw := &bufferWriter{w: w}
+		c.w, c.framer = bw, bw
+	case *frameWriter:
+		if w == nil {
+			w = f.w
+		}
+		fw := &frameWriter{w: w}
+		c.w, c.framer = fw, fw
+	default:
+		if w == nil {
+			panic("logfmt: Clone of an async encoder requires a writer")
+		}
+		c.w, c.framer = w, nil
+	}
+	return &c
+}
+
+// setWriter returns enc to the state of an encoder returned by NewEncoder(w),
+// keeping its buffers.
+func (enc *Encoder) setWriter(w io.Writer) {
+	*enc = Encoder{
+		w:       w,
+		scratch: enc.scratch,
+		pairs:   enc.pairs,
+		pending: enc.pending,
+	}
+	enc.scratch.Reset()
+	enc.pairs.reset()
+	enc.pending.reset()
+}
+
 var (
 	space   = []byte(" ")
 	equals  = []byte("=")
@@ -42,37 +298,156 @@ var (
 	null    = []byte("null")
 )
 
+const bom = "\uFEFF"
+
+var bomBytes = []byte(bom)
+
 // EncodeKeyval writes the logfmt encoding of key and value to the stream. A
 // single space is written before the second and subsequent keys in a record.
 // Nothing is written if a non-nil error is returned.
+//
+// Byte slices, including named types such as json.RawMessage, are written
+// as strings, and a nil byte slice is written as null. Empty values returned
+// by Decoder.Value are nil, so they must be replaced by an empty slice to be
+// encoded as empty values again.
+//
+// Slice and array values, other than byte slices, are flattened into one
+// pair per element with keys of the form key[i], so k=[]int{2, 19} is
+// written as k[0]=2 k[1]=19. Elements are encoded as any other value and may
+// be flattened in turn. An empty slice writes nothing and a nil slice is
+// written as null.
+//
+// Struct values are likewise flattened into one pair per exported field with
+// keys of the form key.name. The name is taken from the field's logfmt tag,
+// if present, and is the field name otherwise. Fields tagged logfmt:"-" are
+// skipped. ErrInvalidKey is returned if a name contains runes that are not
+// valid in a key.
+//
+// Map values are flattened into one pair per entry with keys of the form
+// key.mapkey, sorted by mapkey. Map keys are encoded as other keys, except
+// that ErrInvalidKey is returned rather than removing invalid runes. An
+// empty map writes nothing and a nil map is written as null.
+//
+// Values that implement json.Marshaler, including structs and maps, are
+// written as their JSON encoding rather than flattened, quoted as JSON
+// objects and strings always are, unless they also implement
+// encoding.TextMarshaler, error, or fmt.Stringer, which take precedence.
+//
+// ErrCyclicValue is returned if a flattened value contains itself. The
+// depth of flattening may be limited with SetMaxFlattenDepth.
+//
+// The errors ErrNilKey, ErrInvalidKey, ErrUnsupportedKeyType, and
+// ErrUnsupportedValueType are returned wrapped in an EncodeError that
+// identifies the key, and may be tested for with errors.Is.
 func (enc *Encoder) EncodeKeyval(key, value interface{}) error {
-	enc.scratch.Reset()
-	if enc.needSep {
-		if _, err := enc.scratch.Write(space); err != nil {
-			return err
-		}
+	enc.lock()
+	defer enc.unlock()
+	return encodeError(key, enc.encodeKeyval(key, value))
+}
+
+func (enc *Encoder) encodeKeyval(key, value interface{}) error {
+	if enc.ElapsedKey != "" && !enc.needSep && enc.start.IsZero() {
+		enc.start = enc.clock()
+	}
+	enc.pairs.reset()
+	if err := enc.appendPairs(&enc.pairs, key, value); err != nil {
+		return err
+	}
+	return enc.writePairs(&enc.pairs)
+}
+
+// WriteRawKeyval writes key and value to the stream as a key/value pair
+// exactly as given, for forwarding tokens that are already valid logfmt
+// without decoding and re-encoding them.
+//
+// WriteRawKeyval does not validate, quote, or escape key or value, and the
+// caller is responsible for ensuring that key is a valid key and that value
+// is a valid, correctly quoted and escaped value. Invalid input produces
+// output that cannot be decoded, or that decodes to other pairs. The only
+// check made is that key is not empty, and ErrInvalidKey is returned if it
+// is. Options that transform keys or values, such as SetKeyPrefix or
+// SetKeySanitizer, are not applied, but key filters, ordering, and framing
+// are.
+func (enc *Encoder) WriteRawKeyval(key, value []byte) error {
+	enc.lock()
+	defer enc.unlock()
+	if len(key) == 0 {
+		return ErrInvalidKey
+	}
+	if enc.ElapsedKey != "" && !enc.needSep && enc.start.IsZero() {
+		enc.start = enc.clock()
 	}
-	if err := writeKey(&enc.scratch, key); err != nil {
+	enc.pairs.reset()
+	enc.pairs.add(key, value)
+	return enc.writePairs(&enc.pairs)
+}
+
+// EncodeKeyvalOmitEmpty is like EncodeKeyval except that nothing is written,
+// and nil is returned, if value is nil, a nil pointer, an empty string, or an
+// empty slice, array, or map.
+func (enc *Encoder) EncodeKeyvalOmitEmpty(key, value interface{}) error {
+	if isEmptyValue(value) {
+		return nil
+	}
+	return enc.EncodeKeyval(key, value)
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []byte:
+		return len(v) == 0
+	}
+	rvalue := reflect.ValueOf(value)
+	switch rvalue.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return rvalue.IsNil()
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return rvalue.Len() == 0
+	}
+	return false
+}
+
+func (enc *Encoder) writeSequence(w io.Writer) error {
+	if err := writeKey(w, enc.SequenceKey); err != nil {
 		return err
 	}
-	if _, err := enc.scratch.Write(equals); err != nil {
+	if _, err := w.Write(equals); err != nil {
 		return err
 	}
-	if err := writeValue(&enc.scratch, value); err != nil {
+	if _, err := io.WriteString(w, strconv.FormatUint(enc.seq+1, 10)); err != nil {
 		return err
 	}
-	_, err := enc.w.Write(enc.scratch.Bytes())
-	enc.needSep = true
+	_, err := w.Write(enc.separator())
 	return err
 }
 
 // EncodeKeyvals writes the logfmt encoding of keyvals to the stream. Keyvals
 // is a variadic sequence of alternating keys and values. Keys of unsupported
 // type are skipped along with their corresponding value. Values of
-// unsupported type or that cause a MarshalerError are replaced by their error
-// but do not cause EncodeKeyvals to return an error. If a non-nil error is
-// returned some key/value pairs may not have be written.
+// unsupported type, rejected by RejectCR or NonFiniteFloatPolicy, or that
+// cause a MarshalerError are replaced by their error but do not cause
+// EncodeKeyvals to return an error.
+// If a non-nil error is returned some key/value pairs may not have be
+// written. Errors are wrapped in an EncodeError as by EncodeKeyval.
 func (enc *Encoder) EncodeKeyvals(keyvals ...interface{}) error {
+	enc.lock()
+	defer enc.unlock()
+	if err := enc.encodeKeyvals(keyvals); err != nil {
+		return err
+	}
+	if enc.autoNL {
+		return enc.endRecord()
+	}
+	return nil
+}
+
+// encodeKeyvals writes keyvals as described by EncodeKeyvals, without ending
+// the record.
+func (enc *Encoder) encodeKeyvals(keyvals []interface{}) error {
 	if len(keyvals) == 0 {
 		return nil
 	}
@@ -81,21 +456,109 @@ func (enc *Encoder) EncodeKeyvals(keyvals ...interface{}) error {
 	}
 	for i := 0; i < len(keyvals); i += 2 {
 		k, v := keyvals[i], keyvals[i+1]
-		err := enc.EncodeKeyval(k, v)
+		err := enc.encodeKeyval(k, v)
 		if err == ErrUnsupportedKeyType {
 			continue
 		}
-		if _, ok := err.(*MarshalerError); ok || err == ErrUnsupportedValueType {
+		if isValueError(err) {
 			v = err
-			err = enc.EncodeKeyval(k, v)
+			err = enc.encodeKeyval(k, v)
 		}
 		if err != nil {
-			return err
+			return encodeError(k, err)
 		}
 	}
 	return nil
 }
 
+// EncodeMap writes the entries of m to the stream as key/value pairs, in
+// order of their keys, as if by calling EncodeKeyval for each entry. Unlike a
+// map passed as a value, whose entries are flattened into pairs with keys
+// prefixed by the key of the value, the keys of m are written as they are.
+// EncodeMap does not end the record. If a non-nil error is returned some
+// entries may not have been written.
+func (enc *Encoder) EncodeMap(m map[string]interface{}) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	enc.lock()
+	defer enc.unlock()
+	for _, k := range keys {
+		if err := enc.encodeKeyval(k, m[k]); err != nil {
+			return encodeError(k, err)
+		}
+	}
+	return nil
+}
+
+// EncodeError records an invalid key or unsupported value passed to an
+// Encoder method or Marshal function, and the key it was passed with. Err is
+// one of ErrNilKey, ErrInvalidKey, ErrUnsupportedKeyType, or
+// ErrUnsupportedValueType, or an *InvalidKeyError describing a key that
+// contains invalid runes.
+type EncodeError struct {
+	// Key is the key of the pair, if it is a string or []byte, or
+	// implements fmt.Stringer or encoding.TextMarshaler, and is empty
+	// otherwise. It is not sanitized and may itself be invalid.
+	Key string
+
+	// InValue reports whether Err concerns the value of the pair rather
+	// than its key. Invalid keys produced by flattening a value, such as the
+	// keys of a map, are reported as key errors.
+	InValue bool
+
+	Err error
+}
+
+func (e *EncodeError) Error() string {
+	if ike, ok := e.Err.(*InvalidKeyError); e.Key == "" || ok && ike.Key == e.Key {
+		// The key is not known, or Err already names it.
+		return e.Err.Error()
+	}
+	return "key " + strconv.Quote(e.Key) + ": " + e.Err.Error()
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// encodeError wraps err in an EncodeError if it is an error in key or its
+// value.
+func encodeError(key interface{}, err error) error {
+	if _, ok := err.(*InvalidKeyError); ok {
+		return &EncodeError{Key: keyString(key), Err: err}
+	}
+	switch err {
+	case ErrNilKey, ErrInvalidKey, ErrUnsupportedKeyType:
+		return &EncodeError{Key: keyString(key), Err: err}
+	case ErrUnsupportedValueType:
+		return &EncodeError{Key: keyString(key), InValue: true, Err: err}
+	}
+	return err
+}
+
+// keyString returns key as a string for use in an error message, or the
+// empty string if it cannot be rendered.
+func keyString(key interface{}) string {
+	switch k := key.(type) {
+	case string:
+		return k
+	case []byte:
+		return string(k)
+	case fmt.Stringer:
+		if s, ok := safeString(k); ok {
+			return s
+		}
+	case encoding.TextMarshaler:
+		if b, err := safeMarshal(k); err == nil {
+			return string(b)
+		}
+	}
+	return ""
+}
+
 // MarshalerError represents an error encountered while marshaling a value.
 type MarshalerError struct {
 	Type reflect.Type
@@ -106,35 +569,127 @@ func (e *MarshalerError) Error() string {
 	return "error marshaling value of type " + e.Type.String() + ": " + e.Err.Error()
 }
 
-// ErrNilKey is returned by Marshal functions and Encoder methods if a key is
-// a nil interface or pointer value.
+// MarshalErrorPolicy specifies how an Encoder handles an error returned by
+// the MarshalText method of a value.
+type MarshalErrorPolicy int
+
+const (
+	// MarshalErrorPropagate returns the error, wrapped in a MarshalerError,
+	// from the Encoder method. This is the default.
+	MarshalErrorPropagate MarshalErrorPolicy = iota
+
+	// MarshalErrorPlaceholder writes the value as "!ERROR:" followed by the
+	// error message in place of the marshaled text, so that the rest of the
+	// record is still written.
+	MarshalErrorPlaceholder
+)
+
+// NonFiniteFloatPolicy specifies how an Encoder handles float32 and float64
+// values that are infinite or NaN.
+type NonFiniteFloatPolicy int
+
+const (
+	// NonFiniteFloatToken writes the values as formatted by package fmt:
+	// +Inf, -Inf, and NaN. This is the default.
+	NonFiniteFloatToken NonFiniteFloatPolicy = iota
+
+	// NonFiniteFloatNull writes the values as the nil value token.
+	NonFiniteFloatNull
+
+	// NonFiniteFloatError returns ErrNonFiniteFloat from the Encoder method.
+	NonFiniteFloatError
+)
+
+func marshalErrorPlaceholder(err error) string {
+	if me, ok := err.(*MarshalerError); ok {
+		err = me.Err
+	}
+	return "!ERROR:" + err.Error()
+}
+
+// ErrNilKey is returned by Marshal functions and Encoder methods, wrapped in
+// an EncodeError, if a key is a nil interface or pointer value.
 var ErrNilKey = errors.New("nil key")
 
-// ErrInvalidKey is returned by Marshal functions and Encoder methods if, after
-// dropping invalid runes, a key is empty.
+// ErrInvalidKey is returned by Marshal functions and Encoder methods, wrapped
+// in an EncodeError, if, after dropping invalid runes, a key is empty. It is
+// also wrapped by a SyntaxError for a decoded key that is not valid UTF-8.
 var ErrInvalidKey = errors.New("invalid key")
 
-// ErrUnsupportedKeyType is returned by Encoder methods if a key has an
-// unsupported type.
+// An InvalidKeyError describes a key that was rejected because of the runes
+// it contains. It wraps ErrInvalidKey, so errors.Is(err, ErrInvalidKey)
+// reports true for it.
+type InvalidKeyError struct {
+	// Key is the key as rendered to text, for example by its String or
+	// MarshalText method, before any invalid runes were removed.
+	Key string
+
+	// Index is the byte index in Key of the first invalid rune, or -1 if
+	// Key is empty.
+	Index int
+}
+
+func (e *InvalidKeyError) Error() string {
+	if e.Index < 0 {
+		return "invalid key " + strconv.Quote(e.Key)
+	}
+	r, _ := utf8.DecodeRuneInString(e.Key[e.Index:])
+	return fmt.Sprintf("invalid key %q: invalid rune %q at index %d", e.Key, r, e.Index)
+}
+
+func (e *InvalidKeyError) Unwrap() error {
+	return ErrInvalidKey
+}
+
+// ErrUnsupportedKeyType is returned by Encoder methods, wrapped in an
+// EncodeError, if a key has an unsupported type.
 var ErrUnsupportedKeyType = errors.New("unsupported key type")
 
-// ErrUnsupportedValueType is returned by Encoder methods if a value has an
-// unsupported type.
+// ErrUnsupportedValueType is returned by Encoder methods, wrapped in an
+// EncodeError, if a value has an unsupported type.
 var ErrUnsupportedValueType = errors.New("unsupported value type")
 
+// ErrCyclicValue is returned by Encoder methods if a struct, map, or slice
+// value being flattened contains itself.
+var ErrCyclicValue = errors.New("cyclic value")
+
+// ErrInvalidNilValue is returned by Encoder.SetNilValue if the token would
+// require quoting.
+var ErrInvalidNilValue = errors.New("invalid nil value token")
+
+// ErrInvalidPairSeparator is returned by Encoder.SetPairSeparator if the
+// separator is empty or contains a character that would break the format.
+var ErrInvalidPairSeparator = errors.New("invalid pair separator")
+
+// ErrNonFiniteFloat is returned by Encoder methods if NonFiniteFloatPolicy
+// is NonFiniteFloatError and a value is an infinite or NaN float.
+var ErrNonFiniteFloat = errors.New("non-finite float value")
+
+// ErrCRInValue is returned by Encoder methods if RejectCR is set and a value
+// contains a carriage return.
+var ErrCRInValue = errors.New("carriage return in value")
+
 func writeKey(w io.Writer, key interface{}) error {
+	return encodeKey(w, key, false)
+}
+
+// encodeKey writes key to w. Invalid key runes are removed from the key
+// unless strict is true, in which case ErrInvalidKey is returned instead.
+func encodeKey(w io.Writer, key interface{}, strict bool) error {
 	if key == nil {
 		return ErrNilKey
 	}
 
 	switch k := key.(type) {
 	case string:
-		return writeStringKey(w, k)
+		return writeStringKey(w, k, strict)
 	case []byte:
 		if k == nil {
 			return ErrNilKey
 		}
-		return writeBytesKey(w, k)
+		return writeBytesKey(w, k, strict)
+	case KeyBuilder:
+		return k.writeKey(w)
 	case encoding.TextMarshaler:
 		kb, err := safeMarshal(k)
 		if err != nil {
@@ -143,13 +698,13 @@ func writeKey(w io.Writer, key interface{}) error {
 		if kb == nil {
 			return ErrNilKey
 		}
-		return writeBytesKey(w, kb)
+		return writeBytesKey(w, kb, strict)
 	case fmt.Stringer:
 		ks, ok := safeString(k)
 		if !ok {
 			return ErrNilKey
 		}
-		return writeStringKey(w, ks)
+		return writeStringKey(w, ks, strict)
 	default:
 		rkey := reflect.ValueOf(key)
 		switch rkey.Kind() {
@@ -159,9 +714,9 @@ func writeKey(w io.Writer, key interface{}) error {
 			if rkey.IsNil() {
 				return ErrNilKey
 			}
-			return writeKey(w, rkey.Elem().Interface())
+			return encodeKey(w, rkey.Elem().Interface(), strict)
 		}
-		return writeStringKey(w, fmt.Sprint(k))
+		return writeStringKey(w, fmt.Sprint(k), strict)
 	}
 }
 
@@ -176,82 +731,623 @@ func keyRuneFilter(r rune) rune {
 	return r
 }
 
-func writeStringKey(w io.Writer, key string) error {
+func writeStringKey(w io.Writer, key string, strict bool) error {
 	k := strings.Map(keyRuneFilter, key)
-	if k == "" {
-		return ErrInvalidKey
+	if k == "" || strict && len(k) != len(key) {
+		return &InvalidKeyError{Key: key, Index: strings.IndexFunc(key, isInvalidKeyRune)}
 	}
 	_, err := io.WriteString(w, k)
 	return err
 }
 
-func writeBytesKey(w io.Writer, key []byte) error {
+func writeBytesKey(w io.Writer, key []byte, strict bool) error {
 	k := bytes.Map(keyRuneFilter, key)
-	if len(k) == 0 {
-		return ErrInvalidKey
+	if len(k) == 0 || strict && len(k) != len(key) {
+		return &InvalidKeyError{Key: string(key), Index: bytes.IndexFunc(key, isInvalidKeyRune)}
 	}
 	_, err := w.Write(k)
 	return err
 }
 
-func writeValue(w io.Writer, value interface{}) error {
+// SetNilValue sets the token written for nil values, nil pointers, and nil
+// results from MarshalText to token in place of the default null. String
+// values equal to token are quoted to distinguish them from nil. It returns
+// ErrInvalidNilValue, and leaves the token unchanged, if token would require
+// quoting.
+func (enc *Encoder) SetNilValue(token string) error {
+	if strings.IndexFunc(token, needsQuotedValueRune) != -1 {
+		return ErrInvalidNilValue
+	}
+	enc.nilToken = []byte(token)
+	return nil
+}
+
+// SetTimeFormat sets the layout, as accepted by time.Time.Format, used to
+// format time.Time and *time.Time values. The default layout is
+// time.RFC3339Nano. Formatted times are quoted if the layout produces
+// characters that require it.
+func (enc *Encoder) SetTimeFormat(layout string) {
+	enc.timeLayout = layout
+}
+
+// SetEmitEmptyRecords sets whether EndRecord ends a record in which no pairs
+// have been written, writing an empty line, or an empty frame for encoders
+// that frame records. By default empty records are not written. Callers that
+// rely on each EndRecord producing a line, for example to preserve record
+// boundaries when copying a stream, should enable it.
+func (enc *Encoder) SetEmitEmptyRecords(emit bool) {
+	enc.emitEmpty = emit
+}
+
+// SetAutoNewline sets whether each call to EncodeKeyvals with a non-empty
+// keyvals ends the record as if by a call to EndRecord, so that each call
+// writes one complete record. The record also includes any pairs written by
+// EncodeKeyval since the last EndRecord, since they belong to the same record.
+// If no pairs are written, for example because all keys are of unsupported
+// type, the record is empty and is only written as described for EndRecord.
+// The record is not ended if EncodeKeyvals returns an error. EncodeKeyval is
+// not affected, and EndRecord may still be called directly. By default records
+// are only ended by EndRecord.
+func (enc *Encoder) SetAutoNewline(auto bool) {
+	enc.autoNL = auto
+}
+
+// SetErrorPrecedence sets whether values that implement error are always
+// written using their Error method. By default the MarshalText method of a
+// value that implements both error and encoding.TextMarshaler takes
+// precedence. The Error method always takes precedence over the String method
+// of fmt.Stringer.
+func (enc *Encoder) SetErrorPrecedence(preferError bool) {
+	enc.preferErr = preferError
+}
+
+// SetBoolStrings sets the tokens written for the boolean values true and
+// false, for example "yes" and "no", in place of the default true and false.
+// The tokens are written as string values, so they are quoted if necessary.
+func (enc *Encoder) SetBoolStrings(t, f string) {
+	enc.bools = &[2]string{t, f}
+}
+
+func (enc *Encoder) writeBoolValue(w io.Writer, v bool) error {
+	if enc.bools == nil {
+		return enc.writeStringValue(w, strconv.FormatBool(v), true)
+	}
+	if v {
+		return enc.writeStringValue(w, enc.bools[0], true)
+	}
+	return enc.writeStringValue(w, enc.bools[1], true)
+}
+
+// SetKeyPrefix sets a prefix that is prepended to each key, of any type,
+// encoded by enc, including keys flattened from values. The prefix remains in
+// effect across records until changed or cleared by Reset. Invalid key runes
+// are not removed from the prefix; encoding fails with ErrInvalidKey instead.
+func (enc *Encoder) SetKeyPrefix(prefix string) {
+	enc.keyPrefix = prefix
+	enc.badPrefix = strings.IndexFunc(prefix, isInvalidKeyRune) >= 0
+}
+
+// SetKeySanitizer sets a function that rewrites string and fmt.Stringer keys
+// before they are encoded, for example to replace the runes that are not
+// permitted in keys, which are otherwise dropped. The result is encoded as
+// any other key, so runes that are still invalid are dropped, and
+// ErrInvalidKey is returned if no runes remain. Keys of other types, including
+// []byte, encoding.TextMarshaler, and KeyBuilder keys, the key prefix, and the
+// parts of keys added when values are flattened are not passed to fn. Passing
+// nil removes the sanitizer.
+func (enc *Encoder) SetKeySanitizer(fn func(string) string) {
+	enc.sanitize = fn
+}
+
+// SetMaxFlattenDepth limits the nesting of struct, map, slice, and array
+// values that are flattened into separate pairs to n levels. A value nested
+// more deeply is written as a single pair with its value formatted by
+// fmt.Sprint and always quoted, to set it apart from a scalar value, rather
+// than being flattened. A value of
+// n less than 1 removes the limit, which is the default. Regardless of the
+// limit, a value that contains itself, for example through a pointer to an
+// enclosing struct, causes ErrCyclicValue.
+func (enc *Encoder) SetMaxFlattenDepth(n int) {
+	enc.maxDepth = n
+}
+
+// SetEscapePolicy sets a function that decides which control characters, the
+// runes below U+0020, DEL (U+007F), and U+0080 through U+009F, are escaped
+// when they appear in a quoted value, for output to consumers, such as
+// terminals, that interpret them; ANSI color sequences, for example, begin
+// with ESC (U+001B). A control character for which fn returns false is
+// written unchanged within the quotes. The policy does not affect which
+// values are quoted, and quotation marks and backslashes are always escaped.
+// Passing nil restores the default, which escapes all control characters.
+//
+// Output that contains unescaped control characters may not round-trip: a
+// newline ends the record, and the Decoder rejects a quoted value that
+// contains both a control character and an escape sequence. Other parsers
+// may reject such values entirely.
+func (enc *Encoder) SetEscapePolicy(fn func(r rune) bool) {
+	enc.keepCtrl = nil
+	if fn != nil {
+		enc.keepCtrl = func(r rune) bool { return !fn(r) }
+	}
+}
+
+// SetKeyFilter sets a function that decides which pairs are written, for
+// example to omit or allowlist sensitive keys centrally. A pair is skipped,
+// along with its separator, if fn returns false for its key. The key is
+// passed to fn as it would be written, including the key prefix and the
+// parts added when values are flattened, so fn sees the key of each pair of
+// a flattened value and keys of any type in their string form. Passing nil
+// removes the filter.
+func (enc *Encoder) SetKeyFilter(fn func(key string) bool) {
+	enc.keyFilter = fn
+}
+
+// SetValueFormatter sets a function that may replace each value before it is
+// written, for example to truncate long strings or mask secrets centrally.
+// fn is called with the key as it will be written, including the key prefix,
+// and the value passed to the encoder, and returns the value to encode in its
+// place, which is then written as usual. Returning value unchanged leaves the
+// pair as it is. A value that is flattened, such as a map or struct, is
+// passed to fn once as a whole, with the key of the pair before flattening.
+// Passing nil removes the function.
+func (enc *Encoder) SetValueFormatter(fn func(key string, value interface{}) interface{}) {
+	enc.valueFmt = fn
+}
+
+// sanitizeKey returns key rewritten by the key sanitizer, if it applies.
+func (enc *Encoder) sanitizeKey(key interface{}) interface{} {
+	if enc.sanitize == nil {
+		return key
+	}
+	switch k := key.(type) {
+	case string:
+		return enc.sanitize(k)
+	case []byte, encoding.TextMarshaler, KeyBuilder:
+		return key
+	case fmt.Stringer:
+		if ks, ok := safeString(k); ok {
+			return enc.sanitize(ks)
+		}
+	}
+	return key
+}
+
+// SetFloatFormat sets the format and precision, as accepted by
+// strconv.FormatFloat, used to format float32 and float64 values. Each value
+// is formatted with the bit size of its type. A format of 0 restores the
+// default, which formats values as by fmt.Sprint. Formatted values are quoted
+// if the format produces characters that require it.
+func (enc *Encoder) SetFloatFormat(fmtByte byte, prec int) {
+	enc.floatFmt, enc.floatPrec = fmtByte, prec
+}
+
+// SetIntBase sets the base, between 2 and 36, used to format integer values
+// of any signed or unsigned integer kind, for example 16 to write large IDs
+// compactly in hexadecimal. Digits greater than 9 are written as lower case
+// letters and, unless set by SetIntPrefix, no prefix is written. The default
+// base is 10. SetIntBase panics if base is out of range.
+func (enc *Encoder) SetIntBase(base int) {
+	if base < 2 || base > 36 {
+		panic("logfmt: invalid integer base")
+	}
+	enc.intBase = base
+}
+
+// SetIntPrefix sets a prefix, such as "0x", written before the digits of
+// integer values, following the sign of negative values. Values are quoted
+// if the prefix contains characters that require it. The default is no
+// prefix.
+func (enc *Encoder) SetIntPrefix(prefix string) {
+	enc.intPrefix = prefix
+}
+
+// writeIntValue writes the integer value rv using the base and prefix set by
+// SetIntBase and SetIntPrefix.
+func (enc *Encoder) writeIntValue(w io.Writer, rv reflect.Value) error {
+	base := enc.intBase
+	if base == 0 {
+		base = 10
+	}
+	var buf [72]byte
+	b := buf[:0]
+	var u uint64
+	switch rv.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u = rv.Uint()
+	default:
+		i := rv.Int()
+		if i < 0 {
+			b = append(b, '-')
+		}
+		u = uint64(i)
+		if i < 0 {
+			u = -u
+		}
+	}
+	b = append(b, enc.intPrefix...)
+	b = strconv.AppendUint(b, u, base)
+	return enc.writeBytesValue(w, b)
+}
+
+func (enc *Encoder) nilValue() []byte {
+	if enc.nilToken == nil {
+		return null
+	}
+	return enc.nilToken
+}
+
+// RegisterFormatter registers fn as the formatter for values of type t.
+// Values of type t are passed to fn and the returned bytes are written as the
+// value, quoted if necessary, in place of the default formatting. A nil
+// result is written as null. A non-nil error from fn is returned wrapped in a
+// MarshalerError. Registering a nil fn removes any formatter for t.
+func (enc *Encoder) RegisterFormatter(t reflect.Type, fn func(v interface{}) ([]byte, error)) {
+	if fn == nil {
+		delete(enc.formatters, t)
+		return
+	}
+	if enc.formatters == nil {
+		enc.formatters = map[reflect.Type]func(v interface{}) ([]byte, error){}
+	}
+	enc.formatters[t] = fn
+}
+
+// writeValue writes the encoding of value to w. The encoding is chosen by
+// the first of the following that applies to value:
+//
+//  1. a formatter registered for its type by RegisterFormatter
+//  2. the error interface, if SetErrorPrecedence(true) was called
+//  3. nil, string, []byte, bool, time.Time, net.IP, url.URL, GoString, and
+//     Nested values
+//  4. the encoding.TextMarshaler interface
+//  5. the error interface
+//  6. the fmt.Stringer interface
+//  7. the json.Marshaler interface
+//  8. its kind, by reflection
+func (enc *Encoder) writeValue(w io.Writer, value interface{}) error {
+	if value != nil && len(enc.formatters) > 0 {
+		if fn, ok := enc.formatters[reflect.TypeOf(value)]; ok {
+			return enc.writeFormattedValue(w, value, fn)
+		}
+	}
+	if e, ok := value.(error); ok && enc.preferErr {
+		se, ok := safeError(e)
+		return enc.writeStringValue(w, se, ok)
+	}
+	if isNilBytes(value) {
+		// Checked first, as named byte slices such as json.RawMessage may
+		// have methods that encode nil as something else.
+		return enc.writeBytesValue(w, enc.nilValue())
+	}
 	switch v := value.(type) {
 	case nil:
-		return writeBytesValue(w, null)
+		return enc.writeBytesValue(w, enc.nilValue())
 	case string:
-		return writeStringValue(w, v, true)
+		if enc.StripBOM {
+			v = strings.TrimPrefix(v, bom)
+		}
+		return enc.writeStringValue(w, v, true)
 	case []byte:
-		return writeBytesValue(w, v)
+		if enc.StripBOM {
+			v = bytes.TrimPrefix(v, bomBytes)
+		}
+		return enc.writeBytesValue(w, v)
+	case bool:
+		return enc.writeBoolValue(w, v)
+	case time.Time:
+		return enc.writeTimeValue(w, v)
+	case *time.Time:
+		if v == nil {
+			return enc.writeBytesValue(w, enc.nilValue())
+		}
+		return enc.writeTimeValue(w, *v)
+	case goString:
+		_, err := writeQuotedString(w, fmt.Sprintf("%#v", v.v), enc.keepCtrl)
+		return err
+	case nested:
+		return enc.writeNestedValue(w, v.keyvals)
+	case net.IP:
+		if v == nil {
+			return enc.writeBytesValue(w, enc.nilValue())
+		}
+		return enc.writeStringValue(w, v.String(), true)
+	case url.URL:
+		return enc.writeStringValue(w, v.String(), true)
 	case encoding.TextMarshaler:
 		vb, err := safeMarshal(v)
 		if err != nil {
+			if enc.MarshalErrorPolicy == MarshalErrorPlaceholder {
+				return enc.writeStringValue(w, marshalErrorPlaceholder(err), true)
+			}
 			return err
 		}
 		if vb == nil {
-			vb = null
+			vb = enc.nilValue()
 		}
-		return writeBytesValue(w, vb)
+		return enc.writeBytesValue(w, vb)
 	case error:
 		se, ok := safeError(v)
-		return writeStringValue(w, se, ok)
+		return enc.writeStringValue(w, se, ok)
 	case fmt.Stringer:
 		ss, ok := safeString(v)
-		return writeStringValue(w, ss, ok)
+		return enc.writeStringValue(w, ss, ok)
+	case json.Marshaler:
+		vb, err := safeMarshalJSON(v)
+		if err != nil {
+			if enc.MarshalErrorPolicy == MarshalErrorPlaceholder {
+				return enc.writeStringValue(w, marshalErrorPlaceholder(err), true)
+			}
+			return err
+		}
+		if vb == nil {
+			vb = enc.nilValue()
+		}
+		return enc.writeBytesValue(w, vb)
 	default:
 		rvalue := reflect.ValueOf(value)
 		switch rvalue.Kind() {
-		case reflect.Array, reflect.Chan, reflect.Func, reflect.Map, reflect.Slice, reflect.Struct:
+		case reflect.Slice:
+			if rvalue.Type().Elem().Kind() == reflect.Uint8 {
+				// Named byte slice types, such as json.RawMessage.
+				return enc.writeValue(w, rvalue.Bytes())
+			}
+			if rvalue.IsNil() {
+				return enc.writeBytesValue(w, enc.nilValue())
+			}
+			if rvalue.Len() == 0 && enc.EmptySliceMarker != "" {
+				return enc.writeStringValue(w, enc.EmptySliceMarker, true)
+			}
+			return ErrUnsupportedValueType
+		case reflect.Map:
+			if rvalue.IsNil() {
+				return enc.writeBytesValue(w, enc.nilValue())
+			}
+			return ErrUnsupportedValueType
+		case reflect.Array, reflect.Chan, reflect.Func, reflect.Struct:
 			return ErrUnsupportedValueType
 		case reflect.Ptr:
 			if rvalue.IsNil() {
-				return writeBytesValue(w, null)
+				return enc.writeBytesValue(w, enc.nilValue())
+			}
+			return enc.writeValue(w, rvalue.Elem().Interface())
+		case reflect.Bool:
+			return enc.writeBoolValue(w, rvalue.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if enc.intBase != 0 || enc.intPrefix != "" {
+				return enc.writeIntValue(w, rvalue)
+			}
+		case reflect.Float32, reflect.Float64:
+			if f := rvalue.Float(); math.IsInf(f, 0) || math.IsNaN(f) {
+				switch enc.NonFiniteFloatPolicy {
+				case NonFiniteFloatNull:
+					return enc.writeBytesValue(w, enc.nilValue())
+				case NonFiniteFloatError:
+					return ErrNonFiniteFloat
+				}
+			}
+			if enc.floatFmt != 0 {
+				var buf [32]byte
+				b := strconv.AppendFloat(buf[:0], rvalue.Float(), enc.floatFmt, enc.floatPrec, rvalue.Type().Bits())
+				return enc.writeBytesValue(w, b)
 			}
-			return writeValue(w, rvalue.Elem().Interface())
 		}
-		return writeStringValue(w, fmt.Sprint(v), true)
+		return enc.writeStringValue(w, fmt.Sprint(v), true)
 	}
 }
 
+// GoString returns a value that Encoder methods write as the quoted Go-syntax
+// representation of v, as formatted by the %#v verb. It is intended for
+// debugging output of values that have no useful logfmt encoding.
+func GoString(v interface{}) interface{} {
+	return goString{v}
+}
+
+type goString struct {
+	v interface{}
+}
+
+// Nested returns a value that Encoder methods write as a single value
+// holding the logfmt record of keyvals, quoted and escaped so that the outer
+// record remains well-formed; for example, the key "outer" and value
+// Nested("a", 1, "b", 2) are written as outer="a=1 b=2". Decoding the value
+// yields the nested record, which can itself be decoded. The nested pairs are
+// encoded with the value options of the encoder, and keyvals may itself
+// contain Nested values.
+func Nested(keyvals ...interface{}) interface{} {
+	return nested{keyvals}
+}
+
+type nested struct {
+	keyvals []interface{}
+}
+
+func (enc *Encoder) writeNestedValue(w io.Writer, keyvals []interface{}) error {
+	var buf bytes.Buffer
+	sub := &Encoder{
+		w:                    &buf,
+		StripBOM:             enc.StripBOM,
+		MarshalErrorPolicy:   enc.MarshalErrorPolicy,
+		RejectCR:             enc.RejectCR,
+		NonFiniteFloatPolicy: enc.NonFiniteFloatPolicy,
+		EmptySliceMarker:     enc.EmptySliceMarker,
+		formatters:           enc.formatters,
+		nilToken:             enc.nilToken,
+		timeLayout:           enc.timeLayout,
+		floatFmt:             enc.floatFmt,
+		floatPrec:            enc.floatPrec,
+		intBase:              enc.intBase,
+		intPrefix:            enc.intPrefix,
+		keepCtrl:             enc.keepCtrl,
+		bools:                enc.bools,
+		preferErr:            enc.preferErr,
+		strict:               enc.strict,
+		unquoteNil:           enc.unquoteNil,
+	}
+	if err := sub.EncodeKeyvals(keyvals...); err != nil {
+		return err
+	}
+	return enc.writeBytesValue(w, buf.Bytes())
+}
+
+func (enc *Encoder) writeFormattedValue(w io.Writer, value interface{}, fn func(v interface{}) ([]byte, error)) error {
+	vb, err := safeFormat(value, fn)
+	if err != nil {
+		return err
+	}
+	if vb == nil {
+		vb = enc.nilValue()
+	}
+	return enc.writeBytesValue(w, vb)
+}
+
+// needsQuotedValueRune reports whether r requires a value to be quoted. A
+// backslash is quoted, although an unquoted value may contain one, so that
+// the value is not mistaken for an escape sequence by other parsers.
 func needsQuotedValueRune(r rune) bool {
-	return r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError
+	return r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || isControl(r)
+}
+
+// needsStrictQuotedValueRune reports whether r requires a value to be quoted
+// in strict quoting mode.
+func needsStrictQuotedValueRune(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("._-/:", r)
+}
+
+// SetStrictQuoting sets whether values are quoted unless they consist only of
+// ASCII letters and digits and the characters '.', '-', '_', '/', and ':'.
+// Strict quoting guarantees that values are unambiguous to any logfmt parser,
+// at the cost of quoting more values. By default values are only quoted if
+// they contain characters that require it, such as spaces, '=', '"', and
+// '\\'. The token written for nil values, set by SetNilValue, is never quoted.
+func (enc *Encoder) SetStrictQuoting(strict bool) {
+	enc.strict = strict
+}
+
+// SetQuoteNilString sets whether a string value equal to the token written
+// for nil values, null by default or as set by SetNilValue, is quoted to
+// distinguish it from a nil value. The default is true. When it is false
+// such a string is written unquoted, as in k=null, unless it requires
+// quoting for another reason, and can no longer be distinguished from a nil
+// value when decoded.
+func (enc *Encoder) SetQuoteNilString(quote bool) {
+	enc.unquoteNil = !quote
+}
+
+// SetPairSeparator sets the separator written between the pairs of a record
+// in place of the default single space, for example a tab or " | ". It
+// returns ErrInvalidPairSeparator, and leaves the separator unchanged, if sep
+// is empty or contains '=', '"', a newline, or a carriage return.
+//
+// The Decoder accepts any combination of spaces, tabs, and other whitespace
+// and control characters between pairs, but decodes other characters as keys,
+// so output written with a separator such as " | " cannot be decoded by this
+// package without removing the separators first.
+func (enc *Encoder) SetPairSeparator(sep string) error {
+	if sep == "" || strings.ContainsAny(sep, "=\"\n\r") {
+		return ErrInvalidPairSeparator
+	}
+	enc.pairSep = []byte(sep)
+	return nil
+}
+
+// separator returns the separator written between pairs.
+func (enc *Encoder) separator() []byte {
+	if enc.pairSep == nil {
+		return space
+	}
+	return enc.pairSep
+}
+
+// SetAlwaysQuoteKeys sets keys whose values are always written quoted, as in
+// id="12345", even if they contain no characters that require quoting, for
+// consumers that take a quoted value to be a string. Values of other keys are
+// quoted only as necessary. Keys are compared with the encoded key of each
+// pair, after any key prefix, sanitizing, and flattening have been applied.
+// Values of every type are quoted, including numbers and booleans, except
+// that the token written for nil values is not. Each call replaces the keys
+// set by the previous call, and calling it with no keys restores minimal
+// quoting for all keys.
+func (enc *Encoder) SetAlwaysQuoteKeys(keys ...string) {
+	if len(keys) == 0 {
+		enc.quoteKeys = nil
+		return
+	}
+	enc.quoteKeys = make(map[string]bool, len(keys))
+	for _, k := range keys {
+		enc.quoteKeys[k] = true
+	}
+}
+
+// needsQuoting reports whether value must be written quoted.
+func (enc *Encoder) needsQuoting(value string) bool {
+	if enc.forceQuote {
+		return true
+	}
+	if enc.strict {
+		return strings.IndexFunc(value, needsStrictQuotedValueRune) != -1
+	}
+	return strings.IndexFunc(value, needsQuotedValueRune) != -1
+}
+
+// needsQuotingBytes is like needsQuoting for a []byte value.
+func (enc *Encoder) needsQuotingBytes(value []byte) bool {
+	if enc.forceQuote || enc.strict {
+		if bytes.Equal(value, enc.nilValue()) {
+			return false
+		}
+		return enc.forceQuote || bytes.IndexFunc(value, needsStrictQuotedValueRune) != -1
+	}
+	return bytes.IndexFunc(value, needsQuotedValueRune) != -1
+}
+
+// isNilBytes reports whether value is a nil []byte or a nil slice of a
+// named byte slice type.
+func isNilBytes(value interface{}) bool {
+	if b, ok := value.([]byte); ok {
+		return b == nil
+	}
+	rv := reflect.ValueOf(value)
+	return rv.Kind() == reflect.Slice && rv.IsNil() && rv.Type().Elem().Kind() == reflect.Uint8
 }
 
-func writeStringValue(w io.Writer, value string, ok bool) error {
+func (enc *Encoder) writeTimeValue(w io.Writer, t time.Time) error {
+	layout := enc.timeLayout
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	return enc.writeStringValue(w, t.Format(layout), true)
+}
+
+func (enc *Encoder) writeStringValue(w io.Writer, value string, ok bool) error {
+	if enc.RejectCR && strings.IndexByte(value, '\r') != -1 {
+		return ErrCRInValue
+	}
 	var err error
-	if ok && value == "null" {
-		_, err = io.WriteString(w, `"null"`)
-	} else if strings.IndexFunc(value, needsQuotedValueRune) != -1 {
-		_, err = writeQuotedString(w, value)
+	if !ok && value == "null" {
+		// value represents a nil receiver.
+		_, err = w.Write(enc.nilValue())
+	} else if ok && !enc.unquoteNil && value == string(enc.nilValue()) {
+		_, err = writeQuotedString(w, value, enc.keepCtrl)
+	} else if enc.needsQuoting(value) {
+		_, err = writeQuotedString(w, value, enc.keepCtrl)
 	} else {
 		_, err = io.WriteString(w, value)
 	}
 	return err
 }
 
-func writeBytesValue(w io.Writer, value []byte) error {
+func (enc *Encoder) writeBytesValue(w io.Writer, value []byte) error {
+	if enc.RejectCR && bytes.IndexByte(value, '\r') != -1 {
+		return ErrCRInValue
+	}
 	var err error
-	if bytes.IndexFunc(value, needsQuotedValueRune) != -1 {
-		_, err = writeQuotedBytes(w, value)
+	if enc.needsQuotingBytes(value) {
+		_, err = writeQuotedBytes(w, value, enc.keepCtrl)
 	} else {
 		_, err = w.Write(value)
 	}
@@ -259,28 +1355,112 @@ func writeBytesValue(w io.Writer, value []byte) error {
 }
 
 // EndRecord writes a newline character to the stream and resets the encoder
-// to the beginning of a new record.
+// to the beginning of a new record. If no pairs have been written in the
+// current record, EndRecord does nothing, so that conditionally built records
+// that turn out to be empty do not produce blank lines, unless
+// SetEmitEmptyRecords(true) has been called.
 func (enc *Encoder) EndRecord() error {
-	_, err := enc.w.Write(newline)
+	enc.lock()
+	defer enc.unlock()
+	return enc.endRecord()
+}
+
+func (enc *Encoder) endRecord() error {
+	if enc.ElapsedKey != "" && !enc.start.IsZero() {
+		elapsed := enc.clock().Sub(enc.start)
+		enc.start = time.Time{}
+		if err := enc.encodeKeyval(enc.ElapsedKey, elapsed); err != nil {
+			return encodeError(enc.ElapsedKey, err)
+		}
+	}
+	if !enc.needSep && !enc.emitEmpty {
+		return nil
+	}
+	if enc.buffered() {
+		if err := enc.writePending(); err != nil {
+			return err
+		}
+	}
+	var err error
+	if enc.framer != nil {
+		err = enc.framer.endRecord()
+	} else {
+		_, err = enc.w.Write(newline)
+	}
 	if err == nil {
+		if enc.needSep && enc.SequenceKey != "" {
+			enc.seq++
+		}
+		enc.needSep = false
+		if enc.flush != nil {
+			enc.flush()
+		}
+	} else if enc.framer != nil {
+		// The framer has discarded the record, so the next pair starts a
+		// new one.
 		enc.needSep = false
 	}
 	return err
 }
 
-// Reset resets the encoder to the beginning of a new record.
+// StartRecord marks the start of a record for the purpose of measuring its
+// elapsed time. See ElapsedKey.
+func (enc *Encoder) StartRecord() {
+	enc.lock()
+	defer enc.unlock()
+	enc.start = enc.clock()
+}
+
+func (enc *Encoder) clock() time.Time {
+	if enc.now != nil {
+		return enc.now()
+	}
+	return time.Now()
+}
+
+// Reset resets the encoder to the beginning of a new record and clears the
+// key prefix.
 func (enc *Encoder) Reset() {
+	enc.lock()
+	defer enc.unlock()
 	enc.needSep = false
+	enc.SetKeyPrefix("")
+	enc.start = time.Time{}
+	enc.pending.reset()
+	if enc.framer != nil {
+		enc.framer.discard()
+	}
+}
+
+// A recordFramer buffers the output of an Encoder and takes over writing
+// each record, in place of the newline, when the record ends. The buffered
+// record is discarded by endRecord whether or not it is written.
+type recordFramer interface {
+	endRecord() error
+	discard()
+}
+
+// nilReceiverPanic reports whether panicVal, recovered from a call to a
+// method of recv, is the runtime error caused by recv being a nil pointer.
+// Panics raised deliberately by a method, or caused by a nil pointer other
+// than the receiver of a non-nil recv, are not reported, and the callers
+// below panic again with them rather than hide a bug in the method.
+func nilReceiverPanic(recv interface{}, panicVal interface{}) bool {
+	if _, ok := panicVal.(runtime.Error); !ok {
+		return false
+	}
+	v := reflect.ValueOf(recv)
+	return v.Kind() == reflect.Ptr && v.IsNil()
 }
 
 func safeError(err error) (s string, ok bool) {
 	defer func() {
 		if panicVal := recover(); panicVal != nil {
-			if v := reflect.ValueOf(err); v.Kind() == reflect.Ptr && v.IsNil() {
+			if nilReceiverPanic(err, panicVal) {
 				s, ok = "null", false
-			} else {
-				s, ok = fmt.Sprintf("PANIC:%v", panicVal), false
+				return
 			}
+			panic(panicVal)
 		}
 	}()
 	s, ok = err.Error(), true
@@ -290,11 +1470,11 @@ func safeError(err error) (s string, ok bool) {
 func safeString(str fmt.Stringer) (s string, ok bool) {
 	defer func() {
 		if panicVal := recover(); panicVal != nil {
-			if v := reflect.ValueOf(str); v.Kind() == reflect.Ptr && v.IsNil() {
+			if nilReceiverPanic(str, panicVal) {
 				s, ok = "null", false
-			} else {
-				s, ok = fmt.Sprintf("PANIC:%v", panicVal), true
+				return
 			}
+			panic(panicVal)
 		}
 	}()
 	s, ok = str.String(), true
@@ -304,11 +1484,11 @@ func safeString(str fmt.Stringer) (s string, ok bool) {
 func safeMarshal(tm encoding.TextMarshaler) (b []byte, err error) {
 	defer func() {
 		if panicVal := recover(); panicVal != nil {
-			if v := reflect.ValueOf(tm); v.Kind() == reflect.Ptr && v.IsNil() {
+			if nilReceiverPanic(tm, panicVal) {
 				b, err = nil, nil
-			} else {
-				b, err = nil, fmt.Errorf("panic when marshalling: %s", panicVal)
+				return
 			}
+			panic(panicVal)
 		}
 	}()
 	b, err = tm.MarshalText()
@@ -320,3 +1500,39 @@ func safeMarshal(tm encoding.TextMarshaler) (b []byte, err error) {
 	}
 	return
 }
+
+func safeMarshalJSON(jm json.Marshaler) (b []byte, err error) {
+	defer func() {
+		if panicVal := recover(); panicVal != nil {
+			if nilReceiverPanic(jm, panicVal) {
+				b, err = nil, nil
+				return
+			}
+			panic(panicVal)
+		}
+	}()
+	b, err = jm.MarshalJSON()
+	if err != nil {
+		return nil, &MarshalerError{
+			Type: reflect.TypeOf(jm),
+			Err:  err,
+		}
+	}
+	return
+}
+
+func safeFormat(v interface{}, fn func(v interface{}) ([]byte, error)) (b []byte, err error) {
+	defer func() {
+		if panicVal := recover(); panicVal != nil {
+			b, err = nil, fmt.Errorf("panic when formatting: %s", panicVal)
+		}
+	}()
+	b, err = fn(v)
+	if err != nil {
+		return nil, &MarshalerError{
+			Type: reflect.TypeOf(v),
+			Err:  err,
+		}
+	}
+	return
+}
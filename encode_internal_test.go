@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestSafeString(t *testing.T) {
@@ -26,6 +27,63 @@ func TestSafeMarshal(t *testing.T) {
 	}
 }
 
+func TestSafeMarshalPanic(t *testing.T) {
+	data := []struct {
+		name string
+		call func()
+		nil  bool
+	}{
+		{name: "nil receiver", nil: true, call: func() { safeMarshal((*stringMarshaler)(nil)) }},
+		{name: "nil field", call: func() { safeMarshal(&nilFieldMarshaler{}) }},
+		{name: "nil receiver deliberate panic", call: func() { safeMarshal((*panicingMarshaler)(nil)) }},
+		{name: "JSON nil field", call: func() { safeMarshalJSON(&nilFieldMarshaler{}) }},
+		{name: "Stringer nil field", call: func() { safeString(&nilFieldMarshaler{}) }},
+		{name: "error nil field", call: func() { safeError(&nilFieldMarshaler{}) }},
+		{name: "error deliberate panic", call: func() { safeError((*panicingMarshaler)(nil)) }},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			defer func() {
+				if got, want := recover() == nil, d.nil; got != want {
+					t.Errorf("got no panic: %v, want: %v", got, want)
+				}
+			}()
+			d.call()
+		})
+	}
+}
+
+type nilFieldMarshaler struct {
+	inner *stringMarshaler
+}
+
+func (m *nilFieldMarshaler) MarshalText() ([]byte, error) {
+	return []byte(*m.inner), nil
+}
+
+func (m *nilFieldMarshaler) MarshalJSON() ([]byte, error) {
+	return m.MarshalText()
+}
+
+func (m *nilFieldMarshaler) String() string {
+	return string(*m.inner)
+}
+
+func (m *nilFieldMarshaler) Error() string {
+	return string(*m.inner)
+}
+
+type panicingMarshaler struct{}
+
+func (m *panicingMarshaler) MarshalText() ([]byte, error) {
+	panic("deliberate")
+}
+
+func (m *panicingMarshaler) Error() string {
+	panic("deliberate")
+}
+
 func TestWriteKeyStrings(t *testing.T) {
 	keygen := []struct {
 		name string
@@ -77,7 +135,7 @@ func TestWriteKeyStrings(t *testing.T) {
 				w := &bytes.Buffer{}
 				key := g.fn(d.key)
 				err := writeKey(w, key)
-				if err != d.err {
+				if !errors.Is(err, d.err) {
 					t.Errorf("%#v: got error: %v, want error: %v", key, err, d.err)
 				}
 				if err != nil {
@@ -152,13 +210,20 @@ func TestWriteValueStrings(t *testing.T) {
 		{value: "v", want: "v"},
 		{value: " ", want: `" "`},
 		{value: "=", want: `"="`},
-		{value: `\`, want: `\`},
+		{value: `\`, want: `"\\"`},
+		{value: `a\b`, want: `"a\\b"`},
 		{value: `"`, want: `"\""`},
 		{value: `\"`, want: `"\\\""`},
 		{value: "\n", want: `"\n"`},
 		{value: "\x00", want: `"\u0000"`},
 		{value: "\x10", want: `"\u0010"`},
 		{value: "\x1F", want: `"\u001f"`},
+		{value: "\x7f", want: `"\u007f"`},
+		{value: "a\x7fb", want: `"a\u007fb"`},
+		{value: "\u0085", want: `"\u0085"`},
+		{value: "\u009f", want: `"\u009f"`},
+		{value: "\u00a0", want: "\u00a0"},
+		{value: "\x85", want: `"\ufffd"`},
 		{value: "µ", want: `µ`},
 	}
 
@@ -166,7 +231,7 @@ func TestWriteValueStrings(t *testing.T) {
 		for _, d := range data {
 			w := &bytes.Buffer{}
 			value := g(d.value)
-			err := writeValue(w, value)
+			err := (&Encoder{}).writeValue(w, value)
 			if err != d.err {
 				t.Errorf("%#v (%[1]T): got error: %v, want error: %v", value, err, d.err)
 			}
@@ -210,7 +275,7 @@ func TestWriteValue(t *testing.T) {
 
 	for _, d := range data {
 		w := &bytes.Buffer{}
-		err := writeValue(w, d.value)
+		err := (&Encoder{}).writeValue(w, d.value)
 		if !reflect.DeepEqual(err, d.err) {
 			t.Errorf("%#v: got error: %v, want error: %v", d.value, err, d.err)
 		}
@@ -266,8 +331,53 @@ func BenchmarkWriteStringKey(b *testing.B) {
 	for _, k := range keys {
 		b.Run(k, func(b *testing.B) {
 			for i := 0; i < b.N; i++ {
-				writeStringKey(ioutil.Discard, k)
+				writeStringKey(ioutil.Discard, k, false)
 			}
 		})
 	}
 }
+
+func TestEncoderElapsedKey(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tick := func(d time.Duration) { clock = clock.Add(d) }
+
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	enc.ElapsedKey = "elapsed"
+	enc.now = func() time.Time { return clock }
+
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Started explicitly.
+	enc.StartRecord()
+	tick(time.Second)
+	check(enc.EncodeKeyval("a", 1))
+	tick(500 * time.Millisecond)
+	check(enc.EndRecord())
+
+	// Started by the first pair.
+	tick(time.Hour)
+	check(enc.EncodeKeyval("b", 2))
+	tick(3 * time.Millisecond)
+	check(enc.EndRecord())
+
+	// Empty record without StartRecord, which is not written.
+	check(enc.EndRecord())
+
+	// Reset discards the start time.
+	enc.StartRecord()
+	enc.Reset()
+	tick(time.Minute)
+	check(enc.EncodeKeyval("c", 3))
+	check(enc.EndRecord())
+
+	want := "a=1 elapsed=1.5s\nb=2 elapsed=3ms\nc=3 elapsed=0s\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got: %q\nwant: %q", got, want)
+	}
+}
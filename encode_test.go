@@ -2,12 +2,19 @@ package logfmt_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-logfmt/logfmt"
 )
@@ -33,12 +40,33 @@ func TestEncodeKeyval(t *testing.T) {
 		{key: "k", value: " ", want: `k=" "`},
 		{key: "k", value: `"`, want: `k="\""`},
 		{key: "k", value: `=`, want: `k="="`},
-		{key: "k", value: `\`, want: `k=\`},
+		{key: "k", value: `\`, want: `k="\\"`},
 		{key: "k", value: `=\`, want: `k="=\\"`},
 		{key: "k", value: `\"`, want: `k="\\\""`},
-		{key: "k", value: [2]int{2, 19}, err: logfmt.ErrUnsupportedValueType},
-		{key: "k", value: []string{"e1", "e 2"}, err: logfmt.ErrUnsupportedValueType},
-		{key: "k", value: structData{"a a", 9}, err: logfmt.ErrUnsupportedValueType},
+		{key: "k", value: [2]int{2, 19}, want: "k[0]=2 k[1]=19"},
+		{key: "k", value: []string{"e1", "e 2"}, want: `k[0]=e1 k[1]="e 2"`},
+		{key: "k", value: [][]int{{1}, {2, 3}}, want: "k[0][0]=1 k[1][0]=2 k[1][1]=3"},
+		{key: "k", value: []interface{}{nil, &decimalStringer{5, 9}}, want: "k[0]=null k[1]=5.9"},
+		{key: "k", value: []string{}, want: ""},
+		{key: "k", value: []string(nil), want: "k=null"},
+		{key: "k", value: []interface{}{"a", (chan int)(nil)}, err: logfmt.ErrUnsupportedValueType},
+		{key: "k", value: structData{"a a", 9}, want: `k.fieldA="a a" k.B=9`},
+		{key: "k", value: &structData{"a", 1}, want: "k.fieldA=a k.B=1"},
+		{key: "k", value: nestedStructData{In: structData{"x", 2}, Skip: 3, List: []int{4}}, want: "k.in.fieldA=x k.in.B=2 k.List[0]=4"},
+		{key: "k", value: struct{ a, B int }{1, 2}, want: "k.B=2"},
+		{key: "k", value: struct{}{}, want: ""},
+		{key: "k", value: map[string]string{"z": "1", "a": "x y"}, want: `k.a="x y" k.z=1`},
+		{key: "k", value: map[int]bool{10: true, 2: false}, want: "k.10=true k.2=false"},
+		{key: "k", value: map[decimalStringer]int{{1, 2}: 3}, want: "k.1.2=3"},
+		{key: "k", value: map[string]map[string]int{"b": {"y": 2}, "a": {"x": 1}}, want: "k.a.x=1 k.b.y=2"},
+		{key: "k", value: map[string]int{}, want: ""},
+		{key: "k", value: map[string]int(nil), want: "k=null"},
+		{key: "k", value: map[string]int{"a b": 1}, err: logfmt.ErrInvalidKey},
+		{key: "k", value: map[string]int{"": 1}, err: logfmt.ErrInvalidKey},
+		{key: "k", value: map[[1]int]int{{1}: 1}, err: logfmt.ErrUnsupportedKeyType},
+		{key: "k", value: struct {
+			A int `logfmt:"a b"`
+		}{1}, err: logfmt.ErrInvalidKey},
 		{key: "k", value: decimalMarshaler{5, 9}, want: "k=5.9"},
 		{key: "k", value: (*decimalMarshaler)(nil), want: "k=null"},
 		{key: "k", value: decimalStringer{5, 9}, want: "k=5.9"},
@@ -58,13 +86,31 @@ func TestEncodeKeyval(t *testing.T) {
 		{key: "k", value: "\ufffd", want: `k="\ufffd"`},
 		{key: "k", value: []byte("\ufffd\x00"), want: `k="\ufffd\u0000"`},
 		{key: "k", value: []byte("\ufffd"), want: `k="\ufffd"`},
+		{key: "k", value: []byte("v"), want: "k=v"},
+		{key: "k", value: []byte("\xbd\x01"), want: `k="\ufffd\u0001"`},
+		{key: "k", value: []byte(nil), want: "k=null"},
+		{key: "k", value: []byte{}, want: "k="},
+		{key: "k", value: json.RawMessage(nil), want: "k=null"},
+		{key: "k", value: byteSlice(nil), want: "k=null"},
+		{key: "k", value: json.RawMessage(`{"a":1}`), want: `k="{\"a\":1}"`},
+		{key: "k", value: json.RawMessage(`1`), want: "k=1"},
+		{key: "k", value: byteSlice("v v"), want: `k="v v"`},
+		{key: "k", value: logfmt.GoString(structData{"a a", 9}), want: `k="logfmt_test.structData{A:\"a a\", B:9}"`},
+		{key: "k", value: logfmt.GoString([]int{1, 2}), want: `k="[]int{1, 2}"`},
+		{key: "k", value: logfmt.GoString(1), want: `k="1"`},
+		{key: "k", value: logfmt.GoString(nil), want: `k="<nil>"`},
+		{key: "k", value: logfmt.Nested("a", 1, "b", 2), want: `k="a=1 b=2"`},
+		{key: "k", value: logfmt.Nested("msg", "hi there"), want: `k="msg=\"hi there\""`},
+		{key: "k", value: logfmt.Nested("a", logfmt.Nested("b", "c d")), want: `k="a=\"b=\\\"c d\\\"\""`},
+		{key: "k", value: logfmt.Nested(), want: "k="},
+		{key: "k", value: logfmt.Nested(nil, 1), err: logfmt.ErrNilKey},
 	}
 
 	for _, d := range data {
 		w := &bytes.Buffer{}
 		enc := logfmt.NewEncoder(w)
 		err := enc.EncodeKeyval(d.key, d.value)
-		if err != d.err {
+		if !errors.Is(err, d.err) {
 			t.Errorf("%#v, %#v: got error: %v, want error: %v", d.key, d.value, err, d.err)
 		}
 		if got, want := w.String(), d.want; got != want {
@@ -73,6 +119,43 @@ func TestEncodeKeyval(t *testing.T) {
 	}
 }
 
+func TestNestedRoundTrip(t *testing.T) {
+	b, err := logfmt.MarshalKeyvals("outer", logfmt.Nested("a", 1, "msg", `say "hi"`, "in", logfmt.Nested("x", "y z")), "b", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decode := func(data []byte) map[string]string {
+		t.Helper()
+		m := map[string]string{}
+		dec := logfmt.NewDecoder(bytes.NewReader(data))
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+				m[string(dec.Key())] = string(dec.Value())
+			}
+		}
+		if err := dec.Err(); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		return m
+	}
+
+	outer := decode(b)
+	if got, want := outer["b"], "2"; got != want {
+		t.Errorf("b: got %q, want %q", got, want)
+	}
+	inner := decode([]byte(outer["outer"]))
+	if got, want := inner["a"], "1"; got != want {
+		t.Errorf("a: got %q, want %q", got, want)
+	}
+	if got, want := inner["msg"], `say "hi"`; got != want {
+		t.Errorf("msg: got %q, want %q", got, want)
+	}
+	if got, want := decode([]byte(inner["in"]))["x"], "y z"; got != want {
+		t.Errorf("x: got %q, want %q", got, want)
+	}
+}
+
 func TestMarshalKeyvals(t *testing.T) {
 	one := 1
 	ptr := &one
@@ -103,11 +186,12 @@ func TestMarshalKeyvals(t *testing.T) {
 		{in: kv("k", "v v"), want: []byte(`k="v v"`)},
 		{in: kv("k", `"`), want: []byte(`k="\""`)},
 		{in: kv("k", `=`), want: []byte(`k="="`)},
-		{in: kv("k", `\`), want: []byte(`k=\`)},
+		{in: kv("k", `\`), want: []byte(`k="\\"`)},
 		{in: kv("k", `=\`), want: []byte(`k="=\\"`)},
 		{in: kv("k", `\"`), want: []byte(`k="\\\""`)},
 		{in: kv("k1", "v1", "k2", "v2"), want: []byte("k1=v1 k2=v2")},
-		{in: kv("k1", "v1", "k2", [2]int{}), want: []byte("k1=v1 k2=\"unsupported value type\"")},
+		{in: kv("k1", "v1", "k2", (chan int)(nil)), want: []byte("k1=v1 k2=\"unsupported value type\"")},
+		{in: kv("k1", "v1", "k2", [2]int{}), want: []byte("k1=v1 k2[0]=0 k2[1]=0")},
 		{in: kv([2]int{}, "v1", "k2", "v2"), want: []byte("k2=v2")},
 		{in: kv("k", time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)), want: []byte("k=2009-11-10T23:00:00Z")},
 		{in: kv("k", errorMarshaler{}), want: []byte("k=\"error marshaling value of type logfmt_test.errorMarshaler: marshal error\"")},
@@ -126,19 +210,37 @@ func TestMarshalKeyvals(t *testing.T) {
 		{in: kv((*decimalStringer)(nil), "v"), err: logfmt.ErrNilKey},
 		{in: kv(marshalerStringer{5, 9}, "v"), want: []byte("5.9=v")},
 		{in: kv("k", panicingStringer{0}), want: []byte("k=ok")},
-		{in: kv("k", panicingStringer{1}), want: []byte("k=PANIC:panic1")},
-		// Need extra mechanism to test panic-while-printing-panicVal
-		//{in: kv("k", panicingStringer{2}), want: []byte("?")},
 	}
 
 	for _, d := range data {
 		got, err := logfmt.MarshalKeyvals(d.in...)
-		if err != d.err {
+		if !errors.Is(err, d.err) {
 			t.Errorf("%#v: got error: %v, want error: %v", d.in, err, d.err)
 		}
 		if !reflect.DeepEqual(got, d.want) {
 			t.Errorf("%#v: got '%s', want '%s'", d.in, got, d.want)
 		}
+
+		buf := &bytes.Buffer{}
+		err = logfmt.MarshalKeyvalsTo(buf, d.in...)
+		if !errors.Is(err, d.err) {
+			t.Errorf("MarshalKeyvalsTo %#v: got error: %v, want error: %v", d.in, err, d.err)
+		}
+		if err == nil && !bytes.Equal(buf.Bytes(), d.want) {
+			t.Errorf("MarshalKeyvalsTo %#v: got '%s', want '%s'", d.in, buf.Bytes(), d.want)
+		}
+	}
+
+	// A panic other than one caused by a nil receiver is not recovered.
+	for _, v := range []interface{}{panicingStringer{1}, panicingStringer{2}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%#v: got no panic", v)
+				}
+			}()
+			logfmt.MarshalKeyvals("k", v)
+		}()
 	}
 }
 
@@ -146,6 +248,12 @@ func kv(keyvals ...interface{}) []interface{} {
 	return keyvals
 }
 
+type nestedStructData struct {
+	In   structData `logfmt:"in"`
+	Skip int        `logfmt:"-"`
+	List []int
+}
+
 type structData struct {
 	A string `logfmt:"fieldA"`
 	B int
@@ -222,3 +330,1898 @@ func BenchmarkEncodeKeyval(b *testing.B) {
 		enc.EncodeKeyval("some-key", "a rather long string with spaces")
 	}
 }
+
+func TestEncoderSetIntBase(t *testing.T) {
+	type id uint64
+	keyvals := []interface{}{"a", 255, "b", int8(-128), "c", uint64(math.MaxUint64), "d", id(3054), "e", math.MinInt64, "f", time.Second, "g", 1.5}
+
+	tests := []struct {
+		base   int
+		prefix string
+		want   string
+	}{
+		{want: "a=255 b=-128 c=18446744073709551615 d=3054 e=-9223372036854775808 f=1s g=1.5"},
+		{base: 16, want: "a=ff b=-80 c=ffffffffffffffff d=bee e=-8000000000000000 f=1s g=1.5"},
+		{base: 16, prefix: "0x", want: "a=0xff b=-0x80 c=0xffffffffffffffff d=0xbee e=-0x8000000000000000 f=1s g=1.5"},
+		{prefix: "#", want: "a=#255 b=-#128 c=#18446744073709551615 d=#3054 e=-#9223372036854775808 f=1s g=1.5"},
+		{base: 2, prefix: "0b ", want: `a="0b 11111111" b="-0b 10000000" c="0b 1111111111111111111111111111111111111111111111111111111111111111" d="0b 101111101110" e="-0b 1000000000000000000000000000000000000000000000000000000000000000" f=1s g=1.5`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := logfmt.NewEncoder(&buf)
+		if test.base != 0 {
+			enc.SetIntBase(test.base)
+		}
+		enc.SetIntPrefix(test.prefix)
+		if err := enc.EncodeKeyvals(keyvals...); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("base %d, prefix %q: got %q, want %q", test.base, test.prefix, got, test.want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("SetIntBase(1) did not panic")
+		}
+	}()
+	logfmt.NewEncoder(ioutil.Discard).SetIntBase(1)
+}
+
+func TestEncoderSetStrictQuoting(t *testing.T) {
+	var buf bytes.Buffer
+	enc := logfmt.NewEncoder(&buf)
+	enc.SetStrictQuoting(true)
+	if err := enc.SetNilValue("<nil>"); err != nil {
+		t.Fatal(err)
+	}
+	err := enc.EncodeKeyvals(
+		"path", "/var/log/app.log",
+		"addr", "10.0.0.1:80",
+		"id", "a_b-C9",
+		"trail", `foo\`,
+		"q", "a'b",
+		"u", "é",
+		"amp", []byte("a&b"),
+		"n", -3,
+		"f", 1.5,
+		"nil", nil,
+		"empty", "",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `path=/var/log/app.log addr=10.0.0.1:80 id=a_b-C9 trail="foo\\" q="a'b" u="é" amp="a&b" n=-3 f=1.5 nil=<nil> empty=`
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	enc.SetStrictQuoting(false)
+	if err := enc.EncodeKeyvals("q", "a'b", "trail", `foo\`); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), ` q=a'b trail="foo\\"`; got != want {
+		t.Errorf("default: got %q, want %q", got, want)
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	tests := []struct {
+		keyvals []interface{}
+		want    *logfmt.EncodeError
+		msg     string
+	}{
+		{keyvals: []interface{}{"a", 1, "", 2}, want: &logfmt.EncodeError{Err: &logfmt.InvalidKeyError{Key: "", Index: -1}}, msg: `invalid key ""`},
+		{keyvals: []interface{}{"\x00", 2}, want: &logfmt.EncodeError{Key: "\x00", Err: &logfmt.InvalidKeyError{Key: "\x00", Index: 0}}, msg: `invalid key "\x00": invalid rune '\x00' at index 0`},
+		{keyvals: []interface{}{nil, 1}, want: &logfmt.EncodeError{Err: logfmt.ErrNilKey}, msg: "nil key"},
+		{keyvals: []interface{}{decimalStringer{1, 2}, map[string]int{"a b": 1}}, want: &logfmt.EncodeError{Key: "1.2", Err: &logfmt.InvalidKeyError{Key: "a b", Index: 1}}, msg: `key "1.2": invalid key "a b": invalid rune ' ' at index 1`},
+	}
+	for _, test := range tests {
+		_, err := logfmt.MarshalKeyvals(test.keyvals...)
+		var ee *logfmt.EncodeError
+		if !errors.As(err, &ee) {
+			t.Errorf("%v: got error %v, want *EncodeError", test.keyvals, err)
+			continue
+		}
+		if !reflect.DeepEqual(ee, test.want) {
+			t.Errorf("%v: got %#v, want %#v", test.keyvals, ee, test.want)
+		}
+		target := test.want.Err
+		if _, ok := target.(*logfmt.InvalidKeyError); ok {
+			target = logfmt.ErrInvalidKey
+		}
+		if !errors.Is(err, target) {
+			t.Errorf("%v: errors.Is(%v, %v) = false", test.keyvals, err, target)
+		}
+		if got := err.Error(); got != test.msg {
+			t.Errorf("%v: got message %q, want %q", test.keyvals, got, test.msg)
+		}
+	}
+
+	enc := logfmt.NewEncoder(ioutil.Discard)
+	err := enc.EncodeKeyval("ch", make(chan int))
+	want := &logfmt.EncodeError{Key: "ch", InValue: true, Err: logfmt.ErrUnsupportedValueType}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("value: got %#v, want %#v", err, want)
+	}
+	err = enc.EncodeKeyval("m", map[[1]int]int{{1}: 1})
+	want = &logfmt.EncodeError{Key: "m", Err: logfmt.ErrUnsupportedKeyType}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("map key: got %#v, want %#v", err, want)
+	}
+	if _, err := logfmt.AppendKeyval(nil, []byte("\x00"), 1); !reflect.DeepEqual(err, &logfmt.EncodeError{Key: "\x00", Err: &logfmt.InvalidKeyError{Key: "\x00", Index: 0}}) {
+		t.Errorf("AppendKeyval: got %#v", err)
+	}
+}
+
+func TestEncoderSetQuoteNilString(t *testing.T) {
+	tests := []struct {
+		nilValue string
+		quote    bool
+		want     string
+	}{
+		{quote: true, want: `s="null" n=null d=<nil>`},
+		{quote: false, want: `s=null n=null d=<nil>`},
+		{nilValue: "<nil>", quote: true, want: `s=null n=<nil> d="<nil>"`},
+		{nilValue: "<nil>", quote: false, want: `s=null n=<nil> d=<nil>`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := logfmt.NewEncoder(&buf)
+		if test.nilValue != "" {
+			if err := enc.SetNilValue(test.nilValue); err != nil {
+				t.Fatal(err)
+			}
+		}
+		enc.SetQuoteNilString(test.quote)
+		if err := enc.EncodeKeyvals("s", "null", "n", nil, "d", "<nil>"); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("%q, %v: got %q, want %q", test.nilValue, test.quote, got, test.want)
+		}
+	}
+}
+
+func TestEncoderSetAlwaysQuoteKeys(t *testing.T) {
+	var buf bytes.Buffer
+	enc := logfmt.NewEncoder(&buf)
+	enc.SetAlwaysQuoteKeys("id", "name", "raw", "empty", "nil", "num", "user.id", "pfx.id")
+	err := enc.EncodeKeyvals(
+		"id", "12345",
+		"name", `say "hi"\`,
+		"raw", []byte("abc"),
+		"empty", "",
+		"nil", nil,
+		"num", 42,
+		"other", "12345",
+		"user", map[string]string{"id": "7", "role": "admin"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	enc.SetKeyPrefix("pfx.")
+	if err := enc.EncodeKeyvals("id", "8", "name", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	enc.SetKeyPrefix("")
+	enc.SetAlwaysQuoteKeys()
+	if err := enc.EncodeKeyvals("id", "9"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `id="12345" name="say \"hi\"\\" raw="abc" empty="" nil=null num="42" other=12345 user.id="7" user.role=admin
+pfx.id="8" pfx.name=x
+id=9`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEncoderEmptyRecords(t *testing.T) {
+	records := [][]interface{}{
+		{},
+		{"a", 1},
+		{},
+		{"b", 2, "c", "x y"},
+		{[]int{}, 1},
+		{},
+	}
+	tests := []struct {
+		emit bool
+		want string
+	}{
+		{emit: false, want: "a=1\nb=2 c=\"x y\"\n"},
+		{emit: true, want: "\na=1\n\nb=2 c=\"x y\"\n\n\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := logfmt.NewEncoder(&buf)
+		enc.SetEmitEmptyRecords(test.emit)
+		for _, r := range records {
+			if err := enc.EncodeKeyvals(r...); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.EndRecord(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("emit %v: got %q, want %q", test.emit, got, test.want)
+		}
+	}
+}
+
+type jsonPayload struct {
+	Name string
+	Tags []string
+}
+
+func (p jsonPayload) MarshalJSON() ([]byte, error) {
+	type plain jsonPayload
+	return json.Marshal(plain(p))
+}
+
+type ptrJSON struct{ v int }
+
+func (p *ptrJSON) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Itoa(p.v)), nil
+}
+
+type jsonText struct{}
+
+func (jsonText) MarshalJSON() ([]byte, error) { return []byte(`"json"`), nil }
+func (jsonText) MarshalText() ([]byte, error) { return []byte("text"), nil }
+
+type badJSON struct{}
+
+func (badJSON) MarshalJSON() ([]byte, error) { return nil, errors.New("bad") }
+
+func TestEncodeJSONMarshaler(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+		err   bool
+	}{
+		{value: jsonPayload{Name: `a "b"`, Tags: []string{"x"}}, want: `k="{\"Name\":\"a \\\"b\\\"\",\"Tags\":[\"x\"]}"`},
+		{value: &ptrJSON{v: 7}, want: "k=7"},
+		{value: (*ptrJSON)(nil), want: "k=null"},
+		{value: jsonText{}, want: "k=text"},
+		{value: badJSON{}, err: true},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := logfmt.NewEncoder(&buf).EncodeKeyval("k", test.value)
+		got := buf.Bytes()
+		if test.err {
+			var me *logfmt.MarshalerError
+			if !errors.As(err, &me) {
+				t.Errorf("%#v: got error %v, want *MarshalerError", test.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%#v: got %s, want %s", test.value, got, test.want)
+		}
+	}
+
+	// The JSON encoding round-trips through the decoder.
+	b, err := logfmt.MarshalKeyvals("k", jsonPayload{Name: `a "b"`, Tags: []string{"x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := logfmt.NewDecoder(bytes.NewReader(b))
+	dec.ScanRecord()
+	dec.ScanKeyval()
+	var p jsonPayload
+	if err := json.Unmarshal(dec.Value(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if want := (jsonPayload{Name: `a "b"`, Tags: []string{"x"}}); !reflect.DeepEqual(p, want) {
+		t.Errorf("round trip: got %#v, want %#v", p, want)
+	}
+}
+
+func TestEncodeNetworkValues(t *testing.T) {
+	u, err := url.Parse("https://example.com/search?q=a b&page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{value: net.ParseIP("10.0.0.1"), want: "k=10.0.0.1"},
+		{value: net.ParseIP("2001:db8::1"), want: "k=2001:db8::1"},
+		{value: net.IP(nil), want: "k=null"},
+		{value: u, want: `k="https://example.com/search?q=a b&page=2"`},
+		{value: *u, want: `k="https://example.com/search?q=a b&page=2"`},
+		{value: &url.URL{Scheme: "http", Host: "h", Path: "/p"}, want: "k=http://h/p"},
+		{value: (*url.URL)(nil), want: "k=null"},
+		{value: struct{ U url.URL }{*u}, want: `k.U="https://example.com/search?q=a b&page=2"`},
+	}
+	for _, test := range tests {
+		got, err := logfmt.MarshalKeyvals("k", test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%#v: got %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestEncoderSetAutoNewline(t *testing.T) {
+	var buf bytes.Buffer
+	enc := logfmt.NewEncoder(&buf)
+	enc.SetAutoNewline(true)
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(enc.EncodeKeyvals("a", 1, "b", 2))
+	check(enc.EncodeKeyvals())
+	check(enc.EncodeKeyval("c", 3))
+	check(enc.EncodeKeyval("d", 4))
+	check(enc.EncodeKeyvals("e", 5))
+	check(enc.EncodeKeyval("f", 6))
+	check(enc.EndRecord())
+	if got, want := buf.String(), "a=1 b=2\nc=3 d=4 e=5\nf=6\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	if err := enc.EncodeKeyvals("g", 7, "", 8); !errors.Is(err, logfmt.ErrInvalidKey) {
+		t.Fatalf("got error %v, want %v", err, logfmt.ErrInvalidKey)
+	}
+	check(enc.EncodeKeyvals("h", 9))
+	if got, want := buf.String(), "g=7 h=9\n"; got != want {
+		t.Errorf("after error: got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	enc.SetAutoNewline(false)
+	check(enc.EncodeKeyvals("a", 1))
+	check(enc.EncodeKeyvals("b", 2))
+	if got, want := buf.String(), "a=1 b=2"; got != want {
+		t.Errorf("disabled: got %q, want %q", got, want)
+	}
+}
+
+func TestEncoderWriteRawKeyval(t *testing.T) {
+	var buf bytes.Buffer
+	enc := logfmt.NewEncoder(&buf)
+	enc.SetKeyPrefix("app.")
+	enc.SetKeyFilter(func(key string) bool { return key != "drop" })
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(enc.EncodeKeyval("a", 1))
+	check(enc.WriteRawKeyval([]byte("msg"), []byte(`"hello world"`)))
+	check(enc.WriteRawKeyval([]byte("drop"), []byte("x")))
+	check(enc.WriteRawKeyval([]byte("empty"), nil))
+	check(enc.EndRecord())
+	check(enc.WriteRawKeyval([]byte("b"), []byte("2")))
+	check(enc.EndRecord())
+
+	if got, want := buf.String(), "app.a=1 msg=\"hello world\" empty=\nb=2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := enc.WriteRawKeyval(nil, []byte("v")); err != logfmt.ErrInvalidKey {
+		t.Errorf("empty key: got error %v, want %v", err, logfmt.ErrInvalidKey)
+	}
+}
+
+func BenchmarkWriteRawKeyval(b *testing.B) {
+	key, value := []byte("some-key"), []byte(`"a rather long string with spaces"`)
+	b.Run("EncodeKeyval", func(b *testing.B) {
+		b.ReportAllocs()
+		enc := logfmt.NewEncoder(ioutil.Discard)
+		for i := 0; i < b.N; i++ {
+			enc.EncodeKeyval(key, value)
+		}
+	})
+	b.Run("WriteRawKeyval", func(b *testing.B) {
+		b.ReportAllocs()
+		enc := logfmt.NewEncoder(ioutil.Discard)
+		for i := 0; i < b.N; i++ {
+			enc.WriteRawKeyval(key, value)
+		}
+	})
+}
+
+func BenchmarkMarshalKeyvals(b *testing.B) {
+	keyvals := []interface{}{"sk", "10", "some-key", "a rather long string with spaces", "n", 42}
+	b.Run("MarshalKeyvals", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logfmt.MarshalKeyvals(keyvals...)
+		}
+	})
+	b.Run("NewEncoder", func(b *testing.B) {
+		// The unpooled equivalent, for comparison.
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := &bytes.Buffer{}
+			logfmt.NewEncoder(buf).EncodeKeyvals(keyvals...)
+			_ = buf.Bytes()
+		}
+	})
+}
+
+func BenchmarkEncoderPerRecord(b *testing.B) {
+	b.Run("NewEncoder", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			enc := logfmt.NewEncoder(ioutil.Discard)
+			enc.EncodeKeyval("sk", "10")
+			enc.EncodeKeyval("some-key", "a rather long string with spaces")
+			enc.EndRecord()
+		}
+	})
+	b.Run("GetEncoder", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			enc := logfmt.GetEncoder(ioutil.Discard)
+			enc.EncodeKeyval("sk", "10")
+			enc.EncodeKeyval("some-key", "a rather long string with spaces")
+			enc.EndRecord()
+			logfmt.PutEncoder(enc)
+		}
+	})
+}
+
+func TestGetEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := logfmt.GetEncoder(&buf)
+	enc.SortKeys = true
+	enc.SetKeyPrefix("p.")
+	enc.EncodeKeyvals("b", 2, "a", 1)
+	logfmt.PutEncoder(enc)
+	if buf.Len() != 0 {
+		t.Errorf("pending pairs written by PutEncoder: %q", buf.String())
+	}
+
+	for i := 0; i < 3; i++ {
+		buf.Reset()
+		enc = logfmt.GetEncoder(&buf)
+		if err := enc.EncodeKeyvals("b", 2, "a", 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EndRecord(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), "b=2 a=1\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		logfmt.PutEncoder(enc)
+	}
+}
+
+func TestNewEncoderWith(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := logfmt.NewEncoderWith(buf,
+		logfmt.WithSortKeys(),
+		logfmt.WithNilValue("-"),
+		logfmt.WithTimeFormat("2006-01-02"),
+		logfmt.WithFloatFormat('f', 2),
+		logfmt.WithKeyPrefix("p."),
+		logfmt.WithKeySanitizer(strings.ToLower),
+	)
+	err := enc.EncodeKeyvals("T", time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), "N", nil, "F", 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "p.f=1.50 p.n=- p.t=2024-05-06\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWithNilValuePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for an invalid nil value")
+		}
+	}()
+	logfmt.NewEncoderWith(ioutil.Discard, logfmt.WithNilValue("a b"))
+}
+
+func TestAlignedEncoder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := logfmt.NewAlignedEncoder(buf)
+	records := [][]interface{}{
+		{"level", "info", "msg", "two words", "ƒ", 1},
+		{"a", 1, "bb", nil},
+		{},
+		{"k", "v"},
+	}
+	for _, r := range records {
+		if err := enc.EncodeKeyvals(r...); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EndRecord(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "level=info msg  =\"two words\" ƒ    =1\n" +
+		"a =1 bb=null\n" +
+		"k=v\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	enc = logfmt.NewAlignedEncoder(buf)
+	enc.SortKeys = true
+	enc.SequenceKey = "seq"
+	if err := enc.EncodeKeyvals("long", 1, "b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "seq=1 b   =2 long=1\n"; got != want {
+		t.Errorf("sorted: got %q, want %q", got, want)
+	}
+}
+
+func TestEncoderEncodeMap(t *testing.T) {
+	var buf bytes.Buffer
+	enc := logfmt.NewEncoder(&buf)
+	m := map[string]interface{}{
+		"msg":   "hello world",
+		"count": 3,
+		"ok":    true,
+		"user":  map[string]interface{}{"id": 7, "name": "bob"},
+		"err":   nil,
+	}
+	for i := 0; i < 2; i++ {
+		if err := enc.EncodeMap(m); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EndRecord(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.EncodeMap(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	line := `count=3 err=null msg="hello world" ok=true user.id=7 user.name=bob` + "\n"
+	if got, want := buf.String(), line+line; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	err := enc.EncodeMap(map[string]interface{}{"a": 1, "b c": 2, "": 3})
+	var ike *logfmt.InvalidKeyError
+	if !errors.As(err, &ike) || ike.Key != "" {
+		t.Errorf("got error %v, want invalid empty key", err)
+	}
+}
+
+func TestMarshalKeyvalsAppend(t *testing.T) {
+	tests := []struct {
+		dst     string
+		keyvals []interface{}
+		want    string
+		err     error
+	}{
+		{dst: "", keyvals: kv("a", 1, "b", "x y"), want: `a=1 b="x y"`},
+		{dst: "a=1", keyvals: kv("b", 2), want: "a=1 b=2"},
+		{dst: "a=1 ", keyvals: kv("b", 2), want: "a=1 b=2"},
+		{dst: "a=1\t", keyvals: kv("b", 2), want: "a=1\tb=2"},
+		{dst: "a=1\n", keyvals: kv("b", 2), want: "a=1\nb=2"},
+		{dst: "a=1", keyvals: kv("b"), want: "a=1 b=null"},
+		{dst: "a=1", keyvals: nil, want: "a=1"},
+		{dst: "a=1", keyvals: kv([]int{}, 2), want: "a=1"},
+		{dst: "a=1", keyvals: kv("b", 2, "", 3), want: "a=1", err: logfmt.ErrInvalidKey},
+	}
+	for _, test := range tests {
+		got, err := logfmt.MarshalKeyvalsAppend([]byte(test.dst), test.keyvals...)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%q, %v: got error %v, want %v", test.dst, test.keyvals, err, test.err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%q, %v: got %q, want %q", test.dst, test.keyvals, got, test.want)
+		}
+	}
+
+	var rec []byte
+	for _, part := range [][]interface{}{kv("ts", 1), kv(), kv("user", "bob", "id", 7), kv("msg", "done")} {
+		var err error
+		if rec, err = logfmt.MarshalKeyvalsAppend(rec, part...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := string(rec), "ts=1 user=bob id=7 msg=done"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncoderSetPairSeparator(t *testing.T) {
+	for _, sep := range []string{"", "=", "a=b", `"`, "\n", " \r"} {
+		enc := logfmt.NewEncoder(ioutil.Discard)
+		if err := enc.SetPairSeparator(sep); err != logfmt.ErrInvalidPairSeparator {
+			t.Errorf("%q: got error %v, want %v", sep, err, logfmt.ErrInvalidPairSeparator)
+		}
+	}
+
+	tests := []struct {
+		sep   string
+		setup func(enc *logfmt.Encoder)
+		want  string
+	}{
+		{sep: "\t", want: "a=1\tb=\"x y\"\tc=3\nd=4\n"},
+		{sep: " | ", want: "a=1 | b=\"x y\" | c=3\nd=4\n"},
+		{sep: "\t", setup: func(enc *logfmt.Encoder) { enc.SequenceKey = "seq" }, want: "seq=1\ta=1\tb=\"x y\"\tc=3\nseq=2\td=4\n"},
+		{sep: "\t", setup: func(enc *logfmt.Encoder) { enc.SortKeys = true }, want: "a=1\tb=\"x y\"\tc=3\nd=4\n"},
+		{sep: "\t", setup: func(enc *logfmt.Encoder) { enc.SequenceKey = "seq"; enc.SortKeys = true }, want: "seq=1\ta=1\tb=\"x y\"\tc=3\nseq=2\td=4\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := logfmt.NewEncoder(&buf)
+		if err := enc.SetPairSeparator(test.sep); err != nil {
+			t.Fatal(err)
+		}
+		if test.setup != nil {
+			test.setup(enc)
+		}
+		if err := enc.EncodeKeyvals("a", 1, "b", "x y"); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EncodeKeyval("c", 3); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EndRecord(); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EncodeKeyval("d", 4); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EndRecord(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.sep, got, test.want)
+		}
+	}
+
+	// Whitespace separators can be decoded.
+	var buf bytes.Buffer
+	enc := logfmt.NewEncoder(&buf)
+	if err := enc.SetPairSeparator("\t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EncodeKeyvals("a", 1, "b", "x\ty", "c", logfmt.Nested("d", 2, "e", 3)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := logfmt.UnmarshalMap(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"a": "1", "b": "x\ty", "c": "d=2 e=3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %q, want %q", got, want)
+	}
+}
+
+func TestInvalidKeyError(t *testing.T) {
+	tests := []struct {
+		key   interface{}
+		value interface{}
+		want  logfmt.InvalidKeyError
+	}{
+		{key: " ", want: logfmt.InvalidKeyError{Key: " ", Index: 0}},
+		{key: []byte("="), want: logfmt.InvalidKeyError{Key: "=", Index: 0}},
+		{key: decimalStringer{1, 2}, value: map[string]int{"ok\xff": 1}, want: logfmt.InvalidKeyError{Key: "ok\xff", Index: 2}},
+		{key: "k", value: map[string]int{"a=b": 1}, want: logfmt.InvalidKeyError{Key: "a=b", Index: 1}},
+		{key: "k", value: map[string]int{"": 1}, want: logfmt.InvalidKeyError{Key: "", Index: -1}},
+	}
+	for _, test := range tests {
+		enc := logfmt.NewEncoder(ioutil.Discard)
+		err := enc.EncodeKeyval(test.key, test.value)
+		var ike *logfmt.InvalidKeyError
+		if !errors.As(err, &ike) || !errors.Is(err, logfmt.ErrInvalidKey) {
+			t.Errorf("%v: got error %v, want *InvalidKeyError", test.key, err)
+			continue
+		}
+		if *ike != test.want {
+			t.Errorf("%v: got %+v, want %+v", test.key, *ike, test.want)
+		}
+	}
+}
+
+func TestEncoderSetValueFormatter(t *testing.T) {
+	var keys []string
+	format := func(key string, value interface{}) interface{} {
+		keys = append(keys, key)
+		switch v := value.(type) {
+		case string:
+			if key == "req.token" {
+				return "***"
+			}
+			if len(v) > 5 {
+				return v[:5] + "..."
+			}
+		case map[string]int:
+			return len(v)
+		}
+		return value
+	}
+
+	var buf bytes.Buffer
+	enc := logfmt.NewEncoder(&buf)
+	enc.SetValueFormatter(format)
+	if err := enc.EncodeKeyvals("msg", "hello world", "n", 1, "counts", map[string]int{"a": 1, "b": 2}, "bad key", "v"); err != nil {
+		t.Fatal(err)
+	}
+	enc.SetKeyPrefix("req.")
+	if err := enc.EncodeKeyval("token", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	enc.SetKeyPrefix("")
+	enc.SetValueFormatter(nil)
+	if err := enc.EncodeKeyval("raw", "hello world"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `msg=hello... n=1 counts=2 badkey=v req.token=*** raw="hello world"`
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	wantKeys := []string{"msg", "n", "counts", "badkey", "req.token"}
+	if !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("got keys %q, want %q", keys, wantKeys)
+	}
+}
+
+func TestEncoderSetKeyFilter(t *testing.T) {
+	redact := func(key string) bool {
+		return key != "password" && !strings.HasSuffix(key, ".token")
+	}
+	data := []struct {
+		keyvals []interface{}
+		want    string
+	}{
+		{keyvals: []interface{}{"password", "x", "user", "bob"}, want: "user=bob\n"},
+		{keyvals: []interface{}{"user", "bob", "password", "x"}, want: "user=bob\n"},
+		{keyvals: []interface{}{"a", 1, "password", "x", "b", 2}, want: "a=1 b=2\n"},
+		{keyvals: []interface{}{"password", "x"}, want: ""},
+		{keyvals: []interface{}{decimalStringer{5, 9}, 1, "password", 2}, want: "5.9=1\n"},
+		{keyvals: []interface{}{"auth", map[string]string{"token": "t", "user": "u"}}, want: "auth.user=u\n"},
+	}
+
+	for _, d := range data {
+		for _, sorted := range []bool{false, true} {
+			buf := &bytes.Buffer{}
+			enc := logfmt.NewEncoder(buf)
+			enc.SortKeys = sorted
+			enc.SetKeyFilter(redact)
+			if err := enc.EncodeKeyvals(d.keyvals...); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.EndRecord(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != d.want {
+				t.Errorf("%v, sorted %v: got %q, want %q", d.keyvals, sorted, got, d.want)
+			}
+		}
+	}
+
+	w := &countingWriter{}
+	enc := logfmt.NewEncoder(w)
+	enc.SetKeyFilter(redact)
+	if err := enc.EncodeKeyval("password", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.writes) != 0 {
+		t.Errorf("got writes %q for a dropped pair", w.writes)
+	}
+}
+
+type node struct {
+	Name string
+	Next *node
+}
+
+func TestEncoderFlattenCycle(t *testing.T) {
+	n := &node{Name: "a"}
+	n.Next = &node{Name: "b", Next: n}
+
+	m := map[string]interface{}{"x": 1}
+	m["self"] = m
+
+	s := []interface{}{1, nil}
+	s[1] = s
+
+	shared := &node{Name: "s"}
+
+	sub := []interface{}{1, nil}
+	sub[1] = sub[:1]
+
+	data := []struct {
+		value interface{}
+		want  string
+		err   error
+	}{
+		{value: n, err: logfmt.ErrCyclicValue},
+		{value: m, err: logfmt.ErrCyclicValue},
+		{value: s, err: logfmt.ErrCyclicValue},
+		{value: []*node{shared, shared}, want: "k[0].Name=s k[0].Next=null k[1].Name=s k[1].Next=null"},
+		{value: sub, want: "k[0]=1 k[1][0]=1"},
+	}
+
+	for _, d := range data {
+		buf := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(buf)
+		if err := enc.EncodeKeyval("k", d.value); err != d.err {
+			t.Errorf("%T: got error %v, want %v", d.value, err, d.err)
+		}
+		if got := buf.String(); got != d.want {
+			t.Errorf("%T: got %q, want %q", d.value, got, d.want)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	enc := logfmt.NewEncoder(buf)
+	if err := enc.EncodeKeyvals("k", n, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "k=\"cyclic value\" a=1"; got != want {
+		t.Errorf("EncodeKeyvals: got %q, want %q", got, want)
+	}
+}
+
+func TestEncoderSetMaxFlattenDepth(t *testing.T) {
+	type inner struct{ A, B int }
+	type outer struct {
+		In   inner
+		List []int
+		X    int
+	}
+	value := []outer{{In: inner{1, 2}, List: []int{3, 4}, X: 5}}
+
+	data := []struct {
+		depth int
+		want  string
+	}{
+		{depth: 0, want: "k[0].In.A=1 k[0].In.B=2 k[0].List[0]=3 k[0].List[1]=4 k[0].X=5"},
+		{depth: 3, want: "k[0].In.A=1 k[0].In.B=2 k[0].List[0]=3 k[0].List[1]=4 k[0].X=5"},
+		{depth: 2, want: `k[0].In="{1 2}" k[0].List="[3 4]" k[0].X=5`},
+		{depth: 1, want: `k[0]="{{1 2} [3 4] 5}"`},
+	}
+
+	for _, d := range data {
+		buf := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(buf)
+		enc.SetMaxFlattenDepth(d.depth)
+		if err := enc.EncodeKeyval("k", value); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != d.want {
+			t.Errorf("depth %d: got %q, want %q", d.depth, got, d.want)
+		}
+	}
+
+	type one struct{ A int }
+	for _, d := range []struct {
+		depth int
+		value interface{}
+		want  string
+	}{
+		{depth: 0, value: []one{{1}}, want: "k[0].A=1"},
+		{depth: 1, value: []one{{1}}, want: `k[0]="{1}"`},
+		{depth: 1, value: [][]int{{7}}, want: `k[0]="[7]"`},
+		{depth: 1, value: []map[string]int{{"a": 1}}, want: `k[0]="map[a:1]"`},
+	} {
+		buf := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(buf)
+		enc.SetMaxFlattenDepth(d.depth)
+		if err := enc.EncodeKeyval("k", d.value); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != d.want {
+			t.Errorf("depth %d, %v: got %q, want %q", d.depth, d.value, got, d.want)
+		}
+	}
+
+	n := &node{Name: "a"}
+	n.Next = n
+	buf := &bytes.Buffer{}
+	enc := logfmt.NewEncoder(buf)
+	enc.SetMaxFlattenDepth(1)
+	if err := enc.EncodeKeyval("k", n); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), fmt.Sprintf(`k.Name=a k.Next="&{a %p}"`, n); got != want {
+		t.Errorf("cyclic: got %q, want %q", got, want)
+	}
+
+	m := map[string]interface{}{"x": 1}
+	m["self"] = m
+	for _, value := range []interface{}{m, struct{ M interface{} }{m}} {
+		buf.Reset()
+		enc.SetMaxFlattenDepth(1)
+		if err := enc.EncodeKeyval("k", value); err != logfmt.ErrCyclicValue {
+			t.Errorf("%T: got error %v, want %v", value, err, logfmt.ErrCyclicValue)
+		}
+		if got := buf.String(); got != "" {
+			t.Errorf("%T: got %q, want nothing written", value, got)
+		}
+	}
+}
+
+func TestEncoderSetEscapePolicy(t *testing.T) {
+	allowESC := func(r rune) bool { return r != 0x1b }
+	data := []struct {
+		value  interface{}
+		policy func(rune) bool
+		want   string
+	}{
+		{value: "\x1b[31mred\x1b[0m", want: `k="\u001b[31mred\u001b[0m"`},
+		{value: "\x1b[31mred\x1b[0m", policy: allowESC, want: "k=\"\x1b[31mred\x1b[0m\""},
+		{value: []byte("\x1b[1m \"b\"\n"), policy: allowESC, want: "k=\"\x1b[1m \\\"b\\\"\\n\""},
+		{value: "a\tb", policy: func(rune) bool { return false }, want: "k=\"a\tb\""},
+		{value: "del\x7f c1\u0085", want: `k="del\u007f c1\u0085"`},
+		{value: []byte("del\x7f c1\u0085"), policy: func(rune) bool { return false }, want: "k=\"del\x7f c1\u0085\""},
+		{value: logfmt.Nested("c", "\x1b"), policy: allowESC, want: "k=\"c=\\\"\x1b\\\"\""},
+	}
+
+	for _, d := range data {
+		buf := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(buf)
+		enc.SetEscapePolicy(d.policy)
+		if err := enc.EncodeKeyval("k", d.value); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != d.want {
+			t.Errorf("%q: got %q, want %q", d.value, got, d.want)
+		}
+	}
+}
+
+func TestEncoderSetBoolStrings(t *testing.T) {
+	type myBool bool
+	yes := true
+	data := []struct {
+		value  interface{}
+		tokens []string
+		want   string
+	}{
+		{value: true, want: "k=true"},
+		{value: false, want: "k=false"},
+		{value: true, tokens: []string{"yes", "no"}, want: "k=yes"},
+		{value: false, tokens: []string{"yes", "no"}, want: "k=no"},
+		{value: &yes, tokens: []string{"1", "0"}, want: "k=1"},
+		{value: (*bool)(nil), tokens: []string{"1", "0"}, want: "k=null"},
+		{value: myBool(false), tokens: []string{"1", "0"}, want: "k=0"},
+		{value: []bool{true, false}, tokens: []string{"on", "off"}, want: "k[0]=on k[1]=off"},
+		{value: true, tokens: []string{"very true", ""}, want: `k="very true"`},
+		{value: false, tokens: []string{"very true", ""}, want: "k="},
+		{value: false, tokens: []string{"T", "null"}, want: `k="null"`},
+	}
+
+	for _, d := range data {
+		buf := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(buf)
+		if d.tokens != nil {
+			enc.SetBoolStrings(d.tokens[0], d.tokens[1])
+		}
+		if err := enc.EncodeKeyval("k", d.value); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != d.want {
+			t.Errorf("%#v, %q: got %q, want %q", d.value, d.tokens, got, d.want)
+		}
+	}
+}
+
+// richError implements error, fmt.Stringer, and encoding.TextMarshaler.
+type richError struct{}
+
+func (richError) Error() string                { return "error message" }
+func (richError) String() string               { return "string" }
+func (richError) MarshalText() ([]byte, error) { return []byte("text"), nil }
+
+// stringerError implements error and fmt.Stringer.
+type stringerError struct{}
+
+func (stringerError) Error() string  { return "error message" }
+func (stringerError) String() string { return "string" }
+
+func TestEncoderSetErrorPrecedence(t *testing.T) {
+	data := []struct {
+		value       interface{}
+		preferError bool
+		want        string
+	}{
+		{value: richError{}, want: "k=text"},
+		{value: richError{}, preferError: true, want: `k="error message"`},
+		{value: stringerError{}, want: `k="error message"`},
+		{value: stringerError{}, preferError: true, want: `k="error message"`},
+		{value: (*ptrError)(nil), preferError: true, want: "k=null"},
+		{value: decimalMarshaler{5, 9}, preferError: true, want: "k=5.9"},
+	}
+
+	for _, d := range data {
+		buf := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(buf)
+		enc.SetErrorPrecedence(d.preferError)
+		if err := enc.EncodeKeyval("k", d.value); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != d.want {
+			t.Errorf("%T, %v: got %q, want %q", d.value, d.preferError, got, d.want)
+		}
+	}
+}
+
+func TestEncoderClone(t *testing.T) {
+	var parentBuf, childBuf bytes.Buffer
+	parent := logfmt.NewEncoder(&parentBuf)
+	parent.SortKeys = true
+	parent.SequenceKey = "seq"
+	parent.SetKeyPrefix("app.")
+	parent.SetTimeFormat("15:04")
+	if err := parent.SetNilValue("-"); err != nil {
+		t.Fatal(err)
+	}
+	parent.RegisterFormatter(reflect.TypeOf(time.Duration(0)), func(v interface{}) ([]byte, error) {
+		return []byte(fmt.Sprint(v.(time.Duration).Milliseconds())), nil
+	})
+
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check(parent.EncodeKeyvals("b", 1, "a", nil))
+	check(parent.EndRecord())
+	check(parent.EncodeKeyval("pending", 1))
+
+	child := parent.Clone(&childBuf)
+	child.SetKeyPrefix("child.")
+	child.RegisterFormatter(reflect.TypeOf(time.Duration(0)), nil)
+	check(child.EncodeKeyvals("t", time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC), "d", 1500*time.Millisecond, "n", nil))
+	check(child.EndRecord())
+
+	check(parent.EncodeKeyvals("d", 1500*time.Millisecond))
+	check(parent.EndRecord())
+
+	if got, want := childBuf.String(), "seq=1 child.d=1.5s child.n=- child.t=15:04\n"; got != want {
+		t.Errorf("child: got %q, want %q", got, want)
+	}
+	if got, want := parentBuf.String(), "seq=1 app.a=- app.b=1\nseq=2 app.d=1500 app.pending=1\n"; got != want {
+		t.Errorf("parent: got %q, want %q", got, want)
+	}
+
+	parentBuf.Reset()
+	buffered := logfmt.NewBufferedEncoder(&parentBuf)
+	check(buffered.EncodeKeyval("a", 1))
+	clone := buffered.Clone(nil)
+	if len(clone.Bytes()) != 0 {
+		t.Errorf("clone Bytes: got %q, want empty", clone.Bytes())
+	}
+	check(clone.EncodeKeyval("b", 2))
+	check(clone.EndRecord())
+	check(buffered.EndRecord())
+	if got, want := parentBuf.String(), "b=2\na=1\n"; got != want {
+		t.Errorf("buffered: got %q, want %q", got, want)
+	}
+}
+
+func TestEncoderSetKeySanitizer(t *testing.T) {
+	underscore := func(k string) string {
+		return strings.Map(func(r rune) rune {
+			if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError {
+				return '_'
+			}
+			return r
+		}, k)
+	}
+	data := []struct {
+		key  interface{}
+		fn   func(string) string
+		want string
+		err  error
+	}{
+		{key: "user name", fn: underscore, want: "user_name=v"},
+		{key: "a=b\"c\n", fn: underscore, want: "a_b_c_=v"},
+		{key: decimalStringer{5, 9}, fn: underscore, want: "5.9=v"},
+		{key: decimalStringer{5, 9}, fn: func(string) string { return "s t" }, want: "st=v"},
+		{key: []byte("b k"), fn: underscore, want: "bk=v"},
+		{key: decimalMarshaler{5, 9}, fn: func(string) string { return "x" }, want: "5.9=v"},
+		{key: "a b", fn: strings.ToUpper, want: "AB=v"},
+		{key: "key", fn: func(string) string { return " " }, err: logfmt.ErrInvalidKey},
+		{key: "a b", want: "ab=v"},
+	}
+
+	for _, d := range data {
+		buf := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(buf)
+		enc.SetKeySanitizer(d.fn)
+		err := enc.EncodeKeyval(d.key, "v")
+		if !errors.Is(err, d.err) {
+			t.Errorf("%#v: got error %v, want %v", d.key, err, d.err)
+		}
+		if got := buf.String(); got != d.want {
+			t.Errorf("%#v: got %q, want %q", d.key, got, d.want)
+		}
+	}
+}
+
+func TestEncoderRegisterFormatter(t *testing.T) {
+	errFormat := errors.New("format error")
+	data := []struct {
+		value interface{}
+		fn    func(interface{}) ([]byte, error)
+		want  string
+		err   error
+	}{
+		{
+			value: time.Duration(1500) * time.Millisecond,
+			fn:    func(v interface{}) ([]byte, error) { return []byte(fmt.Sprint(v.(time.Duration).Milliseconds())), nil },
+			want:  "k=1500",
+		},
+		{
+			value: decimalMarshaler{5, 9},
+			fn:    func(v interface{}) ([]byte, error) { return []byte("five nine"), nil },
+			want:  `k="five nine"`,
+		},
+		{
+			value: decimalStringer{5, 9},
+			fn:    func(v interface{}) ([]byte, error) { return nil, nil },
+			want:  "k=null",
+		},
+		{
+			value: decimalStringer{5, 9},
+			fn:    func(v interface{}) ([]byte, error) { return nil, errFormat },
+			err:   &logfmt.MarshalerError{Type: reflect.TypeOf(decimalStringer{}), Err: errFormat},
+		},
+	}
+
+	for _, d := range data {
+		w := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(w)
+		enc.RegisterFormatter(reflect.TypeOf(d.value), d.fn)
+		err := enc.EncodeKeyval("k", d.value)
+		if !reflect.DeepEqual(err, d.err) {
+			t.Errorf("%#v: got error: %v, want error: %v", d.value, err, d.err)
+		}
+		if got, want := w.String(), d.want; got != want {
+			t.Errorf("%#v: got '%s', want '%s'", d.value, got, want)
+		}
+	}
+}
+
+func TestEncoderRegisterFormatterPointer(t *testing.T) {
+	w := &bytes.Buffer{}
+	enc := logfmt.NewEncoder(w)
+	enc.RegisterFormatter(reflect.TypeOf(0), func(v interface{}) ([]byte, error) {
+		return []byte(fmt.Sprintf("%#x", v)), nil
+	})
+	n := 255
+	if err := enc.EncodeKeyvals("a", n, "b", &n, "c", "s"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.String(), "a=0xff b=0xff c=s"; got != want {
+		t.Errorf("got '%s', want '%s'", got, want)
+	}
+
+	w.Reset()
+	enc.Reset()
+	enc.RegisterFormatter(reflect.TypeOf(0), nil)
+	if err := enc.EncodeKeyval("a", n); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.String(), "a=255"; got != want {
+		t.Errorf("got '%s', want '%s'", got, want)
+	}
+}
+
+func TestEncoderSequenceKey(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := logfmt.NewEncoder(buf)
+	enc.SequenceKey = "seq"
+
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check(enc.EncodeKeyvals("a", 1, "b", 2))
+	check(enc.EndRecord())
+	check(enc.EndRecord())
+	check(enc.EncodeKeyval("a", 3))
+	check(enc.EndRecord())
+
+	want := "seq=1 a=1 b=2\nseq=2 a=3\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestEncoderStripBOM(t *testing.T) {
+	data := []struct {
+		value interface{}
+		strip bool
+		want  string
+	}{
+		{value: "\ufeffv", strip: false, want: "k=\ufeffv"},
+		{value: "\ufeffv", strip: true, want: "k=v"},
+		{value: "\ufeff", strip: true, want: "k="},
+		{value: "v", strip: true, want: "k=v"},
+		{value: "v\ufeffv", strip: true, want: "k=v\ufeffv"},
+		{value: "\ufeff\ufeffv", strip: true, want: "k=\ufeffv"},
+		{value: []byte("\ufeffv"), strip: false, want: "k=\ufeffv"},
+		{value: []byte("\ufeffv w"), strip: true, want: `k="v w"`},
+		{value: []byte("v\ufeff"), strip: true, want: "k=v\ufeff"},
+	}
+
+	for _, d := range data {
+		w := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(w)
+		enc.StripBOM = d.strip
+		if err := enc.EncodeKeyval("k", d.value); err != nil {
+			t.Errorf("%q: got error: %v", d.value, err)
+		}
+		if got, want := w.String(), d.want; got != want {
+			t.Errorf("%q: got %q, want %q", d.value, got, want)
+		}
+	}
+}
+
+func TestEncoderMarshalErrorPolicy(t *testing.T) {
+	data := []struct {
+		policy logfmt.MarshalErrorPolicy
+		want   string
+		err    error
+	}{
+		{
+			policy: logfmt.MarshalErrorPropagate,
+			want:   "a=1",
+			err:    &logfmt.MarshalerError{Type: reflect.TypeOf(errorMarshaler{}), Err: errMarshal},
+		},
+		{
+			policy: logfmt.MarshalErrorPlaceholder,
+			want:   `a=1 k="!ERROR:marshal error" b=2`,
+		},
+	}
+
+	for _, d := range data {
+		w := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(w)
+		enc.MarshalErrorPolicy = d.policy
+
+		var err error
+		for _, kv := range [][2]interface{}{{"a", 1}, {"k", errorMarshaler{}}, {"b", 2}} {
+			if err = enc.EncodeKeyval(kv[0], kv[1]); err != nil {
+				break
+			}
+		}
+		if !reflect.DeepEqual(err, d.err) {
+			t.Errorf("policy %v: got error: %v, want error: %v", d.policy, err, d.err)
+		}
+		if got, want := w.String(), d.want; got != want {
+			t.Errorf("policy %v: got '%s', want '%s'", d.policy, got, want)
+		}
+	}
+}
+
+func TestEncoderRejectCR(t *testing.T) {
+	data := []struct {
+		value interface{}
+		want  string
+		err   error
+	}{
+		{value: "a\rb", err: logfmt.ErrCRInValue},
+		{value: "a\r\nb", err: logfmt.ErrCRInValue},
+		{value: []byte("\r"), err: logfmt.ErrCRInValue},
+		{value: errors.New("a\rb"), err: logfmt.ErrCRInValue},
+		{value: "a\nb", want: `k="a\nb"`},
+		{value: "ab", want: "k=ab"},
+	}
+
+	for _, d := range data {
+		w := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(w)
+		enc.RejectCR = true
+		err := enc.EncodeKeyval("k", d.value)
+		if err != d.err {
+			t.Errorf("%q: got error: %v, want error: %v", d.value, err, d.err)
+		}
+		if got, want := w.String(), d.want; got != want {
+			t.Errorf("%q: got '%s', want '%s'", d.value, got, want)
+		}
+	}
+
+	w := &bytes.Buffer{}
+	enc := logfmt.NewEncoder(w)
+	enc.RejectCR = true
+	if err := enc.EncodeKeyvals("a", "x\ry", "b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.String(), `a="carriage return in value" b=1`; got != want {
+		t.Errorf("got '%s', want '%s'", got, want)
+	}
+}
+
+func TestAppendKeyval(t *testing.T) {
+	data := []struct {
+		dst        string
+		key, value interface{}
+		want       string
+		err        error
+	}{
+		{key: "k", value: "v", want: "k=v"},
+		{dst: "a=1", key: "k", value: "v", want: "a=1k=v"},
+		{dst: "a=1 ", key: "k", value: nil, want: "a=1 k=null"},
+		{key: "k", value: "null", want: `k="null"`},
+		{key: "k", value: "v v", want: `k="v v"`},
+		{key: "k", value: 1.025, want: "k=1.025"},
+		{key: "k", value: decimalMarshaler{5, 9}, want: "k=5.9"},
+		{key: "k", value: (*decimalStringer)(nil), want: "k=null"},
+		{dst: "a=1", key: nil, value: "v", want: "a=1", err: logfmt.ErrNilKey},
+		{dst: "a=1", key: "�", value: "v", want: "a=1", err: logfmt.ErrInvalidKey},
+		{dst: "a=1", key: [2]int{}, value: "v", want: "a=1", err: logfmt.ErrUnsupportedKeyType},
+		{dst: "a=1", key: "k", value: (chan int)(nil), want: "a=1", err: logfmt.ErrUnsupportedValueType},
+		{dst: "a=1 ", key: "k", value: []int{4, 5}, want: "a=1 k[0]=4 k[1]=5"},
+	}
+
+	for _, d := range data {
+		got, err := logfmt.AppendKeyval([]byte(d.dst), d.key, d.value)
+		if !errors.Is(err, d.err) {
+			t.Errorf("%#v, %#v: got error: %v, want error: %v", d.key, d.value, err, d.err)
+		}
+		if string(got) != d.want {
+			t.Errorf("%#v, %#v: got '%s', want '%s'", d.key, d.value, got, d.want)
+		}
+		if d.err != nil {
+			continue
+		}
+		m, err := logfmt.MarshalKeyvals(d.key, d.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(got[len(d.dst):]), string(m); got != want {
+			t.Errorf("%#v, %#v: got '%s', MarshalKeyvals '%s'", d.key, d.value, got, want)
+		}
+	}
+}
+
+func BenchmarkAppendKeyval(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]byte, 0, 1024)
+	for i := 0; i < b.N; i++ {
+		buf = buf[:0]
+		buf, _ = logfmt.AppendKeyval(buf, "sk", "10")
+		buf = append(buf, ' ')
+		buf, _ = logfmt.AppendKeyval(buf, "some-key", "a rather long string with spaces")
+	}
+}
+
+func TestEncoderNonFiniteFloatPolicy(t *testing.T) {
+	data := []struct {
+		value  interface{}
+		policy logfmt.NonFiniteFloatPolicy
+		want   string
+		err    error
+	}{
+		{value: math.Inf(1), policy: logfmt.NonFiniteFloatToken, want: "k=+Inf"},
+		{value: math.Inf(-1), policy: logfmt.NonFiniteFloatToken, want: "k=-Inf"},
+		{value: math.NaN(), policy: logfmt.NonFiniteFloatToken, want: "k=NaN"},
+		{value: math.Inf(1), policy: logfmt.NonFiniteFloatNull, want: "k=null"},
+		{value: float32(math.Inf(-1)), policy: logfmt.NonFiniteFloatNull, want: "k=null"},
+		{value: math.NaN(), policy: logfmt.NonFiniteFloatNull, want: "k=null"},
+		{value: math.Inf(1), policy: logfmt.NonFiniteFloatError, err: logfmt.ErrNonFiniteFloat},
+		{value: math.Inf(-1), policy: logfmt.NonFiniteFloatError, err: logfmt.ErrNonFiniteFloat},
+		{value: float32(math.NaN()), policy: logfmt.NonFiniteFloatError, err: logfmt.ErrNonFiniteFloat},
+		{value: 1.5, policy: logfmt.NonFiniteFloatError, want: "k=1.5"},
+		{value: math.MaxFloat64, policy: logfmt.NonFiniteFloatNull, want: "k=1.7976931348623157e+308"},
+	}
+
+	for _, d := range data {
+		w := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(w)
+		enc.NonFiniteFloatPolicy = d.policy
+		err := enc.EncodeKeyval("k", d.value)
+		if err != d.err {
+			t.Errorf("%v, %v: got error: %v, want error: %v", d.value, d.policy, err, d.err)
+		}
+		if got, want := w.String(), d.want; got != want {
+			t.Errorf("%v, %v: got '%s', want '%s'", d.value, d.policy, got, want)
+		}
+	}
+}
+
+func TestEncoderSetFloatFormat(t *testing.T) {
+	type myFloat float64
+	data := []struct {
+		value interface{}
+		fmt   byte
+		prec  int
+		want  string
+	}{
+		{value: 1e-9, want: "k=1e-09"},
+		{value: 1e-9, fmt: 'f', prec: -1, want: "k=0.000000001"},
+		{value: 1.0 / 3, fmt: 'f', prec: 3, want: "k=0.333"},
+		{value: float32(0.1), fmt: 'f', prec: -1, want: "k=0.1"},
+		{value: float32(1.0 / 3), fmt: 'g', prec: -1, want: "k=0.33333334"},
+		{value: 12345.678, fmt: 'e', prec: 2, want: "k=1.23e+04"},
+		{value: myFloat(2.5), fmt: 'f', prec: 2, want: "k=2.50"},
+		{value: math.Inf(1), fmt: 'f', prec: 2, want: "k=+Inf"},
+		{value: 1.5, fmt: 'x', prec: -1, want: "k=0x1.8p+00"},
+	}
+
+	for _, d := range data {
+		w := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(w)
+		enc.SetFloatFormat(d.fmt, d.prec)
+		if err := enc.EncodeKeyval("k", d.value); err != nil {
+			t.Errorf("%v, %q, %d: got error: %v", d.value, d.fmt, d.prec, err)
+		}
+		if got, want := w.String(), d.want; got != want {
+			t.Errorf("%v, %q, %d: got '%s', want '%s'", d.value, d.fmt, d.prec, got, want)
+		}
+	}
+}
+
+func TestEncoderSetKeyPrefix(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := logfmt.NewEncoder(buf)
+	enc.SetKeyPrefix("http.")
+	keyvals := []interface{}{
+		"method", "GET",
+		decimalStringer{2, 0}, "stringer",
+		decimalMarshaler{3, 1}, "marshaler",
+		7, "int",
+		"hdr", map[string]string{"a": "b"},
+	}
+	if err := enc.EncodeKeyvals(keyvals...); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EncodeKeyval("status", 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	enc.Reset()
+	if err := enc.EncodeKeyval("status", 200); err != nil {
+		t.Fatal(err)
+	}
+	want := "http.method=GET http.2.0=stringer http.3.1=marshaler http.7=int http.hdr.a=b\nhttp.status=200\nstatus=200"
+	if got := buf.String(); got != want {
+		t.Errorf("got '%s', want '%s'", got, want)
+	}
+
+	buf.Reset()
+	enc.SetKeyPrefix("bad prefix.")
+	if err := enc.EncodeKeyval("k", "v"); !errors.Is(err, logfmt.ErrInvalidKey) {
+		t.Errorf("got error: %v, want error: %v", err, logfmt.ErrInvalidKey)
+	}
+	if got := buf.String(); got != "" {
+		t.Errorf("got '%s', want ''", got)
+	}
+}
+
+func TestEncoderEmptySliceMarker(t *testing.T) {
+	data := []struct {
+		value  interface{}
+		marker string
+		want   string
+		err    error
+	}{
+		{value: []string(nil), marker: "[]", want: "k=null"},
+		{value: []string{}, marker: "[]", want: "k=[]"},
+		{value: []int{}, marker: "empty list", want: `k="empty list"`},
+		{value: []string{"a"}, marker: "[]", want: "k[0]=a"},
+		{value: []string(nil), want: "k=null"},
+		{value: []string{}, want: ""},
+	}
+
+	for _, d := range data {
+		w := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(w)
+		enc.EmptySliceMarker = d.marker
+		err := enc.EncodeKeyval("k", d.value)
+		if err != d.err {
+			t.Errorf("%#v, %q: got error: %v, want error: %v", d.value, d.marker, err, d.err)
+		}
+		if got, want := w.String(), d.want; got != want {
+			t.Errorf("%#v, %q: got '%s', want '%s'", d.value, d.marker, got, want)
+		}
+	}
+}
+
+func TestEncoderEncodeKeyvals(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := logfmt.NewEncoder(buf)
+
+	if err := enc.EncodeKeyval("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EncodeKeyvals("b", 2, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EncodeKeyvals("d", 4, nil, 5, "e", 6); !errors.Is(err, logfmt.ErrNilKey) {
+		t.Errorf("got error: %v, want error: %v", err, logfmt.ErrNilKey)
+	}
+
+	if got, want := buf.String(), "a=1 b=2 c=null\nd=4"; got != want {
+		t.Errorf("\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestEncoderSchemaOrder(t *testing.T) {
+	data := []struct {
+		name    string
+		schema  []string
+		fill    bool
+		prefix  string
+		records [][]interface{}
+		want    string
+	}{
+		{
+			name:   "reorder",
+			schema: []string{"ts", "level", "msg"},
+			records: [][]interface{}{
+				{"msg", "hello world", "level", "info", "ts", 1},
+			},
+			want: "ts=1 level=info msg=\"hello world\"\n",
+		},
+		{
+			name:   "extra keys",
+			schema: []string{"ts", "level"},
+			records: [][]interface{}{
+				{"z", 1, "level", "info", "a", 2, "ts", 3, "level", "warn"},
+			},
+			want: "ts=3 level=info level=warn z=1 a=2\n",
+		},
+		{
+			name:   "missing keys",
+			schema: []string{"ts", "level", "msg"},
+			records: [][]interface{}{
+				{"msg", "m", "x", 1},
+				{},
+			},
+			want: "msg=m x=1\n",
+		},
+		{
+			name:   "fill missing",
+			schema: []string{"ts", "level", "msg"},
+			fill:   true,
+			records: [][]interface{}{
+				{"msg", "m", "x", 1},
+				{},
+				{"level", "debug", "ts", 2, "msg", "n"},
+			},
+			want: "ts=null level=null msg=m x=1\nts=2 level=debug msg=n\n",
+		},
+		{
+			name:   "fill missing with key prefix",
+			schema: []string{"http.method", "http.status"},
+			fill:   true,
+			prefix: "http.",
+			records: [][]interface{}{
+				{"status", 200},
+			},
+			want: "http.method=null http.status=200\n",
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			enc := logfmt.NewEncoder(buf)
+			enc.SchemaOrder = d.schema
+			enc.FillMissing = d.fill
+			enc.SetKeyPrefix(d.prefix)
+			for _, r := range d.records {
+				if err := enc.EncodeKeyvals(r...); err != nil {
+					t.Fatal(err)
+				}
+				if err := enc.EndRecord(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := buf.String(); got != d.want {
+				t.Errorf("\n got: %q\nwant: %q", got, d.want)
+			}
+		})
+	}
+}
+
+func TestEncoderSchemaOrderReset(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := logfmt.NewEncoder(buf)
+	enc.SchemaOrder = []string{"b", "a"}
+	enc.SequenceKey = "seq"
+
+	if err := enc.EncodeKeyvals("a", 1, "b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("got %q written before EndRecord", buf.String())
+	}
+	enc.Reset()
+	if err := enc.EncodeKeyvals("a", 3, "b", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "seq=1 b=4 a=3\n"; got != want {
+		t.Errorf("\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestEncoderSortKeys(t *testing.T) {
+	data := []struct {
+		name    string
+		schema  []string
+		records [][]interface{}
+		want    string
+	}{
+		{
+			name: "sort",
+			records: [][]interface{}{
+				{"c", 1, "a", 2, "b", 3},
+				{"b", 1},
+				{},
+			},
+			want: "a=2 b=3 c=1\nb=1\n",
+		},
+		{
+			name: "stable",
+			records: [][]interface{}{
+				{"b", 1, "a", 2, "b", 3, "a", 4},
+			},
+			want: "a=2 a=4 b=1 b=3\n",
+		},
+		{
+			name: "rendered keys",
+			records: [][]interface{}{
+				{decimalStringer{5, 9}, 1, decimalMarshaler{1, 2}, 2, "k\tz", 3, "ka", 4},
+			},
+			want: "1.2=2 5.9=1 ka=4 kz=3\n",
+		},
+		{
+			name:   "with schema",
+			schema: []string{"z", "y"},
+			records: [][]interface{}{
+				{"c", 1, "y", 2, "a", 3, "z", 4},
+			},
+			want: "z=4 y=2 a=3 c=1\n",
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			enc := logfmt.NewEncoder(buf)
+			enc.SortKeys = true
+			enc.SchemaOrder = d.schema
+			for _, r := range d.records {
+				if err := enc.EncodeKeyvals(r...); err != nil {
+					t.Fatal(err)
+				}
+				if err := enc.EndRecord(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := buf.String(); got != d.want {
+				t.Errorf("\n got: %q\nwant: %q", got, d.want)
+			}
+		})
+	}
+}
+
+func TestEncoderSetNilValue(t *testing.T) {
+	var nilPtr *int
+	data := []struct {
+		token string
+		value interface{}
+		want  string
+	}{
+		{token: "nil", value: nil, want: "k=nil"},
+		{token: "nil", value: nilPtr, want: "k=nil"},
+		{token: "nil", value: (*decimalMarshaler)(nil), want: "k=nil"},
+		{token: "nil", value: (*decimalStringer)(nil), want: "k=nil"},
+		{token: "nil", value: new(nilMarshaler), want: "k=notnilmarshaler"},
+		{token: "nil", value: []byte(nil), want: "k=nil"},
+		{token: "nil", value: json.RawMessage(nil), want: "k=nil"},
+		{token: "nil", value: "nil", want: `k="nil"`},
+		{token: "nil", value: "null", want: "k=null"},
+		{token: "", value: nil, want: "k="},
+		{token: "", value: "", want: `k=""`},
+		{token: "", value: "v", want: "k=v"},
+	}
+
+	for _, d := range data {
+		w := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(w)
+		if err := enc.SetNilValue(d.token); err != nil {
+			t.Fatalf("%q: got error: %v", d.token, err)
+		}
+		if err := enc.EncodeKeyval("k", d.value); err != nil {
+			t.Errorf("%q, %#v: got error: %v", d.token, d.value, err)
+		}
+		if got, want := w.String(), d.want; got != want {
+			t.Errorf("%q, %#v: got '%s', want '%s'", d.token, d.value, got, want)
+		}
+	}
+}
+
+func TestEncoderSetNilValueInvalid(t *testing.T) {
+	for _, token := range []string{"no value", `"`, "a=b", "\n", "\xbd"} {
+		w := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(w)
+		if err := enc.SetNilValue(token); err != logfmt.ErrInvalidNilValue {
+			t.Errorf("%q: got error: %v, want error: %v", token, err, logfmt.ErrInvalidNilValue)
+		}
+		if err := enc.EncodeKeyval("k", nil); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := w.String(), "k=null"; got != want {
+			t.Errorf("%q: got '%s', want '%s'", token, got, want)
+		}
+	}
+}
+
+func TestEncodeKeyvalOmitEmpty(t *testing.T) {
+	var nilPtr *int
+	empty := []interface{}{nil, nilPtr, "", []byte{}, []string(nil), map[string]int{}, [0]int{}, stringData("")}
+
+	for _, e := range empty {
+		for pos := 0; pos <= 3; pos++ {
+			buf := &bytes.Buffer{}
+			enc := logfmt.NewEncoder(buf)
+			for i, k := range []string{"a", "b", "c"} {
+				var err error
+				if i == pos {
+					err = enc.EncodeKeyvalOmitEmpty("x", e)
+				}
+				if err == nil {
+					err = enc.EncodeKeyvalOmitEmpty(k, i)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if pos == 3 {
+				if err := enc.EncodeKeyvalOmitEmpty("x", e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, want := buf.String(), "a=0 b=1 c=2"; got != want {
+				t.Errorf("%#v at %d: got '%s', want '%s'", e, pos, got, want)
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	enc := logfmt.NewEncoder(buf)
+	for _, v := range []interface{}{0, " ", false, (chan int)(nil)} {
+		if err := enc.EncodeKeyvalOmitEmpty("k", v); err != nil && !errors.Is(err, logfmt.ErrUnsupportedValueType) {
+			t.Fatal(err)
+		}
+	}
+	if got, want := buf.String(), `k=0 k=" " k=false`; got != want {
+		t.Errorf("got '%s', want '%s'", got, want)
+	}
+}
+
+type stringData string
+
+func TestEncoderSetTimeFormat(t *testing.T) {
+	ts := time.Date(2009, time.November, 10, 23, 0, 0, 5, time.UTC)
+	data := []struct {
+		layout string
+		value  interface{}
+		want   string
+	}{
+		{value: ts, want: "k=2009-11-10T23:00:00.000000005Z"},
+		{value: &ts, want: "k=2009-11-10T23:00:00.000000005Z"},
+		{value: time.Time{}, want: "k=0001-01-01T00:00:00Z"},
+		{value: (*time.Time)(nil), want: "k=null"},
+		{layout: time.RFC3339, value: ts, want: "k=2009-11-10T23:00:00Z"},
+		{layout: time.Kitchen, value: ts, want: "k=11:00PM"},
+		{layout: time.Kitchen, value: &ts, want: "k=11:00PM"},
+		{layout: time.Kitchen, value: (*time.Time)(nil), want: "k=null"},
+		{layout: "2006-01-02 15:04", value: ts, want: `k="2009-11-10 23:00"`},
+	}
+
+	for _, d := range data {
+		w := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(w)
+		if d.layout != "" {
+			enc.SetTimeFormat(d.layout)
+		}
+		if err := enc.EncodeKeyval("k", d.value); err != nil {
+			t.Errorf("%q, %v: got error: %v", d.layout, d.value, err)
+		}
+		if got, want := w.String(), d.want; got != want {
+			t.Errorf("%q, %v: got '%s', want '%s'", d.layout, d.value, got, want)
+		}
+	}
+}
+
+type byteSlice []byte
+
+type structError struct {
+	Op   string
+	Code int
+}
+
+func (e structError) Error() string {
+	return fmt.Sprintf("%s failed: code %d", e.Op, e.Code)
+}
+
+type ptrError struct {
+	msg string
+}
+
+func (e *ptrError) Error() string {
+	return e.msg
+}
+
+func TestEncodeErrorValues(t *testing.T) {
+	data := []struct {
+		value interface{}
+		want  string
+	}{
+		{value: errors.New("file not found"), want: `err="file not found"`},
+		{value: errors.New("eof"), want: "err=eof"},
+		{value: errors.New("nil"), want: `err="nil"`},
+		{value: structError{"read", 5}, want: `err="read failed: code 5"`},
+		{value: &ptrError{"boom"}, want: "err=boom"},
+		{value: (*ptrError)(nil), want: "err=nil"},
+		{value: fmt.Errorf("wrapped: %w", structError{"open", 2}), want: `err="wrapped: open failed: code 2"`},
+	}
+
+	for _, d := range data {
+		w := &bytes.Buffer{}
+		enc := logfmt.NewEncoder(w)
+		if err := enc.SetNilValue("nil"); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EncodeKeyval("err", d.value); err != nil {
+			t.Errorf("%#v: got error: %v", d.value, err)
+		}
+		if got, want := w.String(), d.want; got != want {
+			t.Errorf("%#v: got '%s', want '%s'", d.value, got, want)
+		}
+	}
+}
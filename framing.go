@@ -0,0 +1,110 @@
+package logfmt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Length prefixed framing writes each record preceded by its length in bytes
+// as a 4 byte big-endian unsigned integer, in place of the newline that
+// normally terminates a record. Readers can then allocate exactly and need
+// not scan for record boundaries.
+
+const frameHeaderLen = 4
+
+// maxFrameLen is the length of the longest record that can be framed. It is
+// a variable so that tests can lower it.
+var maxFrameLen uint64 = math.MaxUint32
+
+// NewLengthPrefixedEncoder returns a new encoder that writes length prefixed
+// records to w. Key/value pairs are buffered until EndRecord, which writes
+// the length of the record followed by the record itself. Reset discards the
+// buffered pairs.
+func NewLengthPrefixedEncoder(w io.Writer) *Encoder {
+	fw := &frameWriter{w: w}
+	enc := NewEncoder(fw)
+	enc.framer = fw
+	return enc
+}
+
+// NewLengthPrefixedDecoder returns a new decoder that reads length prefixed
+// records, as written by an encoder returned by NewLengthPrefixedEncoder,
+// from r.
+//
+// The decoder introduces its own buffering and may read data from r beyond
+// the logfmt records requested. Records longer than bufio.MaxScanTokenSize
+// cause a SyntaxError that wraps bufio.ErrTooLong.
+func NewLengthPrefixedDecoder(r io.Reader) *Decoder {
+	dec := NewDecoder(r)
+	dec.split = dec.scanFrames
+	dec.s.Split(dec.split)
+	return dec
+}
+
+// ErrTruncatedFrame is returned by Decoder methods if the input to a length
+// prefixed decoder ends within a record.
+var ErrTruncatedFrame = errors.New("truncated length prefixed record")
+
+// ErrFrameTooLong is returned by Encoder methods if a length prefixed record
+// is too long for its length to be encoded.
+var ErrFrameTooLong = errors.New("length prefixed record too long")
+
+type frameWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	fw.reserveHeader()
+	fw.buf = append(fw.buf, p...)
+	return len(p), nil
+}
+
+// reserveHeader reserves space for the length header at the start of the
+// buffer, so that the header and record are written by a single Write.
+func (fw *frameWriter) reserveHeader() {
+	if len(fw.buf) == 0 {
+		fw.buf = append(fw.buf, make([]byte, frameHeaderLen)...)
+	}
+}
+
+func (fw *frameWriter) discard() {
+	fw.buf = fw.buf[:0]
+}
+
+func (fw *frameWriter) endRecord() error {
+	defer fw.discard()
+	fw.reserveHeader()
+	n := uint64(len(fw.buf) - frameHeaderLen)
+	if n > maxFrameLen {
+		return ErrFrameTooLong
+	}
+	binary.BigEndian.PutUint32(fw.buf, uint32(n))
+	written, err := fw.w.Write(fw.buf)
+	if err == nil && written < len(fw.buf) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// scanFrames is a split function for a bufio.Scanner that splits length
+// prefixed records. A length that would not fit in the scanner's buffer is
+// rejected with bufio.ErrTooLong before any conversion to int.
+func (dec *Decoder) scanFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) >= frameHeaderLen {
+		n := uint64(binary.BigEndian.Uint32(data))
+		if n > uint64(dec.maxSize-frameHeaderLen) {
+			return 0, nil, bufio.ErrTooLong
+		}
+		if end := frameHeaderLen + int(n); len(data) >= end {
+			return end, data[frameHeaderLen:end], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return 0, nil, ErrTruncatedFrame
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,149 @@
+package logfmt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestLengthPrefixedRoundTrip(t *testing.T) {
+	records := [][]interface{}{
+		{"a", 1, "b", "two words"},
+		{"msg", "line one\nline two", "c", `"quoted"`},
+		{},
+		{"d", "\r\n"},
+	}
+
+	buf := &bytes.Buffer{}
+	enc := NewLengthPrefixedEncoder(buf)
+	enc.SetEmitEmptyRecords(true)
+	for _, r := range records {
+		if err := enc.EncodeKeyvals(r...); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EndRecord(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.EncodeKeyval("discarded", 1); err != nil {
+		t.Fatal(err)
+	}
+	enc.Reset()
+
+	if got, want := buf.Bytes()[:4], []byte{0, 0, 0, 17}; !bytes.Equal(got, want) {
+		t.Errorf("header: got %v, want %v", got, want)
+	}
+
+	want := [][]kv{
+		{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("two words")}},
+		{{[]byte("msg"), []byte("line one\nline two")}, {[]byte("c"), []byte(`"quoted"`)}},
+		nil,
+		{{[]byte("d"), []byte("\r\n")}},
+	}
+	var got [][]kv
+	dec := NewLengthPrefixedDecoder(buf)
+	for dec.ScanRecord() {
+		var kvs []kv
+		for dec.ScanKeyval() {
+			kvs = append(kvs, kv{dec.Key(), dec.Value()})
+		}
+		got = append(got, kvs)
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestLengthPrefixedDecoderTruncated(t *testing.T) {
+	dec := NewLengthPrefixedDecoder(bytes.NewReader([]byte{0, 0, 0, 5, 'a', '=', '1'}))
+	for dec.ScanRecord() {
+		t.Error("ScanRecord: got true")
+	}
+	if got, want := dec.Err(), ErrTruncatedFrame; got != want {
+		t.Errorf("got err: %v, want: %v", got, want)
+	}
+}
+
+func TestLengthPrefixedEncoderTooLong(t *testing.T) {
+	defer func(n uint64) { maxFrameLen = n }(maxFrameLen)
+	maxFrameLen = 8
+
+	buf := &bytes.Buffer{}
+	enc := NewLengthPrefixedEncoder(buf)
+	enc.EncodeKeyval("msg", "too long")
+	if got, want := enc.EndRecord(), ErrFrameTooLong; got != want {
+		t.Errorf("got err: %v, want: %v", got, want)
+	}
+	enc.EncodeKeyval("a", 1)
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.Bytes(), []byte{0, 0, 0, 3, 'a', '=', '1'}; !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// shortWriter accepts at most n bytes of each Write, and records them.
+type shortWriter struct {
+	n      int
+	writes [][]byte
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		p = p[:w.n]
+	}
+	w.writes = append(w.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestLengthPrefixedEncoderShortWrite(t *testing.T) {
+	w := &shortWriter{n: 2}
+	enc := NewLengthPrefixedEncoder(w)
+	enc.EncodeKeyval("a", 1)
+	if got, want := enc.EndRecord(), io.ErrShortWrite; got != want {
+		t.Errorf("got err: %v, want: %v", got, want)
+	}
+
+	w.n = 100
+	enc.EncodeKeyval("b", 2)
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{{0, 0}, {0, 0, 0, 3, 'b', '=', '2'}}
+	if !reflect.DeepEqual(w.writes, want) {
+		t.Errorf("got writes %q, want %q", w.writes, want)
+	}
+}
+
+func TestLengthPrefixedDecoderTooLong(t *testing.T) {
+	dec := NewLengthPrefixedDecoder(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 'a', '=', '1'}))
+	for dec.ScanRecord() {
+		t.Error("ScanRecord: got true")
+	}
+	if err := dec.Err(); !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("got err: %v, want: %v", err, bufio.ErrTooLong)
+	}
+}
+
+func TestLengthPrefixedDecoderReset(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := NewLengthPrefixedEncoder(buf)
+	enc.EncodeKeyval("msg", "a\nb")
+	enc.EndRecord()
+
+	dec := NewLengthPrefixedDecoder(bytes.NewReader(nil))
+	dec.Reset(bytes.NewReader(buf.Bytes()))
+	if !dec.ScanRecord() || !dec.ScanKeyval() {
+		t.Fatalf("got no record, err %v", dec.Err())
+	}
+	if got, want := string(dec.Value()), "a\nb"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
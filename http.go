@@ -0,0 +1,16 @@
+package logfmt
+
+import "net/http"
+
+// NewFlushingEncoder returns a new encoder that writes to w and flushes w
+// after each call to EndRecord, so that clients streaming the response see
+// each record as soon as it is complete. If w does not implement
+// http.Flusher, records are written without flushing and the encoder behaves
+// like one returned by NewEncoder.
+func NewFlushingEncoder(w http.ResponseWriter) *Encoder {
+	enc := NewEncoder(w)
+	if f, ok := w.(http.Flusher); ok {
+		enc.flush = f.Flush
+	}
+	return enc
+}
@@ -0,0 +1,52 @@
+package logfmt_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestNewFlushingEncoder(t *testing.T) {
+	rec := httptest.NewRecorder()
+	enc := logfmt.NewFlushingEncoder(rec)
+
+	if err := enc.EncodeKeyval("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Flushed {
+		t.Error("flushed before EndRecord")
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Flushed {
+		t.Error("not flushed after EndRecord")
+	}
+	if got, want := rec.Body.String(), "a=1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type nonFlushingWriter struct {
+	http.ResponseWriter
+}
+
+func TestNewFlushingEncoderNoFlusher(t *testing.T) {
+	rec := httptest.NewRecorder()
+	enc := logfmt.NewFlushingEncoder(nonFlushingWriter{rec})
+
+	if err := enc.EncodeKeyval("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Flushed {
+		t.Error("flushed through a writer that is not an http.Flusher")
+	}
+	if got, want := rec.Body.String(), "a=1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
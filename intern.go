@@ -0,0 +1,39 @@
+package logfmt
+
+// SetStringInterner sets a function that the string returning methods of the
+// Decoder, KeyString, ValueString, ScanKeyvalString, DecodeMap, and
+// DecodeKeyvalsSlice, use to convert keys and values to strings, in place of
+// allocating a new string for each. An interner that returns the same string
+// for equal tokens, such as one returned by NewInterner, reduces the memory
+// held by decoded records when keys and values repeat. intern must return a
+// string equal to its argument, and must not retain the argument, which is
+// only valid until it returns. Key and Value are not affected. Passing nil
+// restores the default of allocating a new string for each call.
+func (dec *Decoder) SetStringInterner(intern func([]byte) string) {
+	dec.intern = intern
+}
+
+// string returns b as a string, using the interner if one is set.
+func (dec *Decoder) string(b []byte) string {
+	if dec.intern != nil {
+		return dec.intern(b)
+	}
+	return string(b)
+}
+
+// NewInterner returns a function, suitable for Decoder.SetStringInterner,
+// that returns a single shared string for all equal byte slices passed to
+// it. The strings are held in a map that grows with each distinct token and
+// is never pruned, so it is best suited to tokens drawn from a limited set,
+// such as keys. The returned function is not safe for concurrent use.
+func NewInterner() func([]byte) string {
+	m := map[string]string{}
+	return func(b []byte) string {
+		if s, ok := m[string(b)]; ok {
+			return s
+		}
+		s := string(b)
+		m[s] = s
+		return s
+	}
+}
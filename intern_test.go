@@ -0,0 +1,66 @@
+package logfmt_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestDecoderSetStringInterner(t *testing.T) {
+	var calls []string
+	intern := logfmt.NewInterner()
+	dec := logfmt.NewDecoder(strings.NewReader("level=info msg=a\nlevel=info msg=b\n"))
+	dec.SetStringInterner(func(b []byte) string {
+		s := intern(b)
+		calls = append(calls, s)
+		return s
+	})
+
+	var got []string
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			got = append(got, dec.KeyString(), dec.ValueString())
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"level", "info", "msg", "a", "level", "info", "msg", "b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("interner called with %q, want %q", calls, want)
+	}
+
+	dec = logfmt.NewDecoder(strings.NewReader("a=1 b=2\n"))
+	dec.SetStringInterner(func([]byte) string { return "x" })
+	dec.ScanRecord()
+	m, err := dec.DecodeMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"x": "x"}; !reflect.DeepEqual(m, want) {
+		t.Errorf("DecodeMap: got %q, want %q", m, want)
+	}
+}
+
+func TestNewInternerAllocs(t *testing.T) {
+	intern := logfmt.NewInterner()
+	keys := [][]byte{[]byte("level"), []byte("msg"), []byte("ts")}
+	for _, k := range keys {
+		if got := intern(k); got != string(k) {
+			t.Errorf("got %q, want %q", got, k)
+		}
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, k := range keys {
+			intern(k)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs for known tokens, want 0", allocs)
+	}
+}
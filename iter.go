@@ -0,0 +1,44 @@
+//go:build go1.23
+
+package logfmt
+
+import "iter"
+
+// All returns an iterator over the key/value pairs of all remaining records,
+// as found by ScanRecord and ScanKeyval. The iterator stops at the end of the
+// input or at the first error, which is then reported by Err. The yielded
+// slices are subject to the same lifetime rules as those returned by Key and
+// Value.
+func (dec *Decoder) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func(key, value []byte) bool) {
+		for dec.ScanRecord() {
+			for dec.ScanKeyval() {
+				if !yield(dec.key, dec.value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Records returns an iterator over the remaining records, each of which is an
+// iterator over the key/value pairs of the record. Advancing to the next
+// record discards any pairs of the current record not yet iterated. Errors
+// are handled as by All.
+func (dec *Decoder) Records() iter.Seq[iter.Seq2[[]byte, []byte]] {
+	return func(yield func(iter.Seq2[[]byte, []byte]) bool) {
+		for dec.ScanRecord() {
+			if !yield(dec.pairsOfRecord) {
+				return
+			}
+		}
+	}
+}
+
+func (dec *Decoder) pairsOfRecord(yield func(key, value []byte) bool) {
+	for dec.ScanKeyval() {
+		if !yield(dec.key, dec.value) {
+			return
+		}
+	}
+}
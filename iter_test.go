@@ -0,0 +1,67 @@
+//go:build go1.23
+
+package logfmt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoder_All(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1 b=\"x y\"\n\nc\nd=4 =bad\ne=5\n"))
+	var got []kv
+	for k, v := range dec.All() {
+		got = append(got, kv{k, v})
+	}
+	want := []kv{
+		{[]byte("a"), []byte("1")},
+		{[]byte("b"), []byte("x y")},
+		{[]byte("c"), nil},
+		{[]byte("d"), []byte("4")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	wantErr := &SyntaxError{Msg: "unexpected '='", Line: 4, Pos: 5, Err: ErrUnexpectedEquals}
+	if err := dec.Err(); !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("got error %v, want %v", err, wantErr)
+	}
+
+	dec = NewDecoder(strings.NewReader("a=1 b=2\nc=3\n"))
+	for k := range dec.All() {
+		if string(k) == "b" {
+			break
+		}
+	}
+	if !dec.ScanRecord() || !dec.ScanKeyval() || string(dec.Key()) != "c" {
+		t.Errorf("after break: got key %q, want %q", dec.Key(), "c")
+	}
+}
+
+func TestDecoder_Records(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1 b=2 c=3\nd=4\n\ne=5 f=6\n"))
+	var got [][]kv
+	for rec := range dec.Records() {
+		var pairs []kv
+		for k, v := range rec {
+			pairs = append(pairs, kv{k, v})
+			if string(k) == "b" {
+				break
+			}
+		}
+		got = append(got, pairs)
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]kv{
+		{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("2")}},
+		{{[]byte("d"), []byte("4")}},
+		nil,
+		{{[]byte("e"), []byte("5")}, {[]byte("f"), []byte("6")}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+package logfmt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ToJSON decodes the logfmt records in data, as by DecodeBytes, and returns
+// them as a JSON array with one object per record. Values are JSON strings,
+// and keys without a value have the value null. If a key is repeated within
+// a record the last value wins, at the position of the first occurrence.
+// An empty line produces an empty object, and empty input an empty array.
+func ToJSON(data []byte) ([]byte, error) {
+	recs, err := DecodeBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, rec := range recs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONObject(&buf, rec)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+func writeJSONObject(buf *bytes.Buffer, rec []KeyValue) {
+	var (
+		keys  []string
+		vals  = map[string][]byte{}
+		isNil = map[string]bool{}
+	)
+	for _, kv := range rec {
+		k := string(kv.Key)
+		if _, ok := vals[k]; !ok {
+			keys = append(keys, k)
+		}
+		vals[k], isNil[k] = kv.Value, kv.Value == nil
+	}
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(buf, []byte(k))
+		buf.WriteByte(':')
+		if isNil[k] {
+			buf.WriteString("null")
+		} else {
+			writeJSONString(buf, vals[k])
+		}
+	}
+	buf.WriteByte('}')
+}
+
+// writeJSONString writes s to buf as a JSON string. The quoted strings of
+// logfmt use the syntax of JSON strings, without the escaping of HTML
+// characters done by encoding/json.
+func writeJSONString(buf *bytes.Buffer, s []byte) {
+	writeQuotedBytes(buf, s, nil)
+}
+
+// ErrNotJSONObject is returned by FromJSON if its input is not a JSON object
+// or an array of JSON objects.
+var ErrNotJSONObject = errors.New("JSON value is not an object or array of objects")
+
+// FromJSON converts jsonData, which must hold a JSON object or an array of
+// JSON objects, to logfmt with one record per object, each terminated by a
+// newline. The records are written by an encoder configured by opts.
+//
+// The members of each object are written in order. Numbers are written as
+// they appear in jsonData, and null as the encoder's nil value. Nested
+// objects and arrays are flattened, with keys of the form key.member and
+// key[i], and the members of nested objects are sorted by name.
+//
+// ErrInvalidKey is returned if the name of a member contains runes that are
+// not valid in a key, unless a key sanitizer is set by opts, in which case
+// the names of the members of each object, but not of nested objects, are
+// passed to the sanitizer.
+func FromJSON(jsonData []byte, opts ...EncoderOption) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := NewEncoderWith(&buf, opts...)
+	enc.SetEmitEmptyRecords(true)
+	jd := json.NewDecoder(bytes.NewReader(jsonData))
+	jd.UseNumber()
+
+	tok, err := jd.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		err = encodeJSONObject(enc, jd)
+	case json.Delim('['):
+		for err == nil && jd.More() {
+			if tok, err = jd.Token(); err == nil {
+				if tok != json.Delim('{') {
+					return nil, ErrNotJSONObject
+				}
+				err = encodeJSONObject(enc, jd)
+			}
+		}
+		if err == nil {
+			_, err = jd.Token() // ]
+		}
+	default:
+		return nil, ErrNotJSONObject
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := jd.Token(); err != io.EOF {
+		if err == nil {
+			err = ErrNotJSONObject
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeJSONObject encodes the members of the object whose opening brace
+// has been read from jd as a record.
+func encodeJSONObject(enc *Encoder, jd *json.Decoder) error {
+	for jd.More() {
+		tok, err := jd.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		var value interface{}
+		if err := jd.Decode(&value); err != nil {
+			return err
+		}
+		if enc.sanitize == nil && (key == "" || strings.IndexFunc(key, isInvalidKeyRune) >= 0) {
+			return ErrInvalidKey
+		}
+		if err := enc.EncodeKeyval(key, value); err != nil {
+			return err
+		}
+	}
+	if _, err := jd.Token(); err != nil { // }
+		return err
+	}
+	return enc.EndRecord()
+}
@@ -0,0 +1,91 @@
+package logfmt_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestToJSON(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "", want: "[]"},
+		{in: "a=1 b=\"x \\\"y\\\"\" c\n", want: `[{"a":"1","b":"x \"y\"","c":null}]`},
+		{in: "a=1 b=2 a=3\n\nd=\n", want: `[{"a":"3","b":"2"},{},{"d":null}]`},
+		{in: "k=\"<\\u00e9>\"", want: `[{"k":"<é>"}]`},
+	}
+	for _, test := range tests {
+		got, err := logfmt.ToJSON([]byte(test.in))
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%q: got %s, want %s", test.in, got, test.want)
+		}
+	}
+
+	if _, err := logfmt.ToJSON([]byte("a=1\nb=\"2")); err == nil {
+		t.Error("no error for invalid logfmt")
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		opts []logfmt.EncoderOption
+		want string
+		err  error
+	}{
+		{in: `{}`, want: "\n"},
+		{in: `{"z":1,"a":"two words","n":null,"t":true,"f":1.50}`, want: "z=1 a=\"two words\" n=null t=true f=1.50\n"},
+		{in: `[{"a":1},{"b":{"y":2,"x":[3,{"q":4}]}}]`, want: "a=1\nb.x[0]=3 b.x[1].q=4 b.y=2\n"},
+		{in: ` [ ] `, want: ""},
+		{in: `{"a":null}`, opts: []logfmt.EncoderOption{logfmt.WithNilValue("-")}, want: "a=-\n"},
+		{in: `{"a b":1}`, err: logfmt.ErrInvalidKey},
+		{in: `{"a=b":1}`, err: logfmt.ErrInvalidKey},
+		{in: `{"":1}`, err: logfmt.ErrInvalidKey},
+		{in: `{"o":{"a b":1}}`, err: logfmt.ErrInvalidKey},
+		{
+			in:   `{"a b":1,"c=d":2}`,
+			opts: []logfmt.EncoderOption{logfmt.WithKeySanitizer(func(k string) string { return strings.NewReplacer(" ", "_", "=", "_").Replace(k) })},
+			want: "a_b=1 c_d=2\n",
+		},
+		{in: `1`, err: logfmt.ErrNotJSONObject},
+		{in: `[{"a":1},2]`, err: logfmt.ErrNotJSONObject},
+		{in: `{"a":1} {"b":2}`, err: logfmt.ErrNotJSONObject},
+	}
+	for _, test := range tests {
+		got, err := logfmt.FromJSON([]byte(test.in), test.opts...)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.in, err, test.err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got %q, want %q", test.in, got, test.want)
+		}
+	}
+
+	for _, in := range []string{``, `{"a":`, `{"a":1`, `[{"a":1}`} {
+		if _, err := logfmt.FromJSON([]byte(in)); err == nil {
+			t.Errorf("%s: no error for invalid JSON", in)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	in := "a=1 b=\"x y\" c=\"q\\\"uote\"\nd=4\n"
+	j, err := logfmt.ToJSON([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := logfmt.FromJSON(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != in {
+		t.Errorf("got %q, want %q", got, in)
+	}
+}
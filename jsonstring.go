@@ -34,13 +34,16 @@ func poolBuffer(buf *bytes.Buffer) {
 }
 
 // NOTE: keep in sync with writeQuotedBytes below.
-func writeQuotedString(w io.Writer, s string) (int, error) {
+//
+// Control characters are escaped unless keep is not nil and returns true for
+// them, in which case they are written unchanged.
+func writeQuotedString(w io.Writer, s string, keep func(rune) bool) (int, error) {
 	buf := getBuffer()
 	buf.WriteByte('"')
 	start := 0
 	for i := 0; i < len(s); {
 		if b := s[i]; b < utf8.RuneSelf {
-			if 0x20 <= b && b != '\\' && b != '"' {
+			if 0x20 <= b && b != '\\' && b != '"' && b != 0x7f || isControl(rune(b)) && keep != nil && keep(rune(b)) {
 				i++
 				continue
 			}
@@ -61,7 +64,8 @@ func writeQuotedString(w io.Writer, s string) (int, error) {
 				buf.WriteByte('\\')
 				buf.WriteByte('t')
 			default:
-				// This encodes bytes < 0x20 except for \n, \r, and \t.
+				// This encodes bytes < 0x20 except for \n, \r, and \t,
+				// and DEL.
 				buf.WriteString(`\u00`)
 				buf.WriteByte(hex[b>>4])
 				buf.WriteByte(hex[b&0xF])
@@ -71,11 +75,11 @@ func writeQuotedString(w io.Writer, s string) (int, error) {
 			continue
 		}
 		c, size := utf8.DecodeRuneInString(s[i:])
-		if c == utf8.RuneError {
+		if c == utf8.RuneError || isControl(c) && (keep == nil || !keep(c)) {
 			if start < i {
 				buf.WriteString(s[start:i])
 			}
-			buf.WriteString(`\ufffd`)
+			writeEscapedRune(buf, c)
 			i += size
 			start = i
 			continue
@@ -92,13 +96,13 @@ func writeQuotedString(w io.Writer, s string) (int, error) {
 }
 
 // NOTE: keep in sync with writeQuoteString above.
-func writeQuotedBytes(w io.Writer, s []byte) (int, error) {
+func writeQuotedBytes(w io.Writer, s []byte, keep func(rune) bool) (int, error) {
 	buf := getBuffer()
 	buf.WriteByte('"')
 	start := 0
 	for i := 0; i < len(s); {
 		if b := s[i]; b < utf8.RuneSelf {
-			if 0x20 <= b && b != '\\' && b != '"' {
+			if 0x20 <= b && b != '\\' && b != '"' && b != 0x7f || isControl(rune(b)) && keep != nil && keep(rune(b)) {
 				i++
 				continue
 			}
@@ -119,7 +123,8 @@ func writeQuotedBytes(w io.Writer, s []byte) (int, error) {
 				buf.WriteByte('\\')
 				buf.WriteByte('t')
 			default:
-				// This encodes bytes < 0x20 except for \n, \r, and \t.
+				// This encodes bytes < 0x20 except for \n, \r, and \t,
+				// and DEL.
 				buf.WriteString(`\u00`)
 				buf.WriteByte(hex[b>>4])
 				buf.WriteByte(hex[b&0xF])
@@ -129,11 +134,11 @@ func writeQuotedBytes(w io.Writer, s []byte) (int, error) {
 			continue
 		}
 		c, size := utf8.DecodeRune(s[i:])
-		if c == utf8.RuneError {
+		if c == utf8.RuneError || isControl(c) && (keep == nil || !keep(c)) {
 			if start < i {
 				buf.Write(s[start:i])
 			}
-			buf.WriteString(`\ufffd`)
+			writeEscapedRune(buf, c)
 			i += size
 			start = i
 			continue
@@ -149,6 +154,24 @@ func writeQuotedBytes(w io.Writer, s []byte) (int, error) {
 	return n, err
 }
 
+// isControl reports whether r is a control character: a C0 control below
+// U+0020, DEL, or a C1 control in the range U+0080 to U+009F.
+func isControl(r rune) bool {
+	return r < 0x20 || 0x7f <= r && r <= 0x9f
+}
+
+// writeEscapedRune writes the \uXXXX escape sequence for the multibyte rune
+// r, which is either utf8.RuneError or a C1 control character.
+func writeEscapedRune(buf *bytes.Buffer, r rune) {
+	if r == utf8.RuneError {
+		buf.WriteString(`\ufffd`)
+		return
+	}
+	buf.WriteString(`\u00`)
+	buf.WriteByte(hex[r>>4])
+	buf.WriteByte(hex[r&0xF])
+}
+
 // getu4 decodes \uXXXX from the beginning of s, returning the hex value,
 // or it returns -1.
 func getu4(s []byte) rune {
@@ -162,8 +185,12 @@ func getu4(s []byte) rune {
 	return rune(r)
 }
 
-func unquoteBytes(s []byte) (t []byte, ok bool) {
-	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+// unquoteBytes unquotes the JSON style string s, which is quoted by q, either
+// a quotation mark or an apostrophe. An unescaped q within s is invalid. If
+// allowNL is true, s may contain newlines, which are kept; other control
+// characters are invalid.
+func unquoteBytes(s []byte, q byte, allowNL bool) (t []byte, ok bool) {
+	if len(s) < 2 || s[0] != q || s[len(s)-1] != q {
 		return
 	}
 	s = s[1 : len(s)-1]
@@ -174,7 +201,7 @@ func unquoteBytes(s []byte) (t []byte, ok bool) {
 	r := 0
 	for r < len(s) {
 		c := s[r]
-		if c == '\\' || c == '"' || c < ' ' {
+		if c == '\\' || c == q || c < ' ' && !(allowNL && c == '\n') {
 			break
 		}
 		if c < utf8.RuneSelf {
@@ -257,7 +284,7 @@ func unquoteBytes(s []byte) (t []byte, ok bool) {
 			}
 
 		// Quote, control characters are invalid.
-		case c == '"', c < ' ':
+		case c == q, c < ' ' && !(allowNL && c == '\n'):
 			return
 
 		// ASCII
@@ -0,0 +1,56 @@
+package logfmt
+
+import (
+	"io"
+	"strings"
+)
+
+// A KeyBuilder composes a hierarchical key, such as service.http.latency_ms,
+// from parts joined by a separator. A KeyBuilder may be used directly as a
+// key with Encoder methods and Marshal functions. Unlike other keys, from
+// which invalid runes are removed, a KeyBuilder key is rejected with
+// ErrInvalidKey if it has no parts, if any part is empty, or if any part or
+// the separator contains a rune that is not valid in a key.
+//
+// The zero value is an empty KeyBuilder that joins parts with ".". A
+// KeyBuilder is immutable, so one holding a common prefix may be shared and
+// extended by different callers.
+type KeyBuilder struct {
+	sep   string
+	parts []string
+}
+
+// NewKeyBuilder returns an empty KeyBuilder that joins parts with sep. An
+// empty sep selects the default separator ".".
+func NewKeyBuilder(sep string) KeyBuilder {
+	return KeyBuilder{sep: sep}
+}
+
+// Add returns a KeyBuilder with part appended to the parts of kb.
+func (kb KeyBuilder) Add(part string) KeyBuilder {
+	kb.parts = append(kb.parts[:len(kb.parts):len(kb.parts)], part)
+	return kb
+}
+
+// String returns the parts of kb joined by its separator.
+func (kb KeyBuilder) String() string {
+	return strings.Join(kb.parts, kb.separator())
+}
+
+func (kb KeyBuilder) separator() string {
+	if kb.sep == "" {
+		return "."
+	}
+	return kb.sep
+}
+
+// writeKey writes the key built by kb to w, or returns ErrInvalidKey if it
+// is not valid.
+func (kb KeyBuilder) writeKey(w io.Writer) error {
+	for _, part := range kb.parts {
+		if part == "" {
+			return ErrInvalidKey
+		}
+	}
+	return writeStringKey(w, kb.String(), true)
+}
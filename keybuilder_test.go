@@ -0,0 +1,42 @@
+package logfmt_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestKeyBuilder(t *testing.T) {
+	service := logfmt.KeyBuilder{}.Add("service")
+	http := service.Add("http")
+	db := service.Add("db")
+
+	tests := []struct {
+		key  logfmt.KeyBuilder
+		want string
+		err  error
+	}{
+		{key: http.Add("latency_ms"), want: "service.http.latency_ms=1"},
+		{key: db.Add("latency_ms"), want: "service.db.latency_ms=1"},
+		{key: logfmt.NewKeyBuilder("/").Add("a").Add("b"), want: "a/b=1"},
+		{key: logfmt.NewKeyBuilder("").Add("a").Add("b"), want: "a.b=1"},
+		{key: logfmt.KeyBuilder{}, err: logfmt.ErrInvalidKey},
+		{key: service.Add(""), err: logfmt.ErrInvalidKey},
+		{key: service.Add("a b"), err: logfmt.ErrInvalidKey},
+		{key: logfmt.NewKeyBuilder("=").Add("a").Add("b"), err: logfmt.ErrInvalidKey},
+	}
+	for _, test := range tests {
+		got, err := logfmt.MarshalKeyvals(test.key, 1)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%q: got error %v, want %v", test.key, err, test.err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%q: got %q, want %q", test.key, got, test.want)
+		}
+	}
+
+	if got, want := service.String(), "service"; got != want {
+		t.Errorf("shared prefix modified: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+package logfmt
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// A Logger writes leveled log records to an output stream. Each record
+// begins with a level pair and a msg pair, followed by the caller's keyvals
+// encoded as by MarshalKeyvals, and is terminated by a newline.
+//
+// A Logger's methods may be called concurrently. Each record is written to
+// the underlying writer with a single Write call, so records written by
+// different goroutines are never interleaved.
+type Logger struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf bytes.Buffer
+	enc *Encoder
+}
+
+// NewLogger returns a new Logger that writes to w.
+func NewLogger(w io.Writer) *Logger {
+	l := &Logger{w: w}
+	l.enc = NewEncoder(&l.buf)
+	return l
+}
+
+// Debug writes a record with level=debug, msg, and keyvals, a variadic
+// sequence of alternating keys and values.
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log("debug", msg, keyvals)
+}
+
+// Info writes a record with level=info, msg, and keyvals, a variadic sequence
+// of alternating keys and values.
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log("info", msg, keyvals)
+}
+
+// Error writes a record with level=error, msg, and keyvals, a variadic
+// sequence of alternating keys and values.
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log("error", msg, keyvals)
+}
+
+// log writes a single record. A record containing an invalid key is dropped,
+// as are errors returned by the underlying writer; a Logger has no way to
+// report them to the caller.
+func (l *Logger) log(level, msg string, keyvals []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf.Reset()
+	l.enc.Reset()
+	if err := l.enc.EncodeKeyvals("level", level, "msg", msg); err != nil {
+		return
+	}
+	if err := l.enc.EncodeKeyvals(keyvals...); err != nil {
+		return
+	}
+	if err := l.enc.EndRecord(); err != nil {
+		return
+	}
+	l.w.Write(l.buf.Bytes())
+}
@@ -0,0 +1,70 @@
+package logfmt_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := logfmt.NewLogger(&buf)
+	l.Info("started", "port", 8080)
+	l.Debug("tick")
+	l.Error("request failed", "err", errors.New("timed out"), "attempt")
+	l.Info("dropped", "", "bad key")
+	l.Info("after")
+
+	want := `level=info msg=started port=8080
+level=debug msg=tick
+level=error msg="request failed" err="timed out" attempt=null
+level=info msg=after
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// lockedWriter records each Write call separately.
+type lockedWriter struct {
+	mu     sync.Mutex
+	writes []string
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestLoggerConcurrent(t *testing.T) {
+	w := &lockedWriter{}
+	l := logfmt.NewLogger(w)
+
+	const goroutines, records = 8, 50
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < records; i++ {
+				l.Info("hello", "g", g, "i", i)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if got, want := len(w.writes), goroutines*records; got != want {
+		t.Fatalf("got %d writes, want %d", got, want)
+	}
+	for _, rec := range w.writes {
+		if !strings.HasPrefix(rec, "level=info msg=hello g=") || strings.Count(rec, "\n") != 1 {
+			t.Errorf("malformed record %q", rec)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package logfmt
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// NewMessageWriter returns a writer that writes each line written to it to w
+// as a logfmt record with a single pair, whose key is key and whose value is
+// the line, quoted as necessary. It adapts code that writes plain text lines,
+// such as a log.Logger, to produce logfmt.
+//
+// Lines are terminated by newlines, which are not part of the value, and a
+// carriage return preceding a newline is removed. A single Write may contain
+// any number of lines, and a line may be split across Writes; the bytes of a
+// partial line are buffered until its newline is written. The returned writer
+// also implements io.Closer, and its Close method writes any buffered partial
+// line as a record. Close does not close w.
+//
+// The writer's methods may be called concurrently. Write returns the first
+// error returned by w, or an error wrapping ErrInvalidKey if key is not a
+// valid key.
+func NewMessageWriter(w io.Writer, key string) io.Writer {
+	return &messageWriter{enc: NewEncoder(w), key: key}
+}
+
+type messageWriter struct {
+	mu  sync.Mutex
+	enc *Encoder
+	key string
+	buf []byte
+}
+
+func (mw *messageWriter) Write(p []byte) (int, error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			break
+		}
+		line := p[:i]
+		if len(mw.buf) > 0 {
+			mw.buf = append(mw.buf, line...)
+			line = mw.buf
+		}
+		p = p[i+1:]
+		err := mw.writeLine(bytes.TrimSuffix(line, []byte("\r")))
+		mw.buf = mw.buf[:0]
+		if err != nil {
+			return n - len(p), err
+		}
+	}
+	mw.buf = append(mw.buf, p...)
+	return n, nil
+}
+
+// Close writes any buffered partial line as a record.
+func (mw *messageWriter) Close() error {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if len(mw.buf) == 0 {
+		return nil
+	}
+	err := mw.writeLine(mw.buf)
+	mw.buf = mw.buf[:0]
+	return err
+}
+
+func (mw *messageWriter) writeLine(line []byte) error {
+	if err := mw.enc.EncodeKeyval(mw.key, string(line)); err != nil {
+		mw.enc.Reset()
+		return err
+	}
+	return mw.enc.EndRecord()
+}
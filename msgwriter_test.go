@@ -0,0 +1,57 @@
+package logfmt_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestMessageWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := logfmt.NewMessageWriter(&buf, "msg")
+
+	writes := []string{"plain\n", "two words\nsay \"hi\"\r\n\npart", "ial line\nnull\n", "unterminated"}
+	for _, s := range writes {
+		n, err := io.WriteString(w, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(s) {
+			t.Errorf("wrote %d bytes, want %d", n, len(s))
+		}
+	}
+	want := "msg=plain\nmsg=\"two words\"\nmsg=\"say \\\"hi\\\"\"\nmsg=\nmsg=\"partial line\"\nmsg=\"null\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	want += "msg=unterminated\n"
+	if got := buf.String(); got != want {
+		t.Errorf("after Close: got %q, want %q", got, want)
+	}
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("after second Close: got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	logger := log.New(logfmt.NewMessageWriter(&buf, "line"), "", 0)
+	logger.Printf("started in %d ms", 12)
+	if got, want := buf.String(), "line=\"started in 12 ms\"\n"; got != want {
+		t.Errorf("log.Logger: got %q, want %q", got, want)
+	}
+
+	w = logfmt.NewMessageWriter(&buf, "")
+	if _, err := io.WriteString(w, "x\n"); !errors.Is(err, logfmt.ErrInvalidKey) {
+		t.Errorf("invalid key: got error %v, want %v", err, logfmt.ErrInvalidKey)
+	}
+}
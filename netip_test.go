@@ -0,0 +1,32 @@
+//go:build go1.18
+
+package logfmt_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestEncodeNetipValues(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{value: netip.MustParseAddr("192.168.1.10"), want: "k=192.168.1.10"},
+		{value: netip.MustParseAddr("fe80::1%eth0"), want: "k=fe80::1%eth0"},
+		{value: netip.MustParseAddrPort("[::1]:8080"), want: "k=[::1]:8080"},
+		{value: netip.MustParsePrefix("10.0.0.0/8"), want: "k=10.0.0.0/8"},
+		{value: netip.Addr{}, want: "k="},
+	}
+	for _, test := range tests {
+		got, err := logfmt.MarshalKeyvals("k", test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%#v: got %s, want %s", test.value, got, test.want)
+		}
+	}
+}
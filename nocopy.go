@@ -0,0 +1,31 @@
+//go:build go1.20
+
+package logfmt
+
+import "unsafe"
+
+// KeyStringNoCopy returns the most recent key found by a call to ScanKeyval
+// as a string that shares memory with the Decoder's buffer, without
+// allocating.
+//
+// The string is only valid until the next call to ScanKeyval, ScanRecord,
+// Release, or Reset, after which its contents may change. It must not be
+// retained, for example as a map key, beyond that point; use KeyString for a
+// string that may be kept.
+func (dec *Decoder) KeyStringNoCopy() string {
+	return unsafeString(dec.key)
+}
+
+// ValueStringNoCopy returns the most recent value found by a call to
+// ScanKeyval as a string that shares memory with the Decoder's buffer,
+// without allocating. A missing value is returned as the empty string.
+//
+// The string is subject to the same restrictions as the one returned by
+// KeyStringNoCopy; use ValueString for a string that may be kept.
+func (dec *Decoder) ValueStringNoCopy() string {
+	return unsafeString(dec.value)
+}
+
+func unsafeString(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
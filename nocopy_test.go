@@ -0,0 +1,84 @@
+//go:build go1.20
+
+package logfmt
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoder_StringNoCopy(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1 b=\"x\\ty\" c\nd= e=5\n"))
+	var got []string
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			got = append(got, dec.KeyStringNoCopy()+"="+dec.ValueStringNoCopy())
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a=1", "b=x\ty", "c=", "d=", "e=5"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecoder_StringNoCopyAllocs(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("abc=def"))
+	dec.ScanRecord()
+	dec.ScanKeyval()
+	allocs := testing.AllocsPerRun(100, func() {
+		if dec.KeyStringNoCopy() != "abc" || dec.ValueStringNoCopy() != "def" {
+			t.Fatal("wrong key or value")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs, want 0", allocs)
+	}
+}
+
+func BenchmarkDecodeKeyvalString(b *testing.B) {
+	const rows = 10000
+	data := []byte{}
+	for i := 0; i < rows; i++ {
+		data = append(data, "a=1 b=\"bar\" ƒ=2h3s r=\"esc\\tmore stuff\" d x=sf   \n"...)
+	}
+
+	benchmarks := []struct {
+		name       string
+		key, value func(*Decoder) string
+	}{
+		{
+			name:  "Copy",
+			key:   func(dec *Decoder) string { return string(dec.Key()) },
+			value: func(dec *Decoder) string { return string(dec.Value()) },
+		},
+		{
+			name:  "NoCopy",
+			key:   (*Decoder).KeyStringNoCopy,
+			value: (*Decoder).ValueStringNoCopy,
+		},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			var n int
+			for i := 0; i < b.N; i++ {
+				dec := NewDecoder(bytes.NewReader(data))
+				for dec.ScanRecord() {
+					for dec.ScanKeyval() {
+						n += len(bm.key(dec)) + len(bm.value(dec))
+					}
+				}
+				if err := dec.Err(); err != nil {
+					b.Errorf("got %v, want %v", err, nil)
+				}
+			}
+			_ = n
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package logfmt
+
+import (
+	"fmt"
+	"io"
+)
+
+// An EncoderOption configures an Encoder created by NewEncoderWith.
+type EncoderOption func(enc *Encoder)
+
+// NewEncoderWith returns a new encoder that writes to w, configured by opts
+// in order. Configuring an encoder when it is created, rather than with its
+// setter methods, ensures the configuration is complete before the encoder
+// is shared.
+func NewEncoderWith(w io.Writer, opts ...EncoderOption) *Encoder {
+	enc := NewEncoder(w)
+	for _, opt := range opts {
+		opt(enc)
+	}
+	return enc
+}
+
+// WithSortKeys returns an option that sets the encoder's SortKeys field.
+func WithSortKeys() EncoderOption {
+	return func(enc *Encoder) {
+		enc.SortKeys = true
+	}
+}
+
+// WithNilValue returns an option that sets the token written for nil values,
+// as by SetNilValue. Unlike SetNilValue, it panics if token would require
+// quoting, which is a programming error as the token is usually constant.
+func WithNilValue(token string) EncoderOption {
+	return func(enc *Encoder) {
+		if err := enc.SetNilValue(token); err != nil {
+			panic(fmt.Sprintf("logfmt: WithNilValue(%q): %v", token, err))
+		}
+	}
+}
+
+// WithTimeFormat returns an option that sets the layout used to format
+// time.Time values, as by SetTimeFormat.
+func WithTimeFormat(layout string) EncoderOption {
+	return func(enc *Encoder) {
+		enc.SetTimeFormat(layout)
+	}
+}
+
+// WithFloatFormat returns an option that sets the format and precision used
+// to format floating point values, as by SetFloatFormat.
+func WithFloatFormat(fmtByte byte, prec int) EncoderOption {
+	return func(enc *Encoder) {
+		enc.SetFloatFormat(fmtByte, prec)
+	}
+}
+
+// WithKeyPrefix returns an option that sets the prefix prepended to each
+// key, as by SetKeyPrefix.
+func WithKeyPrefix(prefix string) EncoderOption {
+	return func(enc *Encoder) {
+		enc.SetKeyPrefix(prefix)
+	}
+}
+
+// WithKeySanitizer returns an option that sets a function that rewrites
+// keys, as by SetKeySanitizer.
+func WithKeySanitizer(fn func(string) string) EncoderOption {
+	return func(enc *Encoder) {
+		enc.SetKeySanitizer(fn)
+	}
+}
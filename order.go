@@ -0,0 +1,87 @@
+package logfmt
+
+import (
+	"bytes"
+	"sort"
+)
+
+// buffered reports whether enc buffers the pairs of each record until
+// EndRecord in order to reorder or align them.
+func (enc *Encoder) buffered() bool {
+	return enc.SchemaOrder != nil || enc.SortKeys || enc.align
+}
+
+// writePending writes the buffered pairs of the current record, in order, to
+// the stream and discards them.
+func (enc *Encoder) writePending() error {
+	defer enc.pending.reset()
+	if len(enc.pending.pairs) == 0 {
+		return nil
+	}
+
+	order, err := enc.orderPending()
+	if err != nil {
+		return err
+	}
+
+	width := 0
+	if enc.align {
+		width = enc.keyWidth()
+	}
+
+	enc.scratch.Reset()
+	if enc.SequenceKey != "" {
+		if err := enc.writeSequence(&enc.scratch); err != nil {
+			return err
+		}
+	}
+	for i, p := range order {
+		if i > 0 {
+			enc.scratch.Write(enc.separator())
+		}
+		p := enc.pending.pairs[p]
+		enc.scratch.Write(enc.pending.key(p))
+		enc.writePadding(enc.pending.key(p), width)
+		enc.scratch.Write(equals)
+		enc.scratch.Write(enc.pending.value(p))
+	}
+	_, err = enc.w.Write(enc.scratch.Bytes())
+	return err
+}
+
+// orderPending returns the indexes of enc.pending.pairs in the order they
+// are to be written, adding pairs for missing keys if required.
+func (enc *Encoder) orderPending() ([]int, error) {
+	n := len(enc.pending.pairs)
+	order := make([]int, 0, n+len(enc.SchemaOrder))
+	used := make([]bool, n)
+	for _, key := range enc.SchemaOrder {
+		found := false
+		for i := 0; i < n; i++ {
+			if !used[i] && string(enc.pending.key(enc.pending.pairs[i])) == key {
+				order = append(order, i)
+				used[i], found = true, true
+			}
+		}
+		if !found && enc.FillMissing {
+			// The schema key is already encoded, with any key prefix.
+			if err := enc.appendValuePairs(&enc.pending, key, nil, nil); err != nil {
+				return nil, err
+			}
+			order = append(order, len(enc.pending.pairs)-1)
+		}
+	}
+	rest := len(order)
+	for i := 0; i < n; i++ {
+		if !used[i] {
+			order = append(order, i)
+		}
+	}
+	if enc.SortKeys {
+		extra := order[rest:]
+		sort.SliceStable(extra, func(i, j int) bool {
+			return bytes.Compare(enc.pending.key(enc.pending.pairs[extra[i]]), enc.pending.key(enc.pending.pairs[extra[j]])) < 0
+		})
+	}
+	return order, nil
+}
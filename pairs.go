@@ -0,0 +1,402 @@
+package logfmt
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A pairList holds a sequence of encoded key/value pairs. It implements
+// io.Writer so that keys and values can be written directly into it.
+type pairList struct {
+	buf   []byte
+	pairs []encodedPair
+}
+
+// An encodedPair locates an encoded key and value within pairList.buf. The
+// value follows the key without a separator.
+type encodedPair struct {
+	start, keyEnd, end int
+}
+
+func (pl *pairList) Write(p []byte) (int, error) {
+	pl.buf = append(pl.buf, p...)
+	return len(p), nil
+}
+
+func (pl *pairList) WriteString(s string) (int, error) {
+	pl.buf = append(pl.buf, s...)
+	return len(s), nil
+}
+
+func (pl *pairList) reset() {
+	pl.buf = pl.buf[:0]
+	pl.pairs = pl.pairs[:0]
+}
+
+func (pl *pairList) key(p encodedPair) []byte {
+	return pl.buf[p.start:p.keyEnd]
+}
+
+func (pl *pairList) value(p encodedPair) []byte {
+	return pl.buf[p.keyEnd:p.end]
+}
+
+func (pl *pairList) add(key, value []byte) {
+	start := len(pl.buf)
+	pl.buf = append(pl.buf, key...)
+	keyEnd := len(pl.buf)
+	pl.buf = append(pl.buf, value...)
+	pl.pairs = append(pl.pairs, encodedPair{start: start, keyEnd: keyEnd, end: len(pl.buf)})
+}
+
+// appendPairs appends the encoding of key and value to pl. Values that are
+// flattened produce more than one pair, or none. If an error is returned pl
+// is unchanged.
+func (enc *Encoder) appendPairs(pl *pairList, key, value interface{}) error {
+	start, n := len(pl.buf), len(pl.pairs)
+	pl.buf = append(pl.buf, enc.keyPrefix...)
+	err := writeKey(pl, enc.sanitizeKey(key))
+	if err == nil && enc.badPrefix {
+		err = ErrInvalidKey
+	}
+	if err == nil && enc.valueFmt != nil {
+		value = enc.valueFmt(string(pl.buf[start:]), value)
+	}
+	if err == nil {
+		if rv, ok := enc.flattenable(value); ok {
+			prefix := string(pl.buf[start:])
+			pl.buf = pl.buf[:start]
+			err = enc.appendFlattened(pl, prefix, value, rv, &flattenState{})
+		} else {
+			err = enc.appendValue(pl, start, value)
+		}
+	}
+	if err != nil {
+		pl.buf, pl.pairs = pl.buf[:start], pl.pairs[:n]
+		return err
+	}
+	return nil
+}
+
+func (enc *Encoder) appendValuePairs(pl *pairList, key string, value interface{}, fs *flattenState) error {
+	if rv, ok := enc.flattenable(value); ok {
+		return enc.appendFlattened(pl, key, value, rv, fs)
+	}
+	start := len(pl.buf)
+	pl.buf = append(pl.buf, key...)
+	return enc.appendValue(pl, start, value)
+}
+
+// appendValue completes the pair whose key starts at pl.buf[start] by
+// appending the encoding of value.
+func (enc *Encoder) appendValue(pl *pairList, start int, value interface{}) error {
+	keyEnd := len(pl.buf)
+	if enc.quoteKeys != nil && enc.quoteKeys[string(pl.buf[start:keyEnd])] {
+		enc.forceQuote = true
+		defer func() { enc.forceQuote = false }()
+	}
+	if err := enc.writeValue(pl, value); err != nil {
+		return err
+	}
+	pl.pairs = append(pl.pairs, encodedPair{start: start, keyEnd: keyEnd, end: len(pl.buf)})
+	return nil
+}
+
+// A flattenState tracks the values being flattened by a single call to
+// appendPairs, to limit the depth of nesting and detect cycles.
+type flattenState struct {
+	depth int
+	path  map[flattenRef]bool
+}
+
+// A flattenRef identifies a pointer, map, or slice that may refer back to
+// itself. Slices sharing an array are told apart by their length, as a
+// shorter slice of an array may be an element of a longer one without a
+// cycle.
+type flattenRef struct {
+	p uintptr
+	t reflect.Type
+	n int
+}
+
+// appendFlattened appends one pair per element, field, or entry of rv, which
+// must have been returned by flattenable for value. A value nested deeper
+// than the maximum depth is appended as a single pair with the value
+// formatted by fmt.Sprint and quoted instead. ErrCyclicValue is returned if
+// value contains itself, or if formatting it would not terminate.
+func (enc *Encoder) appendFlattened(pl *pairList, key string, value interface{}, rv reflect.Value, fs *flattenState) error {
+	if enc.maxDepth > 0 && fs.depth >= enc.maxDepth {
+		if sprintCyclic(reflect.ValueOf(value), 0, map[flattenRef]bool{}) {
+			return ErrCyclicValue
+		}
+		// Always quote the formatted value, so that it cannot be mistaken
+		// for a scalar.
+		start := len(pl.buf)
+		pl.buf = append(pl.buf, key...)
+		enc.forceQuote = true
+		defer func() { enc.forceQuote = false }()
+		return enc.appendValue(pl, start, fmt.Sprint(value))
+	}
+	if ref, ok := refOf(value); ok {
+		if fs.path[ref] {
+			return ErrCyclicValue
+		}
+		if fs.path == nil {
+			fs.path = map[flattenRef]bool{}
+		}
+		fs.path[ref] = true
+		defer delete(fs.path, ref)
+	}
+	fs.depth++
+	defer func() { fs.depth-- }()
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		return enc.appendStructPairs(pl, key, rv, fs)
+	case reflect.Map:
+		return enc.appendMapPairs(pl, key, rv, fs)
+	}
+	for i := 0; i < rv.Len(); i++ {
+		elemKey := key + "[" + strconv.Itoa(i) + "]"
+		if err := enc.appendValuePairs(pl, elemKey, rv.Index(i).Interface(), fs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refOf returns a reference to value if it is a non-nil pointer or map, or a
+// non-empty slice, through which it may contain itself.
+func refOf(value interface{}) (flattenRef, bool) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map:
+		if rv.IsNil() {
+			return flattenRef{}, false
+		}
+	case reflect.Slice:
+		if rv.Len() == 0 {
+			return flattenRef{}, false
+		}
+		return flattenRef{p: rv.Pointer(), t: rv.Type(), n: rv.Len()}, true
+	default:
+		return flattenRef{}, false
+	}
+	return flattenRef{p: rv.Pointer(), t: rv.Type()}, true
+}
+
+// sprintCyclic reports whether fmt.Sprint would recurse forever formatting
+// v, which happens when a map or slice contains itself. It follows v the
+// way fmt does: pointers only at the top level, and not into values that
+// format themselves.
+func sprintCyclic(v reflect.Value, depth int, seen map[flattenRef]bool) bool {
+	if !v.IsValid() {
+		return false
+	}
+	if v.CanInterface() {
+		switch v.Interface().(type) {
+		case fmt.Formatter, fmt.Stringer, fmt.GoStringer, error:
+			return false
+		}
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		return sprintCyclic(v.Elem(), depth+1, seen)
+	case reflect.Ptr:
+		if depth > 0 || v.IsNil() {
+			return false
+		}
+		return sprintCyclic(v.Elem(), depth+1, seen)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if sprintCyclic(v.Index(i), depth+1, seen) {
+				return true
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if sprintCyclic(v.Field(i), depth+1, seen) {
+				return true
+			}
+		}
+	case reflect.Map, reflect.Slice:
+		if v.Len() == 0 {
+			return false
+		}
+		ref := flattenRef{p: v.Pointer(), t: v.Type()}
+		if v.Kind() == reflect.Slice {
+			ref.n = v.Len()
+		}
+		if seen[ref] {
+			return true
+		}
+		seen[ref] = true
+		defer delete(seen, ref)
+		if v.Kind() == reflect.Slice {
+			for i := 0; i < v.Len(); i++ {
+				if sprintCyclic(v.Index(i), depth+1, seen) {
+					return true
+				}
+			}
+			return false
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if sprintCyclic(iter.Key(), depth+1, seen) || sprintCyclic(iter.Value(), depth+1, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (enc *Encoder) appendStructPairs(pl *pairList, key string, rv reflect.Value, fs *flattenState) error {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Tag.Get("logfmt")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.IndexFunc(name, isInvalidKeyRune) >= 0 {
+			return ErrInvalidKey
+		}
+		if err := enc.appendValuePairs(pl, key+"."+name, rv.Field(i).Interface(), fs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) appendMapPairs(pl *pairList, key string, rv reflect.Value, fs *flattenState) error {
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, rv.Len())
+	var buf bytes.Buffer
+	iter := rv.MapRange()
+	for iter.Next() {
+		buf.Reset()
+		if err := encodeKey(&buf, iter.Key().Interface(), true); err != nil {
+			return err
+		}
+		entries = append(entries, entry{key: buf.String(), value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	for _, e := range entries {
+		if err := enc.appendValuePairs(pl, key+"."+e.key, e.value.Interface(), fs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isInvalidKeyRune(r rune) bool {
+	return keyRuneFilter(r) < 0
+}
+
+// flattenable reports whether value is a collection that is encoded as one
+// pair per element, struct field, or map entry, and if so returns it with any
+// pointers dereferenced. Values with their own encoding, such as
+// TextMarshalers, json.Marshalers, or values with a registered formatter, are
+// not flattened, nor are byte slices, nil slices and maps, or empty slices
+// when EmptySliceMarker is set.
+func (enc *Encoder) flattenable(value interface{}) (reflect.Value, bool) {
+	switch value.(type) {
+	case nil, []byte, time.Time, url.URL, goString, nested, encoding.TextMarshaler, error, fmt.Stringer, json.Marshaler:
+		return reflect.Value{}, false
+	}
+	if _, ok := enc.formatters[reflect.TypeOf(value)]; ok {
+		return reflect.Value{}, false
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return reflect.Value{}, false
+		}
+		return enc.flattenable(rv.Elem().Interface())
+	case reflect.Array, reflect.Struct:
+		return rv, true
+	case reflect.Map:
+		return rv, !rv.IsNil()
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 || rv.IsNil() {
+			return reflect.Value{}, false
+		}
+		if rv.Len() == 0 && enc.EmptySliceMarker != "" {
+			return reflect.Value{}, false
+		}
+		return rv, true
+	}
+	return reflect.Value{}, false
+}
+
+// writePairs writes the pairs in pl to the current record.
+func (enc *Encoder) writePairs(pl *pairList) error {
+	if len(pl.pairs) == 0 {
+		return nil
+	}
+	if enc.buffered() {
+		for _, p := range pl.pairs {
+			if enc.filtered(pl.key(p)) {
+				continue
+			}
+			enc.pending.add(pl.key(p), pl.value(p))
+			enc.needSep = true
+		}
+		return nil
+	}
+
+	enc.scratch.Reset()
+	for _, p := range pl.pairs {
+		if enc.filtered(pl.key(p)) {
+			continue
+		}
+		if enc.needSep {
+			enc.scratch.Write(enc.separator())
+		} else if enc.SequenceKey != "" {
+			if err := enc.writeSequence(&enc.scratch); err != nil {
+				return err
+			}
+		}
+		enc.scratch.Write(pl.key(p))
+		enc.scratch.Write(equals)
+		enc.scratch.Write(pl.value(p))
+		enc.needSep = true
+	}
+	if enc.scratch.Len() == 0 {
+		return nil
+	}
+	_, err := enc.w.Write(enc.scratch.Bytes())
+	return err
+}
+
+// filtered reports whether the pair with key is removed by the key filter.
+func (enc *Encoder) filtered(key []byte) bool {
+	return enc.keyFilter != nil && !enc.keyFilter(string(key))
+}
+
+// isValueError reports whether err, returned while encoding a value, is
+// replaced by its error message by EncodeKeyvals.
+func isValueError(err error) bool {
+	_, ok := err.(*MarshalerError)
+	return ok || err == ErrUnsupportedValueType || err == ErrCRInValue || err == ErrNonFiniteFloat || err == ErrCyclicValue
+}
@@ -0,0 +1,21 @@
+package logfmt
+
+import "bytes"
+
+// Quote returns b as a quoted logfmt value, enclosed in double quotes with
+// quotes, backslashes, and control characters escaped as they are by an
+// Encoder. The result is always quoted, even if b does not require it.
+func Quote(b []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(b) + 2)
+	writeQuotedBytes(&buf, b, nil)
+	return buf.Bytes()
+}
+
+// Unquote interprets b, including its surrounding double quotes, as a quoted
+// logfmt value and returns the value it represents, as it would be returned
+// by Decoder.Value. It reports false if b is not a valid quoted value. The
+// returned slice may share memory with b if b contains no escape sequences.
+func Unquote(b []byte) ([]byte, bool) {
+	return unquoteBytes(b, '"', false)
+}
@@ -0,0 +1,62 @@
+package logfmt_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "", want: `""`},
+		{in: "abc", want: `"abc"`},
+		{in: `say "hi"\`, want: `"say \"hi\"\\"`},
+		{in: "a\tb\nc\x00", want: `"a\tb\nc\u0000"`},
+		{in: "héllo  ", want: `"héllo  "`},
+		{in: "\xff", want: `"\ufffd"`},
+	}
+	for _, test := range tests {
+		got := logfmt.Quote([]byte(test.in))
+		if string(got) != test.want {
+			t.Errorf("Quote(%q): got %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{in: `""`, want: "", ok: true},
+		{in: `"abc"`, want: "abc", ok: true},
+		{in: `"say \"hi\"\\"`, want: `say "hi"\`, ok: true},
+		{in: `"a\tbé😀"`, want: "a\tbé😀", ok: true},
+		{in: `"don\'t"`, want: "don't", ok: true},
+		{in: `abc`},
+		{in: `"abc`},
+		{in: `"`},
+		{in: `"a"b"`},
+		{in: `"\q"`},
+		{in: "\"a\nb\""},
+	}
+	for _, test := range tests {
+		got, ok := logfmt.Unquote([]byte(test.in))
+		if ok != test.ok || ok && string(got) != test.want {
+			t.Errorf("Unquote(%s): got %q, %v, want %q, %v", test.in, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestQuoteUnquote(t *testing.T) {
+	for _, s := range []string{"", "plain", "a=b \"c\" \\d", "\x01\x7fé\n\r\t"} {
+		got, ok := logfmt.Unquote(logfmt.Quote([]byte(s)))
+		if !ok || !bytes.Equal(got, []byte(s)) {
+			t.Errorf("%q: round trip got %q, %v", s, got, ok)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package logfmt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// A KeyValue is a key/value pair of a decoded record. Value is nil for a key
+// without a value.
+type KeyValue struct {
+	Key, Value []byte
+}
+
+// DecodeBytes decodes all of the records in data, which holds complete
+// lines of logfmt as read by a Decoder, and returns the key/value pairs of
+// each. An empty line produces an empty record. Unlike a Decoder it does not
+// limit the length of a record.
+//
+// The returned keys and values alias data; they are not copied. The only
+// exceptions are quoted values containing escape sequences, which are
+// decoded into newly allocated slices.
+//
+// If a record contains a syntax error DecodeBytes returns the records that
+// precede it and a *SyntaxError.
+func DecodeBytes(data []byte) ([][]KeyValue, error) {
+	var (
+		dec  Decoder
+		recs [][]KeyValue
+	)
+	for len(data) > 0 {
+		var line []byte
+		line, data = nextLine(data)
+		dec.startRecord(line)
+		var rec []KeyValue
+		for dec.scanKeyval() {
+			rec = append(rec, KeyValue{Key: dec.key, Value: dec.value})
+		}
+		if dec.err != nil {
+			return recs, dec.err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// DecodeRecord decodes the first record in data, which ends at the first
+// newline or, if there is none, at the end of data, and returns its key/value
+// pairs and the remainder of data following the newline. It supports
+// decoding records one at a time from data that holds other content between
+// them. If data does not contain a newline the whole of data is the record
+// and rest is empty, and if data begins with a newline the record is empty.
+// A carriage return preceding the newline is removed.
+//
+// As with DecodeBytes, the returned keys and values alias data unless they
+// contain escape sequences. If the record contains a syntax error
+// DecodeRecord returns the pairs that precede it, rest, and a *SyntaxError,
+// so that decoding may continue with the next record.
+func DecodeRecord(data []byte) (kvs []KeyValue, rest []byte, err error) {
+	var (
+		dec  Decoder
+		line []byte
+	)
+	line, rest = nextLine(data)
+	dec.startRecord(line)
+	for dec.scanKeyval() {
+		kvs = append(kvs, KeyValue{Key: dec.key, Value: dec.value})
+	}
+	return kvs, rest, dec.err
+}
+
+// Compact returns the logfmt records in data in canonical form, for
+// comparison and deduplication. Each record is decoded, as by DecodeBytes,
+// and encoded again by an Encoder, which separates pairs by a single space,
+// quotes only the values that require it, and ends each record with a
+// newline. Keys without a value are written with an empty value, as in k=.
+// An empty or blank line produces an empty record. If data contains a syntax
+// error Compact returns the *SyntaxError.
+func Compact(data []byte) ([]byte, error) {
+	recs, err := DecodeBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetEmitEmptyRecords(true)
+	for _, rec := range recs {
+		for _, kv := range rec {
+			if err := enc.EncodeKeyval(kv.Key, emptyIfNil(kv.Value)); err != nil {
+				return nil, err
+			}
+		}
+		if err := enc.EndRecord(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// RoundTrip decodes the logfmt records in data and encodes them again,
+// ending each with EndRecord, and returns the result, which is the canonical
+// form of data described by Compact. The canonical form is stable: for any
+// data that RoundTrip accepts, RoundTrip(RoundTrip(data)) returns the same
+// bytes as RoundTrip(data), so applications can use it to check that their
+// own data survives encoding and decoding unchanged. If data contains a
+// syntax error RoundTrip returns the *SyntaxError.
+func RoundTrip(data []byte) ([]byte, error) {
+	return Compact(data)
+}
+
+// nextLine splits the first line from data, removing its line ending.
+func nextLine(data []byte) (line, rest []byte) {
+	line = data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line, rest = data[:i], data[i+1:]
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, rest
+}
+
+// Valid reports whether data holds only valid logfmt records, as accepted by
+// DecodeBytes. Empty input is valid. Nothing is allocated for the decoded
+// pairs.
+func Valid(data []byte) bool {
+	var dec Decoder
+	for len(data) > 0 {
+		var line []byte
+		line, data = nextLine(data)
+		dec.startRecord(line)
+		for dec.scanKeyval() {
+		}
+		if dec.err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidReader decodes all of the records read from r with a Decoder, without
+// retaining them, and returns the first error encountered, which is a
+// *SyntaxError if the input is not valid logfmt. It returns nil if the input
+// is valid, including when it is empty.
+func ValidReader(r io.Reader) error {
+	dec := NewDecoder(r)
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+		}
+	}
+	return dec.Err()
+}
+
+// DecodeMap consumes the remaining key/value pairs of the current record,
+// after a call to ScanRecord returns true, and returns them as a map. If a
+// key is repeated the last value wins. Keys without a value map to the empty
+// string. Unlike Key and Value, the keys and values are copied into strings
+// that remain valid after the next call to ScanRecord.
+func (dec *Decoder) DecodeMap() (map[string]string, error) {
+	m := map[string]string{}
+	for dec.ScanKeyval() {
+		m[dec.string(dec.key)] = dec.string(dec.value)
+	}
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	return m, nil
+}
+
+// DecodeReaderToMaps decodes all of the records read from r with a Decoder
+// and returns one map per record, as by DecodeMap. An empty record produces
+// an empty, non-nil map. The maps hold copies of the keys and values. If an
+// error is encountered, such as a *SyntaxError or an error from r,
+// DecodeReaderToMaps returns the maps of the records that precede it and the
+// error.
+func DecodeReaderToMaps(r io.Reader) ([]map[string]string, error) {
+	var maps []map[string]string
+	dec := NewDecoder(r)
+	for dec.ScanRecord() {
+		m, err := dec.DecodeMap()
+		if err != nil {
+			return maps, err
+		}
+		maps = append(maps, m)
+	}
+	return maps, dec.Err()
+}
+
+// DecodeKeyvalsSlice consumes the remaining key/value pairs of the current
+// record, after a call to ScanRecord returns true, and returns them as
+// alternating keys and values, in order, ready to be passed to
+// MarshalKeyvals after conversion to []interface{}. Keys without a value
+// have the empty string as their value. The keys and values are copied into
+// strings that remain valid after the next call to ScanRecord.
+func (dec *Decoder) DecodeKeyvalsSlice() ([]string, error) {
+	var kvs []string
+	for dec.ScanKeyval() {
+		kvs = append(kvs, dec.string(dec.key), dec.string(dec.value))
+	}
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	return kvs, nil
+}
+
+// AppendKeyvals consumes the remaining key/value pairs of the current record,
+// after a call to ScanRecord returns true, appends them to dst in order, and
+// returns the extended slice. Value is nil for a key without a value. Unlike
+// Key and Value, the keys and values are copies that remain valid after the
+// next call to ScanRecord.
+//
+// The copies are made into the storage of the Key and Value slices of the
+// elements of dst beyond its length, where available, so that a slice reused
+// for each record, as in
+//
+//	kvs, err = dec.AppendKeyvals(kvs[:0])
+//
+// stops allocating once it has grown to fit the records. The keys and values
+// of a reused slice are overwritten by the call that reuses it, and must be
+// copied first if they are to be retained.
+//
+// If a syntax error occurs AppendKeyvals returns dst extended with the pairs
+// that precede the error, and the error.
+func (dec *Decoder) AppendKeyvals(dst []KeyValue) ([]KeyValue, error) {
+	for dec.ScanKeyval() {
+		if len(dst) < cap(dst) {
+			dst = dst[:len(dst)+1]
+		} else {
+			dst = append(dst, KeyValue{})
+		}
+		kv := &dst[len(dst)-1]
+		kv.Key = append(kv.Key[:0], dec.key...)
+		if dec.value == nil {
+			kv.Value = nil
+		} else {
+			kv.Value = append(kv.Value[:0], dec.value...)
+		}
+	}
+	return dst, dec.err
+}
+
+// UnmarshalMap decodes data, which must hold a single logfmt record, into a
+// map as by Decoder.DecodeMap. A trailing newline is permitted.
+// ErrMultipleRecords is returned if data holds more than one record.
+func UnmarshalMap(data []byte) (map[string]string, error) {
+	line, err := singleRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	var dec Decoder
+	dec.startRecord(line)
+	return dec.DecodeMap()
+}
+
+// singleRecord returns the line of data, which must hold a single record
+// optionally followed by line endings.
+func singleRecord(data []byte) ([]byte, error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		if len(bytes.TrimRight(data[i:], "\r\n")) > 0 {
+			return nil, ErrMultipleRecords
+		}
+		data = data[:i]
+	}
+	return bytes.TrimSuffix(data, []byte("\r")), nil
+}
+
+// ErrMultipleRecords is returned by functions that decode a single record if
+// their input holds more than one.
+var ErrMultipleRecords = errors.New("multiple records")
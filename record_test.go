@@ -0,0 +1,412 @@
+package logfmt_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestDecodeBytes(t *testing.T) {
+	data := []byte("a=1 b=\"x y\" c\r\n\nd=\"e\\\"f\" g=\n")
+	got, err := logfmt.DecodeBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]logfmt.KeyValue{
+		{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("x y")}, {Key: []byte("c")}},
+		nil,
+		{{Key: []byte("d"), Value: []byte(`e"f`)}, {Key: []byte("g")}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	data[2] = '9'
+	if v := got[0][0].Value; string(v) != "9" {
+		t.Errorf("value does not alias data: got %q", v)
+	}
+}
+
+func TestDecodeBytesMatchesDecoder(t *testing.T) {
+	inputs := []string{
+		"",
+		"\n",
+		"a=1",
+		"a=1\nb=2\n",
+		"  x  y=\"\"  z=3 \n\n\n",
+		"ƒ=2h3s r=\"esc\\tmore stuff\" d x=sf",
+	}
+	for _, in := range inputs {
+		var want [][]logfmt.KeyValue
+		dec := logfmt.NewDecoder(strings.NewReader(in))
+		for dec.ScanRecord() {
+			var rec []logfmt.KeyValue
+			for dec.ScanKeyval() {
+				rec = append(rec, logfmt.KeyValue{
+					Key:   append([]byte(nil), dec.Key()...),
+					Value: append([]byte(nil), dec.Value()...),
+				})
+			}
+			want = append(want, rec)
+		}
+		if err := dec.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := logfmt.DecodeBytes([]byte(in))
+		if err != nil {
+			t.Errorf("%q: got error: %v", in, err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("%q: got %d records, want %d", in, len(got), len(want))
+			continue
+		}
+		for i := range got {
+			if len(got[i]) != len(want[i]) {
+				t.Errorf("%q: record %d: got %q, want %q", in, i, got[i], want[i])
+				continue
+			}
+			for j := range got[i] {
+				if !bytes.Equal(got[i][j].Key, want[i][j].Key) || !bytes.Equal(got[i][j].Value, want[i][j].Value) {
+					t.Errorf("%q: record %d: got %q, want %q", in, i, got[i], want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeBytesLongRecord(t *testing.T) {
+	long := strings.Repeat("x", 100000)
+	got, err := logfmt.DecodeBytes([]byte("a=1\nk=" + long + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || string(got[1][0].Value) != long {
+		t.Errorf("long record not decoded")
+	}
+}
+
+func TestDecodeBytesSyntaxError(t *testing.T) {
+	got, err := logfmt.DecodeBytes([]byte("a=1\nb=2 =3\nc=4\n"))
+	want := &logfmt.SyntaxError{Msg: "unexpected '='", Line: 2, Pos: 5, Err: logfmt.ErrUnexpectedEquals}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+	if len(got) != 1 {
+		t.Errorf("got %d records, want 1", len(got))
+	}
+}
+
+func TestDecodeRecord(t *testing.T) {
+	tests := []struct {
+		data string
+		want []logfmt.KeyValue
+		rest string
+		err  error
+	}{
+		{data: "a=1 b=\"x y\"\r\nc=2\n", want: []logfmt.KeyValue{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("x y")}}, rest: "c=2\n"},
+		{data: "a=1 c", want: []logfmt.KeyValue{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("c")}}},
+		{data: "\na=1\n", rest: "a=1\n"},
+		{data: ""},
+		{data: "a=1 b=\"2\n\x00binary", want: []logfmt.KeyValue{{Key: []byte("a"), Value: []byte("1")}}, rest: "\x00binary", err: &logfmt.SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 9, Err: logfmt.ErrUnterminatedQuote}},
+	}
+	for _, test := range tests {
+		kvs, rest, err := logfmt.DecodeRecord([]byte(test.data))
+		if !reflect.DeepEqual(err, test.err) {
+			t.Errorf("%q: got error %v, want %v", test.data, err, test.err)
+		}
+		if !reflect.DeepEqual(kvs, test.want) {
+			t.Errorf("%q: got %q, want %q", test.data, kvs, test.want)
+		}
+		if string(rest) != test.rest {
+			t.Errorf("%q: got rest %q, want %q", test.data, rest, test.rest)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		data  string
+		valid bool
+		err   error
+	}{
+		{data: "", valid: true},
+		{data: "\n\n", valid: true},
+		{data: "a=1 b=\"x y\" c\r\nd=\n", valid: true},
+		{data: "a=1\nb=\"2", err: &logfmt.SyntaxError{Msg: "unterminated quoted value", Line: 2, Pos: 5, Err: logfmt.ErrUnterminatedQuote}},
+		{data: "a=1\n=b\n", err: &logfmt.SyntaxError{Msg: "unexpected '='", Line: 2, Pos: 1, Err: logfmt.ErrUnexpectedEquals}},
+	}
+
+	for _, test := range tests {
+		if got := logfmt.Valid([]byte(test.data)); got != test.valid {
+			t.Errorf("Valid(%q) = %v, want %v", test.data, got, test.valid)
+		}
+		err := logfmt.ValidReader(strings.NewReader(test.data))
+		if !reflect.DeepEqual(err, test.err) {
+			t.Errorf("ValidReader(%q) = %v, want %v", test.data, err, test.err)
+		}
+	}
+}
+
+func TestValidAllocs(t *testing.T) {
+	data := []byte("a=1 b=\"x y\" c\nd=\"e f\" g=\n")
+	allocs := testing.AllocsPerRun(100, func() {
+		if !logfmt.Valid(data) {
+			t.Fatal("not valid")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs, want 0", allocs)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "", want: ""},
+		{in: "\n  \n", want: "\n\n"},
+		{in: "a=1   b=\"bar\"  c  d=\"x y\"   \r\n", want: "a=1 b=bar c= d=\"x y\"\n"},
+		{in: "  a=\"esc\\t\" b=a\\b\nc=\"\"", want: "a=\"esc\\t\" b=\"a\\\\b\"\nc=\n"},
+	}
+	for _, test := range tests {
+		got, err := logfmt.Compact([]byte(test.in))
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+		again, err := logfmt.Compact(got)
+		if err != nil || !bytes.Equal(again, got) {
+			t.Errorf("%q: not idempotent: got %q, %v", test.in, again, err)
+		}
+	}
+
+	_, err := logfmt.Compact([]byte("a=1\nb=\"2"))
+	want := &logfmt.SyntaxError{Msg: "unterminated quoted value", Line: 2, Pos: 5, Err: logfmt.ErrUnterminatedQuote}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
+
+func TestRoundTripIdempotent(t *testing.T) {
+	corpus := []string{
+		"",
+		"\n\n",
+		"a=1 b=2 c=3",
+		"a b c",
+		"a= b= c=",
+		`a="" b="\"" c="\\"`,
+		`a="\\\"" b="\\\\" c="\"\\\""`,
+		`a=\ b=\\ c=x\y`,
+		`msg="tab\there" nl="line\nbreak" cr="\r" nul="\u0000"`,
+		`u="é世😀" e=é x="�"`,
+		"bad=\xff\xfe k=\x7f",
+		`null=null quoted="null" empty=""`,
+		"k=\"a b\" k=\"a b\" k",
+		"ƒ=1 日本=語",
+		"a=1   \t b=2\r\nc=3",
+		`k="=" k2="a=b" k3=a\"b"`,
+	}
+	for _, in := range corpus {
+		once, err := logfmt.RoundTrip([]byte(in))
+		if err != nil {
+			// Invalid input has no canonical form.
+			if logfmt.Valid([]byte(in)) {
+				t.Errorf("%q: valid input rejected: %v", in, err)
+			}
+			continue
+		}
+		twice, err := logfmt.RoundTrip(once)
+		if err != nil {
+			t.Errorf("%q: canonical form %q rejected: %v", in, once, err)
+			continue
+		}
+		if !bytes.Equal(once, twice) {
+			t.Errorf("%q: not idempotent:\n once  %q\n twice %q", in, once, twice)
+		}
+	}
+}
+
+func TestDecoderDecodeMap(t *testing.T) {
+	dec := logfmt.NewDecoder(strings.NewReader("a=1 b=\"x y\" a=2 c\n\nd==\n"))
+	var got []map[string]string
+	for dec.ScanRecord() {
+		m, err := dec.DecodeMap()
+		if err != nil {
+			want := &logfmt.SyntaxError{Msg: "unexpected '='", Line: 3, Pos: 3, Err: logfmt.ErrUnexpectedEquals}
+			if !reflect.DeepEqual(err, want) {
+				t.Errorf("got error %v, want %v", err, want)
+			}
+			break
+		}
+		got = append(got, m)
+	}
+	want := []map[string]string{
+		{"a": "2", "b": "x y", "c": ""},
+		{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDecodeReaderToMaps(t *testing.T) {
+	got, err := logfmt.DecodeReaderToMaps(strings.NewReader("a=1 b=\"x y\" a=2 c\n\nd=\"e\\\"f\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]string{
+		{"a": "2", "b": "x y", "c": ""},
+		{},
+		{"d": `e"f`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got[1] == nil {
+		t.Error("empty record: got nil map")
+	}
+
+	got, err = logfmt.DecodeReaderToMaps(strings.NewReader("a=1\nb=\"2\nc=3\n"))
+	if want := []map[string]string{{"a": "1"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("syntax error: got %q, want %q", got, want)
+	}
+	wantErr := &logfmt.SyntaxError{Msg: "unterminated quoted value", Line: 2, Pos: 5, Err: logfmt.ErrUnterminatedQuote}
+	if !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("got error %v, want %v", err, wantErr)
+	}
+
+	got, err = logfmt.DecodeReaderToMaps(strings.NewReader(""))
+	if got != nil || err != nil {
+		t.Errorf("empty input: got %q, %v", got, err)
+	}
+}
+
+func TestDecoderDecodeKeyvalsSlice(t *testing.T) {
+	dec := logfmt.NewDecoder(strings.NewReader("a=1 b=\"x y\" c a=2\n\nd=\"5"))
+	var got [][]string
+	for dec.ScanRecord() {
+		kvs, err := dec.DecodeKeyvalsSlice()
+		if err != nil {
+			want := &logfmt.SyntaxError{Msg: "unterminated quoted value", Line: 3, Pos: 5, Err: logfmt.ErrUnterminatedQuote}
+			if !reflect.DeepEqual(err, want) {
+				t.Errorf("got error %v, want %v", err, want)
+			}
+			if kvs != nil {
+				t.Errorf("got %q with error", kvs)
+			}
+			continue
+		}
+		got = append(got, kvs)
+	}
+	want := [][]string{{"a", "1", "b", "x y", "c", "", "a", "2"}, nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	keyvals := make([]interface{}, len(got[0]))
+	for i, s := range got[0] {
+		keyvals[i] = s
+	}
+	b, err := logfmt.MarshalKeyvals(keyvals...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `a=1 b="x y" c= a=2`; got != want {
+		t.Errorf("MarshalKeyvals: got %q, want %q", got, want)
+	}
+}
+
+func TestDecoderAppendKeyvals(t *testing.T) {
+	dec := logfmt.NewDecoder(strings.NewReader("a=1 b=\"x y\" c\n\nd=4 e=\"5"))
+	var (
+		kvs []logfmt.KeyValue
+		got [][]logfmt.KeyValue
+		err error
+	)
+	for dec.ScanRecord() {
+		kvs, err = dec.AppendKeyvals(kvs[:0])
+		rec := []logfmt.KeyValue{}
+		for _, kv := range kvs {
+			rec = append(rec, logfmt.KeyValue{Key: cloneBytes(kv.Key), Value: cloneBytes(kv.Value)})
+		}
+		got = append(got, rec)
+		if err != nil {
+			break
+		}
+	}
+	want := &logfmt.SyntaxError{Msg: "unterminated quoted value", Line: 3, Pos: 9, Err: logfmt.ErrUnterminatedQuote}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+	wantKVs := [][]logfmt.KeyValue{
+		{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("x y")}, {Key: []byte("c")}},
+		{},
+		{{Key: []byte("d"), Value: []byte("4")}},
+	}
+	if !reflect.DeepEqual(got, wantKVs) {
+		t.Errorf("got %q, want %q", got, wantKVs)
+	}
+
+	data := strings.Repeat("key=value other=\"quoted value\" flag\n", 10)
+	dec = logfmt.NewDecoder(strings.NewReader(data))
+	if !dec.ScanRecord() {
+		t.Fatal("no record")
+	}
+	kvs, err = dec.AppendKeyvals(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := logfmt.KeyValue{Key: cloneBytes(kvs[0].Key), Value: cloneBytes(kvs[0].Value)}
+	allocs := testing.AllocsPerRun(5, func() {
+		if !dec.ScanRecord() {
+			t.Fatal("no record")
+		}
+		if kvs, err = dec.AppendKeyvals(kvs[:0]); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs, want 0", allocs)
+	}
+	if got, want := kvs[0], first; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func TestUnmarshalMap(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+		err  error
+	}{
+		{in: "", want: map[string]string{}},
+		{in: "a=1 b", want: map[string]string{"a": "1", "b": ""}},
+		{in: "a=1 a=\"2 3\"\r\n", want: map[string]string{"a": "2 3"}},
+		{in: "a=1\n\n", want: map[string]string{"a": "1"}},
+		{in: "a=1\nb=2", err: logfmt.ErrMultipleRecords},
+		{in: "a=\"1", err: &logfmt.SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 5, Err: logfmt.ErrUnterminatedQuote}},
+	}
+	for _, test := range tests {
+		got, err := logfmt.UnmarshalMap([]byte(test.in))
+		if !reflect.DeepEqual(err, test.err) {
+			t.Errorf("%q: got error %v, want %v", test.in, err, test.err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.in, got, test.want)
+		}
+	}
+}
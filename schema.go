@@ -0,0 +1,77 @@
+package logfmt
+
+import "sort"
+
+// A SchemaTracker records the sets of keys seen across a stream of logfmt
+// records. It reports keys that appear in some but not all of the observed
+// records, which helps identify inconsistent logging within a service.
+//
+// The zero value is ready to use.
+type SchemaTracker struct {
+	records int
+	counts  map[string]int
+	seen    map[string]bool
+}
+
+// Observe scans the remaining key/value pairs of the current record of dec
+// and records the set of keys found. It must be called after a successful
+// call to dec.ScanRecord. Callers should check dec.Err after Observe
+// returns; a record that ends in an error is not counted.
+func (t *SchemaTracker) Observe(dec *Decoder) {
+	if t.seen == nil {
+		t.seen = map[string]bool{}
+	} else {
+		for k := range t.seen {
+			delete(t.seen, k)
+		}
+	}
+	for dec.ScanKeyval() {
+		if k := dec.Key(); k != nil {
+			t.seen[string(k)] = true
+		}
+	}
+	if dec.Err() != nil {
+		return
+	}
+	if t.counts == nil {
+		t.counts = map[string]int{}
+	}
+	for k := range t.seen {
+		t.counts[k]++
+	}
+	t.records++
+}
+
+// Records returns the number of records observed.
+func (t *SchemaTracker) Records() int {
+	return t.records
+}
+
+// Union returns the sorted set of keys that appeared in at least one
+// observed record.
+func (t *SchemaTracker) Union() []string {
+	return t.keys(func(n int) bool { return n > 0 })
+}
+
+// Intersection returns the sorted set of keys that appeared in every
+// observed record.
+func (t *SchemaTracker) Intersection() []string {
+	return t.keys(func(n int) bool { return n == t.records })
+}
+
+// Inconsistent returns the sorted set of keys that appeared in some but not
+// all observed records.
+func (t *SchemaTracker) Inconsistent() []string {
+	return t.keys(func(n int) bool { return n < t.records })
+}
+
+func (t *SchemaTracker) keys(match func(n int) bool) []string {
+	var keys []string
+	for k, n := range t.counts {
+		if match(n) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
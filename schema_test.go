@@ -0,0 +1,43 @@
+package logfmt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaTracker(t *testing.T) {
+	in := "a=1 b=2 user_id=7\na=3 b=4\nb=5 a=6 c=x a=8\n"
+
+	var st SchemaTracker
+	dec := NewDecoder(strings.NewReader(in))
+	for dec.ScanRecord() {
+		st.Observe(dec)
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	if got, want := st.Records(), 3; got != want {
+		t.Errorf("Records: got %d, want %d", got, want)
+	}
+	if got, want := st.Union(), []string{"a", "b", "c", "user_id"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Union: got %q, want %q", got, want)
+	}
+	if got, want := st.Intersection(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Intersection: got %q, want %q", got, want)
+	}
+	if got, want := st.Inconsistent(), []string{"c", "user_id"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Inconsistent: got %q, want %q", got, want)
+	}
+}
+
+func TestSchemaTracker_empty(t *testing.T) {
+	var st SchemaTracker
+	if got := st.Union(); got != nil {
+		t.Errorf("Union: got %q, want nil", got)
+	}
+	if got := st.Inconsistent(); got != nil {
+		t.Errorf("Inconsistent: got %q, want nil", got)
+	}
+}
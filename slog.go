@@ -0,0 +1,184 @@
+//go:build go1.21
+
+package logfmt
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// HandlerOptions are options for a Handler returned by NewHandler. A zero
+// HandlerOptions consists entirely of default values.
+type HandlerOptions struct {
+	// Level reports the minimum record level that will be logged. If nil,
+	// the handler logs records at slog.LevelInfo or above.
+	Level slog.Leveler
+
+	// ReplaceAttr is called to rewrite each non-group attribute before it is
+	// logged, as described for slog.HandlerOptions. It is also called for
+	// the built-in time, level, and message attributes, with nil groups.
+	ReplaceAttr func(groups []string, a slog.Attr) slog.Attr
+
+	// TimeKey is the key of the record's time. If empty, slog.TimeKey is
+	// used.
+	TimeKey string
+
+	// LevelKey is the key of the record's level. If empty, slog.LevelKey is
+	// used.
+	LevelKey string
+}
+
+// NewHandler returns a slog.Handler that writes each record to w as a line
+// of logfmt, using an Encoder. The record's time, level, and message are
+// written first, followed by its attributes. Attributes within groups, and
+// attributes whose values are flattened by the Encoder, such as structs and
+// maps, are written with dotted keys. Each record is written with a single
+// call to w.Write, and writes are serialized so that the handler, and those
+// derived from it, may be used concurrently. If opts is nil the default
+// options are used.
+func NewHandler(w io.Writer, opts *HandlerOptions) slog.Handler {
+	h := &handler{w: w, mu: &sync.Mutex{}}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.TimeKey == "" {
+		h.opts.TimeKey = slog.TimeKey
+	}
+	if h.opts.LevelKey == "" {
+		h.opts.LevelKey = slog.LevelKey
+	}
+	return h
+}
+
+type handler struct {
+	opts   HandlerOptions
+	w      io.Writer
+	mu     *sync.Mutex
+	groups []string
+	attrs  []boundAttr
+}
+
+// A boundAttr is an attribute added by WithAttrs, along with the groups that
+// were open when it was added.
+type boundAttr struct {
+	groups []string
+	attr   slog.Attr
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.attrs = make([]boundAttr, len(h.attrs), len(h.attrs)+len(attrs))
+	copy(h2.attrs, h.attrs)
+	for _, a := range attrs {
+		h2.attrs = append(h2.attrs, boundAttr{groups: h.groups, attr: a})
+	}
+	return &h2
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+	return &h2
+}
+
+func (h *handler) Handle(_ context.Context, r slog.Record) error {
+	buf := handlerBufPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		handlerBufPool.Put(buf)
+	}()
+	enc := NewEncoder(buf)
+
+	if !r.Time.IsZero() {
+		if err := h.encodeAttr(enc, nil, slog.Time(h.opts.TimeKey, r.Time)); err != nil {
+			return err
+		}
+	}
+	if err := h.encodeAttr(enc, nil, slog.Any(h.opts.LevelKey, r.Level)); err != nil {
+		return err
+	}
+	if err := h.encodeAttr(enc, nil, slog.String(slog.MessageKey, r.Message)); err != nil {
+		return err
+	}
+	for _, ba := range h.attrs {
+		if err := h.encodeAttr(enc, ba.groups, ba.attr); err != nil {
+			return err
+		}
+	}
+	var err error
+	r.Attrs(func(a slog.Attr) bool {
+		err = h.encodeAttr(enc, h.groups, a)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := enc.EndRecord(); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err = h.w.Write(buf.Bytes())
+	return err
+}
+
+var handlerBufPool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+// encodeAttr encodes a, within groups, to enc. Groups are flattened into
+// dotted keys, and empty attributes and groups are omitted.
+func (h *handler) encodeAttr(enc *Encoder, groups []string, a slog.Attr) error {
+	a.Value = a.Value.Resolve()
+	if h.opts.ReplaceAttr != nil && a.Value.Kind() != slog.KindGroup {
+		a = h.opts.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+	if a.Equal(slog.Attr{}) {
+		return nil
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			groups = append(groups[:len(groups):len(groups)], a.Key)
+		}
+		for _, ga := range a.Value.Group() {
+			if err := h.encodeAttr(enc, groups, ga); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	key := a.Key
+	if len(groups) > 0 {
+		var b bytes.Buffer
+		for _, g := range groups {
+			b.WriteString(g)
+			b.WriteByte('.')
+		}
+		b.WriteString(key)
+		key = b.String()
+	}
+	return enc.EncodeKeyvals(key, a.Value.Any())
+}
@@ -0,0 +1,93 @@
+//go:build go1.21
+
+package logfmt_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/slogtest"
+	"time"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestHandlerSlogtest(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := logfmt.NewHandler(buf, nil)
+
+	results := func() []map[string]any {
+		var ms []map[string]any
+		dec := logfmt.NewDecoder(bytes.NewReader(buf.Bytes()))
+		for dec.ScanRecord() {
+			m := map[string]any{}
+			for dec.ScanKeyval() {
+				// Rebuild groups from dotted keys.
+				path := strings.Split(string(dec.Key()), ".")
+				cur := m
+				for _, g := range path[:len(path)-1] {
+					sub, ok := cur[g].(map[string]any)
+					if !ok {
+						sub = map[string]any{}
+						cur[g] = sub
+					}
+					cur = sub
+				}
+				cur[path[len(path)-1]] = string(dec.Value())
+			}
+			ms = append(ms, m)
+		}
+		if err := dec.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return ms
+	}
+
+	if err := slogtest.TestHandler(h, results); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := logfmt.NewHandler(buf, &logfmt.HandlerOptions{
+		Level:    slog.LevelDebug,
+		TimeKey:  "ts",
+		LevelKey: "lvl",
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == "secret" {
+				return slog.String(a.Key, "REDACTED")
+			}
+			if a.Key == "drop" && len(groups) == 1 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	logger := slog.New(h).With("svc", "api").WithGroup("req")
+
+	at := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	r := slog.NewRecord(at, slog.LevelDebug, "hello world", 0)
+	r.AddAttrs(
+		slog.Int("status", 200),
+		slog.String("secret", "hunter2"),
+		slog.String("drop", "x"),
+		slog.Group("hdr", slog.String("ua", "curl/8"), slog.Group("empty")),
+		slog.Any("labels", map[string]string{"b": "2", "a": "1"}),
+		slog.Duration("took", 1500*time.Millisecond),
+	)
+	if err := logger.Handler().Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `ts=2009-11-10T23:00:00Z lvl=DEBUG msg="hello world" svc=api req.status=200 req.secret=REDACTED req.hdr.ua=curl/8 req.labels.a=1 req.labels.b=2 req.took=1.5s` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
+	}
+
+	if h.Enabled(context.Background(), slog.LevelDebug-1) {
+		t.Error("Enabled below minimum level: got true")
+	}
+}
@@ -0,0 +1,79 @@
+package logfmt
+
+import "context"
+
+// A Record is the key/value pairs of a decoded record, in order.
+type Record []KeyValue
+
+// Stream decodes the remaining records in a new goroutine and sends each on
+// the returned channel, for consumption by other goroutines. The keys and
+// values of each Record are copied, so they remain valid and may be used
+// concurrently with further decoding. The channel is closed when decoding
+// stops at the end of the input or on an error, which is then reported by
+// Err. A record that contains a syntax error is not sent, unless lenient
+// decoding is enabled.
+//
+// Decoding also stops if ctx is done, in which case Err returns ctx.Err().
+// A read from the underlying reader that blocks is not interrupted, so ctx is
+// checked between records. The caller must not use dec, other than to call
+// Err after the channel is closed, until then.
+func (dec *Decoder) Stream(ctx context.Context) <-chan Record {
+	ch := make(chan Record)
+	go func() {
+		defer close(ch)
+		for {
+			if err := ctx.Err(); err != nil {
+				dec.err = err
+				return
+			}
+			if !dec.ScanRecord() {
+				return
+			}
+			rec := dec.copyRecord()
+			if dec.err != nil {
+				return
+			}
+			select {
+			case ch <- rec:
+			case <-ctx.Done():
+				dec.err = ctx.Err()
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// copyRecord consumes the remaining pairs of the current record and returns
+// a copy of them, backed by a single slice.
+func (dec *Decoder) copyRecord() Record {
+	var (
+		buf  []byte
+		ends []int // end of each key and value in buf, -1 for a nil value
+	)
+	for dec.ScanKeyval() {
+		buf = append(buf, dec.key...)
+		ends = append(ends, len(buf))
+		if dec.value == nil {
+			ends = append(ends, -1)
+			continue
+		}
+		buf = append(buf, dec.value...)
+		ends = append(ends, len(buf))
+	}
+	if len(ends) == 0 {
+		return nil
+	}
+	rec := make(Record, len(ends)/2)
+	start := 0
+	for i := range rec {
+		end := ends[2*i]
+		rec[i].Key = buf[start:end:end]
+		start = end
+		if end = ends[2*i+1]; end >= 0 {
+			rec[i].Value = buf[start:end:end]
+			start = end
+		}
+	}
+	return rec
+}
@@ -0,0 +1,48 @@
+package logfmt_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestDecoderStream(t *testing.T) {
+	dec := logfmt.NewDecoder(strings.NewReader("a=1 b=\"x y\" c\n\nd=4\ne=\"5"))
+	var got []logfmt.Record
+	for rec := range dec.Stream(context.Background()) {
+		got = append(got, rec)
+	}
+	want := []logfmt.Record{
+		{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("x y")}, {Key: []byte("c")}},
+		nil,
+		{{Key: []byte("d"), Value: []byte("4")}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	wantErr := &logfmt.SyntaxError{Msg: "unterminated quoted value", Line: 4, Pos: 5, Err: logfmt.ErrUnterminatedQuote}
+	if err := dec.Err(); !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("got error %v, want %v", err, wantErr)
+	}
+}
+
+func TestDecoderStreamCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dec := logfmt.NewDecoder(strings.NewReader(strings.Repeat("a=1\n", 100)))
+	ch := dec.Stream(ctx)
+	<-ch
+	cancel()
+	n := 0
+	for range ch {
+		n++
+	}
+	if n > 1 {
+		t.Errorf("got %d records after cancel, want at most 1", n)
+	}
+	if err := dec.Err(); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,47 @@
+package logfmt
+
+import (
+	"io"
+	"sync"
+)
+
+// NewSyncEncoder returns a new encoder that writes to w and may be shared by
+// multiple goroutines. Its methods that write to the stream, including
+// EncodeKeyval, EncodeKeyvals, EndRecord, and Reset, are guarded by a mutex,
+// so that concurrent calls do not corrupt its state or interleave within a
+// pair. Pairs written by separate calls from different goroutines may still
+// be interleaved within a record; use WriteRecord to write each record
+// atomically. Its configuration must not be changed once it is shared.
+func NewSyncEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:  w,
+		mu: &sync.Mutex{},
+	}
+}
+
+// WriteRecord writes keyvals, a variadic sequence of alternating keys and
+// values, as by EncodeKeyvals, and ends the record as by EndRecord. For an
+// encoder returned by NewSyncEncoder it does so while holding the encoder's
+// mutex, so that the record is not interleaved with pairs written by other
+// goroutines. Any pairs already written to the current record are part of
+// the record. If encoding fails the record is not ended.
+func (enc *Encoder) WriteRecord(keyvals ...interface{}) error {
+	enc.lock()
+	defer enc.unlock()
+	if err := enc.encodeKeyvals(keyvals); err != nil {
+		return err
+	}
+	return enc.endRecord()
+}
+
+func (enc *Encoder) lock() {
+	if enc.mu != nil {
+		enc.mu.Lock()
+	}
+}
+
+func (enc *Encoder) unlock() {
+	if enc.mu != nil {
+		enc.mu.Unlock()
+	}
+}
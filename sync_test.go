@@ -0,0 +1,98 @@
+package logfmt_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestSyncEncoderWriteRecord(t *testing.T) {
+	var buf bytes.Buffer
+	enc := logfmt.NewSyncEncoder(&buf)
+
+	const goroutines, records = 8, 100
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < records; i++ {
+				if err := enc.WriteRecord("g", g, "i", i, "msg", "hello world"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if got, want := len(lines), goroutines*records; got != want {
+		t.Fatalf("got %d records, want %d", got, want)
+	}
+	seen := map[string]bool{}
+	for _, line := range lines {
+		var g, i int
+		if n, err := fmt.Sscanf(line, `g=%d i=%d msg="hello world"`, &g, &i); n != 2 || err != nil {
+			t.Fatalf("malformed record %q", line)
+		}
+		seen[line] = true
+	}
+	if len(seen) != goroutines*records {
+		t.Errorf("got %d distinct records, want %d", len(seen), goroutines*records)
+	}
+}
+
+func TestSyncEncoderConcurrentMethods(t *testing.T) {
+	var buf bytes.Buffer
+	enc := logfmt.NewSyncEncoder(&buf)
+	enc.ElapsedKey = "took"
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				enc.StartRecord()
+				enc.EncodeKeyval("a", i)
+				enc.EncodeKeyvals("b", i, "c", i)
+				enc.EncodeMap(map[string]interface{}{"d": i})
+				enc.EndRecord()
+				if i%10 == 0 {
+					enc.Reset()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	clone := enc.Clone(nil)
+	if err := clone.WriteRecord("x", 1); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\nx=1 took=") {
+		t.Errorf("clone record missing from %q", buf.String())
+	}
+}
+
+func TestEncoderWriteRecord(t *testing.T) {
+	var buf bytes.Buffer
+	enc := logfmt.NewEncoder(&buf)
+	if err := enc.EncodeKeyval("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteRecord("b", 2, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteRecord("d", 3); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "a=1 b=2 c=null\nd=3\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package logfmt
+
+// A TokenKind identifies the kind of token described by a TokenEvent.
+type TokenKind int
+
+// Kinds of token reported to a token handler.
+const (
+	// KeyToken is a key. A quoted key includes its quotes.
+	KeyToken TokenKind = iota
+
+	// SeparatorToken is the delimiter between a key and its value, or the
+	// run of bytes, such as spaces, that separates a pair from the
+	// preceding one.
+	SeparatorToken
+
+	// ValueToken is a value, which may be empty. A quoted value includes its
+	// quotes and escape sequences.
+	ValueToken
+
+	// ErrorToken is the position of a syntax error.
+	ErrorToken
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case KeyToken:
+		return "key"
+	case SeparatorToken:
+		return "separator"
+	case ValueToken:
+		return "value"
+	case ErrorToken:
+		return "error"
+	}
+	return "unknown"
+}
+
+// A TokenEvent describes a token of the current record found by the Decoder.
+type TokenEvent struct {
+	Kind TokenKind
+
+	// Start and End are the byte offsets of the token within the line
+	// returned by LineBytes. For an ErrorToken they span the byte at which
+	// the error was found, or are both the length of the line if the error
+	// was found at its end.
+	Start, End int
+
+	// Quoted reports whether a KeyToken or ValueToken is quoted.
+	Quoted bool
+
+	// Err is the *SyntaxError of an ErrorToken, and nil for other kinds.
+	Err error
+}
+
+// SetTokenHandler sets a function that ScanKeyval calls with the tokens of
+// each pair it scans, in order, for tools such as syntax highlighters and
+// linters that need the location of each token rather than the decoded
+// keys and values. For a pair the tokens are the separator between it and
+// the preceding pair of the record, if any, its key, and the key/value
+// delimiter and the value if it has a delimiter. In a record without errors
+// they cover the record from its first key to its last value. A syntax error
+// is reported as an ErrorToken, even in lenient mode, and no separator is
+// reported between it and the next pair. Pairs whose tokens
+// are valid but that are rejected, for example as duplicates, produce only
+// an ErrorToken. Passing nil removes the function.
+func (dec *Decoder) SetTokenHandler(fn func(ev TokenEvent)) {
+	dec.onToken = fn
+}
+
+// emitPair reports the tokens of the pair most recently scanned.
+func (dec *Decoder) emitPair() {
+	start, end := dec.KeyOffset()
+	if dec.tokenEnd >= 0 && dec.tokenEnd < start {
+		dec.onToken(TokenEvent{Kind: SeparatorToken, Start: dec.tokenEnd, End: start})
+	}
+	dec.onToken(TokenEvent{Kind: KeyToken, Start: start, End: end, Quoted: dec.line[start] == '"'})
+	dec.tokenEnd = end
+	if dec.keyEnd < 0 {
+		return
+	}
+	dec.onToken(TokenEvent{Kind: SeparatorToken, Start: dec.keyEnd, End: dec.keyEnd + 1})
+	start, end = dec.ValueOffset()
+	dec.onToken(TokenEvent{Kind: ValueToken, Start: start, End: end, Quoted: end > start && (dec.line[start] == '"' || dec.sqValues && dec.line[start] == '\'')})
+	dec.tokenEnd = end
+}
+
+// emitError reports the syntax error se.
+func (dec *Decoder) emitError(se *SyntaxError) {
+	start := se.Pos - 1
+	end := start + 1
+	if end > len(dec.line) {
+		start, end = len(dec.line), len(dec.line)
+	}
+	dec.onToken(TokenEvent{Kind: ErrorToken, Start: start, End: end, Err: se})
+	dec.tokenEnd = -1
+}
@@ -0,0 +1,107 @@
+package logfmt_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestDecoderSetTokenHandler(t *testing.T) {
+	data := "a=1 b=\"x y\" c d= e=\"\"\nf=2 g=\"unterminated"
+	dec := logfmt.NewDecoder(strings.NewReader(data))
+	var got []string
+	dec.SetTokenHandler(func(ev logfmt.TokenEvent) {
+		s := fmt.Sprintf("%v %q", ev.Kind, dec.LineBytes()[ev.Start:ev.End])
+		if ev.Quoted {
+			s += " quoted"
+		}
+		if ev.Err != nil {
+			s += " " + ev.Err.Error()
+		}
+		got = append(got, s)
+	})
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+		}
+	}
+	if !errors.Is(dec.Err(), logfmt.ErrUnterminatedQuote) {
+		t.Errorf("got error %v, want %v", dec.Err(), logfmt.ErrUnterminatedQuote)
+	}
+	// Further calls do not report the error again.
+	dec.ScanKeyval()
+
+	want := []string{
+		`key "a"`, `separator "="`, `value "1"`,
+		`separator " "`, `key "b"`, `separator "="`, `value "\"x y\"" quoted`,
+		`separator " "`, `key "c"`,
+		`separator " "`, `key "d"`, `separator "="`, `value ""`,
+		`separator " "`, `key "e"`, `separator "="`, `value "\"\"" quoted`,
+		`key "f"`, `separator "="`, `value "2"`,
+		`error "" logfmt syntax error at pos 20 on line 2: unterminated quoted value`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDecoderSetTokenHandlerLenient(t *testing.T) {
+	dec := logfmt.NewDecoder(strings.NewReader(`"k"=v a=b=c`))
+	dec.SetAllowQuotedKeys(true)
+	dec.SetLenient(func(*logfmt.SyntaxError) {})
+	var got []logfmt.TokenEvent
+	dec.SetTokenHandler(func(ev logfmt.TokenEvent) {
+		if ev.Err != nil {
+			ev.Err = errors.Unwrap(ev.Err)
+		}
+		got = append(got, ev)
+	})
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+		}
+	}
+	want := []logfmt.TokenEvent{
+		{Kind: logfmt.KeyToken, Start: 0, End: 3, Quoted: true},
+		{Kind: logfmt.SeparatorToken, Start: 3, End: 4},
+		{Kind: logfmt.ValueToken, Start: 4, End: 5},
+		{Kind: logfmt.ErrorToken, Start: 9, End: 10, Err: logfmt.ErrUnexpectedEquals},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecoderSetTokenHandlerOrder(t *testing.T) {
+	dec := logfmt.NewDecoder(strings.NewReader("  a=1 \tb=\"x\"  c\nd=4"))
+	var got []logfmt.TokenEvent
+	dec.SetTokenHandler(func(ev logfmt.TokenEvent) {
+		got = append(got, ev)
+	})
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []logfmt.TokenEvent{
+		{Kind: logfmt.KeyToken, Start: 2, End: 3},
+		{Kind: logfmt.SeparatorToken, Start: 3, End: 4},
+		{Kind: logfmt.ValueToken, Start: 4, End: 5},
+		{Kind: logfmt.SeparatorToken, Start: 5, End: 7},
+		{Kind: logfmt.KeyToken, Start: 7, End: 8},
+		{Kind: logfmt.SeparatorToken, Start: 8, End: 9},
+		{Kind: logfmt.ValueToken, Start: 9, End: 12, Quoted: true},
+		{Kind: logfmt.SeparatorToken, Start: 12, End: 14},
+		{Kind: logfmt.KeyToken, Start: 14, End: 15},
+		{Kind: logfmt.KeyToken, Start: 0, End: 1},
+		{Kind: logfmt.SeparatorToken, Start: 1, End: 2},
+		{Kind: logfmt.ValueToken, Start: 2, End: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,48 @@
+package logfmt
+
+import "io"
+
+// Transform decodes the logfmt records read from r, passes each key/value
+// pair to fn, and writes the pairs returned by fn to w as logfmt, for
+// pipelines that redact values, rename keys, and so on. If fn returns keep
+// false the pair is dropped; otherwise newKey and newValue are encoded in
+// its place. The arguments to fn are only valid until it returns, but fn may
+// return them unchanged. A nil newValue is written as an empty value.
+//
+// Each input record produces one output record, ended by EndRecord, even if
+// all of its pairs are dropped. Transform returns the first error from
+// decoding, which is a *SyntaxError if the input is not valid logfmt, from
+// encoding the pairs returned by fn, or from writing to w. Records that
+// precede the error have been written to w.
+func Transform(w io.Writer, r io.Reader, fn func(key, value []byte) (newKey, newValue []byte, keep bool)) error {
+	dec := NewDecoder(r)
+	enc := NewEncoder(w)
+	enc.SetEmitEmptyRecords(true)
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			key, value, keep := fn(dec.Key(), dec.Value())
+			if !keep {
+				continue
+			}
+			if err := enc.EncodeKeyval(key, emptyIfNil(value)); err != nil {
+				return err
+			}
+		}
+		if err := dec.Err(); err != nil {
+			return err
+		}
+		if err := enc.EndRecord(); err != nil {
+			return err
+		}
+	}
+	return dec.Err()
+}
+
+// emptyIfNil returns value, or an empty slice if value is nil, so that a nil
+// value from a Decoder is encoded as an empty value rather than as null.
+func emptyIfNil(value []byte) []byte {
+	if value == nil {
+		return []byte{}
+	}
+	return value
+}
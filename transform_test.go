@@ -0,0 +1,59 @@
+package logfmt_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func TestTransform(t *testing.T) {
+	in := "level=info user=alice password=hunter2 msg=\"logged in\"\npassword=x\nlvl=warn msg=\"disk low\"\n"
+	var buf bytes.Buffer
+	err := logfmt.Transform(&buf, strings.NewReader(in), func(key, value []byte) ([]byte, []byte, bool) {
+		switch string(key) {
+		case "password":
+			return nil, nil, false
+		case "user":
+			return key, []byte("[redacted]"), true
+		case "lvl":
+			return []byte("level"), value, true
+		}
+		return key, value, true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "level=info user=[redacted] msg=\"logged in\"\n\nlevel=warn msg=\"disk low\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	keep := func(key, value []byte) ([]byte, []byte, bool) { return key, value, true }
+	err = logfmt.Transform(&buf, strings.NewReader("a=1\nb=\"2\nc=3\n"), keep)
+	wantErr := &logfmt.SyntaxError{Msg: "unterminated quoted value", Line: 2, Pos: 5, Err: logfmt.ErrUnterminatedQuote}
+	if !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("got error %v, want %v", err, wantErr)
+	}
+	if got, want := buf.String(), "a=1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	if err := logfmt.Transform(&buf, strings.NewReader("a= b c=\"\"\n"), keep); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "a= b= c=\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	empty := func(key, value []byte) ([]byte, []byte, bool) { return []byte{}, value, true }
+	err = logfmt.Transform(&buf, strings.NewReader("a=1\n"), empty)
+	if !errors.Is(err, logfmt.ErrInvalidKey) {
+		t.Errorf("got error %v, want %v", err, logfmt.ErrInvalidKey)
+	}
+}
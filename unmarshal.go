@@ -0,0 +1,161 @@
+package logfmt
+
+import (
+	"encoding"
+	"errors"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Unmarshal decodes data, which must hold a single logfmt record, into the
+// struct pointed to by v. A trailing newline is permitted.
+//
+// Keys are matched to exported fields by the name given in the field's
+// logfmt tag or, if there is none, by the field name. Fields tagged
+// logfmt:"-" are ignored, as are keys that match no field. Values are
+// assigned as follows:
+//
+//   - Fields whose address implements encoding.TextUnmarshaler, including
+//     time.Time which expects RFC 3339, receive the raw value.
+//   - String fields receive the value.
+//   - Bool, integer, and floating point fields are parsed with package
+//     strconv. A key without a value sets a bool field to true.
+//   - time.Duration fields are parsed with time.ParseDuration.
+//   - Pointer fields are allocated and the value assigned to their element.
+//
+// A value that cannot be assigned to its field causes an *UnmarshalError.
+// Fields with no matching key are left unchanged.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidUnmarshalTarget
+	}
+	line, err := singleRecord(data)
+	if err != nil {
+		return err
+	}
+
+	sv := rv.Elem()
+	fields := unmarshalFields(sv.Type())
+	var dec Decoder
+	dec.startRecord(line)
+	for dec.ScanKeyval() {
+		i, ok := fields[string(dec.key)]
+		if !ok {
+			continue
+		}
+		f := sv.Field(i)
+		if err := setField(f, dec.value); err != nil {
+			return &UnmarshalError{
+				Key:   string(dec.key),
+				Field: sv.Type().Field(i).Name,
+				Type:  f.Type(),
+				Err:   err,
+			}
+		}
+	}
+	return dec.err
+}
+
+// ErrInvalidUnmarshalTarget is returned by Unmarshal if its target is not a
+// non-nil pointer to a struct.
+var ErrInvalidUnmarshalTarget = errors.New("unmarshal target must be a non-nil pointer to a struct")
+
+// UnmarshalError represents a value that could not be assigned to the struct
+// field matching its key.
+type UnmarshalError struct {
+	Key   string
+	Field string
+	Type  reflect.Type
+	Err   error
+}
+
+func (e *UnmarshalError) Error() string {
+	return "error unmarshaling key " + strconv.Quote(e.Key) + " into field " + e.Field + " of type " + e.Type.String() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *UnmarshalError) Unwrap() error {
+	return e.Err
+}
+
+// unmarshalFields returns the index of the field of t matched by each key.
+func unmarshalFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Tag.Get("logfmt")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := fields[name]; !ok {
+			fields[name] = i
+		}
+	}
+	return fields
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setField(f reflect.Value, value []byte) error {
+	if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText(value)
+	}
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(string(value))
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(string(value))
+	case reflect.Bool:
+		if value == nil {
+			f.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(string(value))
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(value), 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(string(value), 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(string(value), f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Ptr:
+		p := reflect.New(f.Type().Elem())
+		if err := setField(p.Elem(), value); err != nil {
+			return err
+		}
+		f.Set(p)
+	default:
+		return ErrUnsupportedValueType
+	}
+	return nil
+}
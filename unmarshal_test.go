@@ -0,0 +1,107 @@
+package logfmt_test
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+type unmarshalData struct {
+	Msg      string `logfmt:"msg"`
+	Level    string
+	Count    int     `logfmt:"n"`
+	Small    int8    `logfmt:"small"`
+	Size     uint64  `logfmt:"size"`
+	Ratio    float32 `logfmt:"ratio"`
+	Debug    bool    `logfmt:"debug"`
+	OK       bool    `logfmt:"ok"`
+	At       time.Time
+	Took     time.Duration `logfmt:"took"`
+	Addr     net.IP        `logfmt:"addr"`
+	Ptr      *int          `logfmt:"ptr"`
+	Skipped  string        `logfmt:"-"`
+	internal string
+}
+
+func TestUnmarshal(t *testing.T) {
+	data := []byte(`msg="hello world" Level=info n=-42 size=18446744073709551615 ratio=0.5 debug ok=false At=2009-11-10T23:00:00Z took=1.5s addr=10.0.0.1 ptr=7 Skipped=x internal=y unknown=z` + "\n")
+
+	var got unmarshalData
+	got.Msg = "overwritten"
+	got.Small = 3
+	if err := logfmt.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	seven := 7
+	want := unmarshalData{
+		Msg:   "hello world",
+		Level: "info",
+		Count: -42,
+		Small: 3,
+		Size:  18446744073709551615,
+		Ratio: 0.5,
+		Debug: true,
+		At:    time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC),
+		Took:  1500 * time.Millisecond,
+		Addr:  net.ParseIP("10.0.0.1"),
+		Ptr:   &seven,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var d unmarshalData
+	tests := []struct {
+		in    string
+		v     interface{}
+		key   string
+		field string
+		err   error
+	}{
+		{in: "n=abc", v: &d, key: "n", field: "Count", err: strconv.ErrSyntax},
+		{in: "small=300", v: &d, key: "small", field: "Small", err: strconv.ErrRange},
+		{in: "ok=maybe", v: &d, key: "ok", field: "OK", err: strconv.ErrSyntax},
+		{in: "At=yesterday", v: &d, key: "At", field: "At"},
+		{in: "took=5", v: &d, key: "took", field: "Took"},
+		{in: "addr=x", v: &d, key: "addr", field: "Addr"},
+		{in: "V=1", v: &struct{ V []int }{}, key: "V", field: "V", err: logfmt.ErrUnsupportedValueType},
+		{in: "a=1", v: d, err: logfmt.ErrInvalidUnmarshalTarget},
+		{in: "a=1", v: (*unmarshalData)(nil), err: logfmt.ErrInvalidUnmarshalTarget},
+		{in: "a=1", v: new(int), err: logfmt.ErrInvalidUnmarshalTarget},
+		{in: "a=1\nb=2", v: &d, err: logfmt.ErrMultipleRecords},
+	}
+	for _, test := range tests {
+		err := logfmt.Unmarshal([]byte(test.in), test.v)
+		if test.field == "" {
+			if err != test.err {
+				t.Errorf("%q: got error %v, want %v", test.in, err, test.err)
+			}
+			continue
+		}
+		var ue *logfmt.UnmarshalError
+		if !errors.As(err, &ue) {
+			t.Errorf("%q: got error %v, want *UnmarshalError", test.in, err)
+			continue
+		}
+		if ue.Key != test.key || ue.Field != test.field {
+			t.Errorf("%q: got key %q field %q, want key %q field %q", test.in, ue.Key, ue.Field, test.key, test.field)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%q: got error %v, want it to wrap %v", test.in, err, test.err)
+		}
+	}
+
+	err := logfmt.Unmarshal([]byte("n=1 x=\"unterminated"), &d)
+	want := &logfmt.SyntaxError{Msg: "unterminated quoted value", Line: 1, Pos: 20, Err: logfmt.ErrUnterminatedQuote}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
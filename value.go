@@ -0,0 +1,158 @@
+package logfmt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Special layouts for Decoder.TimeKeys that parse times given as a number of
+// seconds or milliseconds since the Unix epoch.
+const (
+	LayoutUnix      = "unix"
+	LayoutUnixMilli = "unixmilli"
+)
+
+// ValueTime parses the most recent value found by a call to ScanKeyval as a
+// time. The layout used is the one given for the key in TimeKeys, or
+// time.RFC3339 if there is none. The layouts LayoutUnix and LayoutUnixMilli
+// parse integer seconds, with an optional fractional part, and integer
+// milliseconds since the Unix epoch. Times given as a number of seconds or
+// milliseconds are returned in UTC.
+func (dec *Decoder) ValueTime() (time.Time, error) {
+	layout := dec.TimeKeys[string(dec.key)]
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	value := string(dec.value)
+
+	var (
+		t   time.Time
+		err error
+	)
+	switch layout {
+	case LayoutUnix:
+		t, err = parseUnix(value)
+	case LayoutUnixMilli:
+		var ms int64
+		ms, err = strconv.ParseInt(value, 10, 64)
+		t = time.Unix(ms/1e3, ms%1e3*1e6).UTC()
+	default:
+		t, err = time.Parse(layout, value)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q for key %q: %w", value, dec.key, err)
+	}
+	return t, nil
+}
+
+// ErrEmptyValue is wrapped by a ValueError returned by the typed value
+// accessors of a Decoder when the value is empty or missing.
+var ErrEmptyValue = errors.New("empty value")
+
+// A ValueError is returned by the typed value accessors of a Decoder, such as
+// ValueInt, when the current value cannot be parsed.
+type ValueError struct {
+	Key   string
+	Value string
+	Line  int // 1-based line number of the record
+	Pos   int // 1-based byte position of the value within the line
+	Err   error
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("logfmt: invalid value %q for key %q at pos %d on line %d: %v", e.Value, e.Key, e.Pos, e.Line, e.Err)
+}
+
+// Unwrap returns e.Err, which is ErrEmptyValue or a *strconv.NumError.
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+func (dec *Decoder) valueError(err error) error {
+	start, _ := dec.ValueOffset()
+	if ne, ok := err.(*strconv.NumError); ok {
+		err = ne.Err
+	}
+	return &ValueError{
+		Key:   string(dec.key),
+		Value: string(dec.value),
+		Line:  dec.lineNum,
+		Pos:   start + 1,
+		Err:   err,
+	}
+}
+
+// ValueInt parses the most recent value found by a call to ScanKeyval as a
+// base 10 signed 64-bit integer. If the value is empty or cannot be parsed,
+// the error is a *ValueError wrapping ErrEmptyValue, strconv.ErrSyntax, or
+// strconv.ErrRange.
+func (dec *Decoder) ValueInt() (int64, error) {
+	if len(dec.value) == 0 {
+		return 0, dec.valueError(ErrEmptyValue)
+	}
+	n, err := strconv.ParseInt(string(dec.value), 10, 64)
+	if err != nil {
+		return 0, dec.valueError(err)
+	}
+	return n, nil
+}
+
+// ValueFloat parses the most recent value found by a call to ScanKeyval as a
+// 64-bit floating point number, as by strconv.ParseFloat. Errors are
+// reported as by ValueInt.
+func (dec *Decoder) ValueFloat() (float64, error) {
+	if len(dec.value) == 0 {
+		return 0, dec.valueError(ErrEmptyValue)
+	}
+	f, err := strconv.ParseFloat(string(dec.value), 64)
+	if err != nil {
+		return 0, dec.valueError(err)
+	}
+	return f, nil
+}
+
+// ValueBool parses the most recent value found by a call to ScanKeyval as a
+// boolean, accepting the values accepted by strconv.ParseBool. Errors are
+// reported as by ValueInt.
+func (dec *Decoder) ValueBool() (bool, error) {
+	if len(dec.value) == 0 {
+		return false, dec.valueError(ErrEmptyValue)
+	}
+	b, err := strconv.ParseBool(string(dec.value))
+	if err != nil {
+		return false, dec.valueError(err)
+	}
+	return b, nil
+}
+
+func parseUnix(s string) (time.Time, error) {
+	sec, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		sec, frac = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		f, err := strconv.ParseUint(frac, 10, 32)
+		if err != nil {
+			return time.Time{}, &strconv.NumError{Func: "ParseUnix", Num: s, Err: strconv.ErrSyntax}
+		}
+		nsec = int64(f)
+		for i := len(frac); i < 9; i++ {
+			nsec *= 10
+		}
+		if strings.HasPrefix(sec, "-") {
+			nsec = -nsec
+		}
+	}
+	return time.Unix(n, nsec).UTC(), nil
+}
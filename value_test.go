@@ -0,0 +1,143 @@
+package logfmt
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecoder_ValueTime(t *testing.T) {
+	in := `ts=2024-01-02T03:04:05Z tsn=2024-01-02T03:04:05.5+01:00 ` +
+		`sec=1704164645 fsec=1704164645.25 ms=1704164645250 day=2024-01-02 kitchen=3:04PM`
+	want := map[string]time.Time{
+		"ts":      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		"tsn":     time.Date(2024, 1, 2, 2, 4, 5, 5e8, time.UTC),
+		"sec":     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		"fsec":    time.Date(2024, 1, 2, 3, 4, 5, 25e7, time.UTC),
+		"ms":      time.Date(2024, 1, 2, 3, 4, 5, 25e7, time.UTC),
+		"day":     time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		"kitchen": time.Date(0, 1, 1, 15, 4, 0, 0, time.UTC),
+	}
+
+	dec := NewDecoder(strings.NewReader(in))
+	dec.TimeKeys = map[string]string{
+		"sec":     LayoutUnix,
+		"fsec":    LayoutUnix,
+		"ms":      LayoutUnixMilli,
+		"day":     "2006-01-02",
+		"kitchen": time.Kitchen,
+	}
+	n := 0
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			got, err := dec.ValueTime()
+			if err != nil {
+				t.Errorf("%s: got error: %v", dec.Key(), err)
+				continue
+			}
+			if w := want[string(dec.Key())]; !got.Equal(w) {
+				t.Errorf("%s: got %v, want %v", dec.Key(), got, w)
+			}
+			n++
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(want) {
+		t.Errorf("got %d values, want %d", n, len(want))
+	}
+}
+
+func TestDecoder_ValueTimeError(t *testing.T) {
+	in := `ts=yesterday sec=12x ms=1.5 fsec=1.x`
+	dec := NewDecoder(strings.NewReader(in))
+	dec.TimeKeys = map[string]string{"sec": LayoutUnix, "ms": LayoutUnixMilli, "fsec": LayoutUnix}
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			_, err := dec.ValueTime()
+			if err == nil {
+				t.Errorf("%s: got nil error", dec.Key())
+				continue
+			}
+			if msg := err.Error(); !strings.Contains(msg, string(dec.Key())) || !strings.Contains(msg, string(dec.Value())) {
+				t.Errorf("%s: error %q does not name key and value", dec.Key(), msg)
+			}
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecoder_ValueTyped(t *testing.T) {
+	in := "n=-42 f=1.5e3 b=true big=9223372036854775808 x=abc e= m\nb2=\"0\""
+	type result struct {
+		key  string
+		i    int64
+		f    float64
+		b    bool
+		errs [3]error
+	}
+	var got []result
+	dec := NewDecoder(strings.NewReader(in))
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			r := result{key: string(dec.Key())}
+			r.i, r.errs[0] = dec.ValueInt()
+			r.f, r.errs[1] = dec.ValueFloat()
+			r.b, r.errs[2] = dec.ValueBool()
+			for i, err := range r.errs {
+				switch {
+				case err == nil:
+				case errors.Is(err, ErrEmptyValue):
+					r.errs[i] = ErrEmptyValue
+				case errors.Is(err, strconv.ErrSyntax):
+					r.errs[i] = strconv.ErrSyntax
+				case errors.Is(err, strconv.ErrRange):
+					r.errs[i] = strconv.ErrRange
+				default:
+					t.Errorf("%s: unexpected error %v", r.key, err)
+				}
+			}
+			got = append(got, r)
+		}
+	}
+	if err := dec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	syn, rng, empty := strconv.ErrSyntax, strconv.ErrRange, ErrEmptyValue
+	want := []result{
+		{key: "n", i: -42, f: -42, errs: [3]error{nil, nil, syn}},
+		{key: "f", f: 1500, errs: [3]error{syn, nil, syn}},
+		{key: "b", b: true, errs: [3]error{syn, syn, nil}},
+		{key: "big", f: 9223372036854775808, errs: [3]error{rng, nil, syn}},
+		{key: "x", errs: [3]error{syn, syn, syn}},
+		{key: "e", errs: [3]error{empty, empty, empty}},
+		{key: "m", errs: [3]error{empty, empty, empty}},
+		{key: "b2", b: false, errs: [3]error{nil, nil, nil}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var err error
+	dec = NewDecoder(strings.NewReader("a=1\nb=2 count=12x"))
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			if string(dec.Key()) == "count" {
+				_, err = dec.ValueInt()
+			}
+		}
+	}
+	wantErr := &ValueError{Key: "count", Value: "12x", Line: 2, Pos: 11, Err: strconv.ErrSyntax}
+	if !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("got error %#v, want %#v", err, wantErr)
+	}
+	if got, want := err.Error(), `logfmt: invalid value "12x" for key "count" at pos 11 on line 2: invalid syntax`; got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+}